@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/config"
+	"document-manager/mailer"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposePasswordReset = "password_reset"
+)
+
+var verifyEmailTokenTTL = time.Hour * 48
+var passwordResetTokenTTL = time.Hour
+
+var errInvalidAccountToken = errors.New("invalid or expired token")
+var messageInvalidAccountToken = "Invalid or expired token"
+
+// accountTokenClaims are the claims of the tokens sent by email. The token ID
+// (jti) points to a models.UserToken row, which makes the token single-use.
+type accountTokenClaims struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Purpose string    `json:"purpose"`
+	jwt.StandardClaims
+}
+
+type EmailBody struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type TokenBody struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type PasswordResetBody struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// appURL returns the base URL of the frontend used to build the links sent by email.
+func appURL() string {
+	return strings.TrimSuffix(config.Get().Server.AppURL, "/")
+}
+
+// formatTTL writes a token lifetime in words, e.g. "48 hours" or "30 minutes".
+func formatTTL(ttl time.Duration) string {
+	value, unit := int(ttl.Minutes()), "minute"
+	if ttl%time.Hour == 0 {
+		value, unit = int(ttl.Hours()), "hour"
+	}
+	if value != 1 {
+		unit += "s"
+	}
+	return strconv.Itoa(value) + " " + unit
+}
+
+// expireAccountTokens marks the unused tokens of the user for purpose as used.
+func expireAccountTokens(db *gorm.DB, userID uuid.UUID, purpose string) error {
+	return db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// issueAccountToken stores a new token for the user, sent to its current
+// email, invalidating the unused tokens previously issued for the same
+// purpose, and returns it signed.
+func issueAccountToken(db *gorm.DB, user models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	record := models.UserToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := expireAccountTokens(tx, user.ID, purpose); err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims := &accountTokenClaims{
+		UserID:  user.ID,
+		Purpose: purpose,
+		StandardClaims: jwt.StandardClaims{
+			Id:        record.ID.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: record.ExpiresAt.Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey())
+}
+
+// consumeAccountToken validates a token for the given purpose and marks it as used.
+func consumeAccountToken(db *gorm.DB, tokenString string, purpose string) (*models.UserToken, error) {
+	claims := &accountTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errInvalidAccountToken
+		}
+		return jwtKey(), nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != purpose {
+		return nil, errInvalidAccountToken
+	}
+
+	tokenID, err := uuid.Parse(claims.Id)
+	if err != nil {
+		return nil, errInvalidAccountToken
+	}
+
+	var record models.UserToken
+	if err := db.Where("id = ? AND purpose = ?", tokenID, purpose).First(&record).Error; err != nil {
+		return nil, errInvalidAccountToken
+	}
+	if record.UserID != claims.UserID || record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, errInvalidAccountToken
+	}
+
+	// the condition on used_at keeps two concurrent requests from both using the token
+	result := db.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, errInvalidAccountToken
+	}
+
+	return &record, nil
+}
+
+// sendAccountEmail issues a token for the user and mails the link built from path.
+func sendAccountEmail(db *gorm.DB, user models.User, purpose string, ttl time.Duration, template string, path string) error {
+	token, err := issueAccountToken(db, user, purpose, ttl)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.NewMessage(user.Email, template, map[string]string{
+		"Name":      user.Name,
+		"Link":      appURL() + path + "?token=" + token,
+		"ExpiresIn": formatTTL(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.GetMailer().Send(msg)
+}
+
+func sendVerificationEmail(db *gorm.DB, user models.User) error {
+	return sendAccountEmail(db, user, tokenPurposeVerifyEmail, verifyEmailTokenTTL, mailer.TemplateVerifyEmail, "/verify-email")
+}
+
+func sendPasswordResetEmail(db *gorm.DB, user models.User) error {
+	return sendAccountEmail(db, user, tokenPurposePasswordReset, passwordResetTokenTTL, mailer.TemplatePasswordReset, "/reset-password")
+}
+
+// RequestEmailVerificationHandler sends a new verification email to the logged user.
+// @Summary Request email verification
+// @Description Send an email verification link to the address of the logged user
+// @ID request-email-verification
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /verify-email/request [post]
+func RequestEmailVerificationHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, claims.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(db, user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending verification email", "user", user.ID, "error", err)
+		respondInternalError(c, "Error sending verification email", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// ConfirmEmailVerificationHandler marks the email of a user as verified.
+// @Summary Confirm email verification
+// @Description Confirm the email address of a user with the token sent to it by email. The token does not verify an address the user changed to since
+// @ID confirm-email-verification
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param token body TokenBody true "Verification token"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /verify-email/confirm [post]
+func ConfirmEmailVerificationHandler(c *gin.Context) {
+	var body TokenBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	record, err := consumeAccountToken(db, body.Token, tokenPurposeVerifyEmail)
+	if err != nil {
+		respondError(c, responses.CodeInvalidLink, messageInvalidAccountToken)
+		return
+	}
+
+	// the link only verifies the address it was sent to, which the user may have changed since
+	result := db.Model(&models.User{}).Where("id = ? AND email = ?", record.UserID, record.Email).Update("email_verified", true)
+	if result.Error != nil {
+		respondInternalError(c, "Error verifying email", result.Error)
+		return
+	}
+	if result.RowsAffected != 1 {
+		respondError(c, responses.CodeInvalidLink, messageInvalidAccountToken)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// RequestPasswordResetHandler sends a password reset email.
+// @Summary Request password reset
+// @Description Send a password reset link to the email, if it belongs to a user
+// @ID request-password-reset
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param email body EmailBody true "User email"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Router /password-reset/request [post]
+func RequestPasswordResetHandler(c *gin.Context) {
+	var body EmailBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	// the response is the same whether the email exists or not, so this
+	// endpoint cannot be used to discover registered addresses
+	message := "If the email belongs to a user, a password reset link has been sent"
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where("email = ?", body.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": message})
+		return
+	}
+
+	if err := sendPasswordResetEmail(db, user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending password reset email", "user", user.ID, "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// ConfirmPasswordResetHandler sets a new password with a password reset token.
+// @Summary Confirm password reset
+// @Description Set a new password with the token sent by email
+// @ID confirm-password-reset
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body PasswordResetBody true "Reset token and new password"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /password-reset/confirm [post]
+func ConfirmPasswordResetHandler(c *gin.Context) {
+	var body PasswordResetBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	// a refused password rolls the use of the token back, to try another one
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		record, err := consumeAccountToken(tx, body.Token, tokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where(searchById, record.UserID).First(&user).Error; err != nil {
+			return errInvalidAccountToken
+		}
+
+		hashedPassword, err := hashNewPassword(tx, user, body.Password)
+		if err != nil {
+			return err
+		}
+
+		// the link was received by email, which also proves the address
+		user.EmailVerified = true
+		if err := setUserPassword(tx, &user, hashedPassword); err != nil {
+			return err
+		}
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidAccountToken):
+			respondError(c, responses.CodeInvalidLink, messageInvalidAccountToken)
+		case !abortNewPassword(c, err):
+			respondInternalError(c, "Error resetting password", err)
+		}
+		return
+	}
+
+	// whoever knew the old password must not stay logged in
+	if err := revokeUserSessions(db, user.ID); err != nil {
+		respondInternalError(c, "Error revoking user sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
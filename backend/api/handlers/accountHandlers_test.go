@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/api/models"
+	"document-manager/mailer"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// captureMailer keeps the sent messages in memory instead of delivering them.
+type captureMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *captureMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *captureMailer) last() mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		return mailer.Message{}
+	}
+	return m.messages[len(m.messages)-1]
+}
+
+var tokenInLink = regexp.MustCompile(`token=([A-Za-z0-9_\-.]+)`)
+
+func tokenFromMessage(msg mailer.Message) string {
+	match := tokenInLink.FindStringSubmatch(msg.Body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func postJSON(r *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	reqBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	db := runInitDb()
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.DefaultCost)
+	assert.Nil(t, err)
+	testUser := models.User{
+		ID:       uuid.New(),
+		Name:     "Reset User",
+		Email:    "reset@example.com",
+		Password: string(hashedPassword),
+	}
+	db.Create(&testUser)
+	defer db.Unscoped().Delete(&testUser)
+
+	r := gin.Default()
+	r.Use(TenantMiddleware)
+	r.POST("/password-reset/request", RequestPasswordResetHandler)
+	r.POST("/password-reset/confirm", ConfirmPasswordResetHandler)
+
+	// unknown emails get the same answer and no message
+	resp := postJSON(r, "/password-reset/request", EmailBody{Email: "nobody@example.com"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 0, len(capture.messages))
+
+	resp = postJSON(r, "/password-reset/request", EmailBody{Email: testUser.Email})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{testUser.Email}, capture.last().To)
+	token := tokenFromMessage(capture.last())
+	assert.NotEqual(t, "", token)
+
+	resp = postJSON(r, "/password-reset/confirm", PasswordResetBody{Token: token, Password: "new-password"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var existingUser models.User
+	err = db.First(&existingUser, searchById, testUser.ID).Error
+	assert.Nil(t, err)
+	assert.Nil(t, VerifyPassword("new-password", existingUser.Password))
+	assert.True(t, existingUser.EmailVerified)
+
+	// the token can only be used once
+	resp = postJSON(r, "/password-reset/confirm", PasswordResetBody{Token: token, Password: "other-password"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// a token for another purpose is rejected
+	verifyToken, err := issueAccountToken(db, testUser, tokenPurposeVerifyEmail, verifyEmailTokenTTL)
+	assert.Nil(t, err)
+	resp = postJSON(r, "/password-reset/confirm", PasswordResetBody{Token: verifyToken, Password: "other-password"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	db.Where("user_id = ?", testUser.ID).Delete(&models.UserToken{})
+}
+
+func TestPasswordResetTokenReplacedByNewRequest(t *testing.T) {
+	db := runInitDb()
+
+	testUser := models.User{
+		ID:    uuid.New(),
+		Name:  "Reset Twice User",
+		Email: "reset-twice@example.com",
+	}
+	db.Create(&testUser)
+	defer db.Unscoped().Delete(&testUser)
+
+	firstToken, err := issueAccountToken(db, testUser, tokenPurposePasswordReset, passwordResetTokenTTL)
+	assert.Nil(t, err)
+	secondToken, err := issueAccountToken(db, testUser, tokenPurposePasswordReset, passwordResetTokenTTL)
+	assert.Nil(t, err)
+
+	_, err = consumeAccountToken(db, firstToken, tokenPurposePasswordReset)
+	assert.Equal(t, errInvalidAccountToken, err)
+	_, err = consumeAccountToken(db, secondToken, tokenPurposePasswordReset)
+	assert.Nil(t, err)
+
+	db.Where("user_id = ?", testUser.ID).Delete(&models.UserToken{})
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	db := runInitDb()
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	r := gin.Default()
+	r.Use(TenantMiddleware)
+	r.POST("/users", CreateUserHandler)
+	r.POST("/verify-email/confirm", ConfirmEmailVerificationHandler)
+
+	resp := postJSON(r, "/users", UserBodyWithoutID{Name: "Verify User", Email: "not an email", Password: "new-user-password"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = postJSON(r, "/users", UserBodyWithoutID{Name: "Verify User", Email: "verify@example.com", Password: "new-user-password"})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var userResponse UserResponse
+	err := json.Unmarshal(resp.Body.Bytes(), &userResponse)
+	assert.Nil(t, err)
+	assert.False(t, userResponse.EmailVerified)
+	defer db.Unscoped().Delete(&models.User{}, searchById, userResponse.ID)
+
+	assert.Equal(t, []string{"verify@example.com"}, capture.last().To)
+	token := tokenFromMessage(capture.last())
+
+	resp = postJSON(r, "/verify-email/confirm", TokenBody{Token: "invalid"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = postJSON(r, "/verify-email/confirm", TokenBody{Token: token})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var existingUser models.User
+	err = db.First(&existingUser, searchById, userResponse.ID).Error
+	assert.Nil(t, err)
+	assert.True(t, existingUser.EmailVerified)
+
+	db.Where("user_id = ?", userResponse.ID).Delete(&models.UserToken{})
+}
+
+func TestEmailVerificationOfChangedEmail(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Changed Email User", "changed-email@example.com", "password", false)
+	defer func() {
+		db.Where("user_id = ?", user.ID).Delete(&models.UserToken{})
+		deleteTestUser(db, user)
+	}()
+
+	r := authTestRouter()
+	r.PUT("/users/:id", AuthMiddleware, gormHandlers().UpdateUserHandler)
+	r.POST("/verify-email/confirm", ConfirmEmailVerificationHandler)
+	userLogin := login(t, r, user.Name, "password")
+
+	// the link sent to the former address is invalidated by the change
+	oldToken, err := issueAccountToken(db, user, tokenPurposeVerifyEmail, verifyEmailTokenTTL)
+	assert.Nil(t, err)
+	resp := requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), userLogin.AccessToken, UserBodyWithoutID{Email: "changed-email-new@example.com"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var unused int64
+	assert.Nil(t, db.Model(&models.UserToken{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&unused).Error)
+	assert.Equal(t, int64(0), unused)
+	resp = postJSON(r, "/verify-email/confirm", TokenBody{Token: oldToken})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// and a link that escaped the invalidation only verifies the address it was sent to
+	staleToken, err := issueAccountToken(db, user, tokenPurposeVerifyEmail, verifyEmailTokenTTL)
+	assert.Nil(t, err)
+	resp = postJSON(r, "/verify-email/confirm", TokenBody{Token: staleToken})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	var changed models.User
+	assert.Nil(t, db.First(&changed, searchById, user.ID).Error)
+	assert.Equal(t, "changed-email-new@example.com", changed.Email)
+	assert.False(t, changed.EmailVerified)
+
+	token, err := issueAccountToken(db, changed, tokenPurposeVerifyEmail, verifyEmailTokenTTL)
+	assert.Nil(t, err)
+	resp = postJSON(r, "/verify-email/confirm", TokenBody{Token: token})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, db.First(&changed, searchById, user.ID).Error)
+	assert.True(t, changed.EmailVerified)
+}
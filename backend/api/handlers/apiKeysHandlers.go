@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// apiKeyHeader is the header API keys are sent in, instead of an Authorization token.
+const apiKeyHeader = "X-API-Key"
+const apiKeyPrefix = "dm_"
+
+// scopes of the API keys
+const (
+	ScopeDocumentsRead  = "documents:read"
+	ScopeDocumentsWrite = "documents:write"
+	ScopeAdmin          = "admin"
+)
+
+var apiKeyScopes = []string{ScopeDocumentsRead, ScopeDocumentsWrite, ScopeAdmin}
+
+// apiKeyLastUsedInterval limits how often a request updates the last used time of its key.
+var apiKeyLastUsedInterval = time.Minute
+
+var messageAPIKeyNotFound = "API key not found"
+
+var errInvalidAPIKey = errors.New("invalid API key")
+var errAPIKeyNotAccepted = errors.New("API keys are not accepted for this route")
+var errAPIKeyScope = errors.New("the API key does not have the required scope")
+
+type APIKeyBody struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type APIKeysResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+}
+
+type CreateAPIKeyResponse struct {
+	Message string `json:"message"`
+	// the key is only shown here, it cannot be retrieved later
+	Key    string         `json:"key"`
+	APIKey APIKeyResponse `json:"api_key"`
+}
+
+func newAPIKeyResponse(key models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     strings.Fields(key.Scopes),
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+func hasScope(scopes string, scope string) bool {
+	for _, granted := range strings.Fields(scopes) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyScopes lets the routes that follow it accept API keys. Requests with
+// a safe method need readScope, the others writeScope. Routes without it
+// only accept access tokens.
+func APIKeyScopes(readScope string, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Set("api_key_scope", readScope)
+		default:
+			c.Set("api_key_scope", writeScope)
+		}
+		c.Next()
+	}
+}
+
+// authenticateAPIKey returns the claims of the owner of the key, checking the
+// key grants the scope the route requires. Only keys with the admin scope
+// carry the permissions of the roles of their owner.
+func authenticateAPIKey(c *gin.Context, key string) (*Claims, error) {
+	requiredScope := c.GetString("api_key_scope")
+	if requiredScope == "" {
+		return nil, errAPIKeyNotAccepted
+	}
+
+	db := tenantDB(c)
+
+	var apiKey models.APIKey
+	if err := db.Where("key_hash = ? AND revoked_at IS NULL", hashToken(key)).First(&apiKey).Error; err != nil {
+		return nil, errInvalidAPIKey
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, errInvalidAPIKey
+	}
+
+	var user models.User
+	if err := db.Where(searchById, apiKey.UserID).First(&user).Error; err != nil || user.Status == models.UserStatusSuspended {
+		return nil, errInvalidAPIKey
+	}
+
+	if !hasScope(apiKey.Scopes, requiredScope) {
+		return nil, errAPIKeyScope
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		db.Model(&apiKey).Update("last_used_at", time.Now())
+	}
+
+	claims := &Claims{UserID: user.ID}
+	if hasScope(apiKey.Scopes, ScopeAdmin) {
+		claims.Permissions = effectivePermissions(db, user)
+	}
+	return claims, nil
+}
+
+// abortAPIKeyError answers a request whose API key was refused.
+func abortAPIKeyError(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidAPIKey) {
+		respondError(c, responses.CodeUnauthorized, "Invalid API key")
+	} else {
+		respondError(c, responses.CodeForbidden, err.Error())
+	}
+}
+
+// revokeUserAPIKeys revokes every API key of a user.
+func revokeUserAPIKeys(db *gorm.DB, userID uuid.UUID) error {
+	return db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func listAPIKeys(db *gorm.DB, userID uuid.UUID) ([]APIKeyResponse, error) {
+	var keys []models.APIKey
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at desc").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	response := make([]APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, newAPIKeyResponse(key))
+	}
+	return response, nil
+}
+
+// revokeAPIKeyOfUser revokes an API key only if it belongs to userID.
+func revokeAPIKeyOfUser(c *gin.Context, userID uuid.UUID) {
+	keyID, err := uuid.Parse(c.Param("keyId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid API key ID")
+		return
+	}
+
+	db := tenantDB(c)
+
+	result := db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		respondInternalError(c, "Error revoking API key", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondError(c, responses.CodeNotFound, messageAPIKeyNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// CreateAPIKeyHandler creates an API key for the logged user.
+// @Summary Create an API key
+// @Description Create a personal API key, sent in the X-API-Key header. The key is only returned once.
+// @ID create-api-key
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param apiKey body APIKeyBody true "Name, scopes (documents:read, documents:write, admin) and optional expiry"
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /api-keys [post]
+func CreateAPIKeyHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	var body APIKeyBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, "Invalid data", err)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		respondInvalidFields(c, "Name is required", responses.FieldError{Field: "name", Message: "is required"})
+		return
+	}
+	if len(body.Scopes) == 0 {
+		respondInvalidFields(c, "At least one scope is required", responses.FieldError{Field: "scopes", Message: "is required"})
+		return
+	}
+	for _, scope := range body.Scopes {
+		valid := false
+		for _, known := range apiKeyScopes {
+			valid = valid || scope == known
+		}
+		if !valid {
+			respondInvalidFields(c, "Invalid scope: "+scope, responses.FieldError{Field: "scopes", Message: "must contain only " + strings.Join(apiKeyScopes, ", ")})
+			return
+		}
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		respondInvalidFields(c, "Expiry must be in the future", responses.FieldError{Field: "expires_at", Message: "must be in the future"})
+		return
+	}
+
+	// only users with permissions may create keys carrying them
+	scopes := strings.Join(body.Scopes, " ")
+	if hasScope(scopes, ScopeAdmin) && len(claims.Permissions) == 0 {
+		respondError(c, responses.CodeBadRequest, "Only users with roles can create admin API keys")
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		respondInternalError(c, "Error generating API key", err)
+		return
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	apiKey := models.APIKey{
+		ID:        uuid.New(),
+		UserID:    claims.UserID,
+		Name:      body.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: body.ExpiresAt,
+	}
+	if err := tenantDB(c).Create(&apiKey).Error; err != nil {
+		respondInternalError(c, "Error creating API key", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{
+		Message: "API key created successfully",
+		Key:     key,
+		APIKey:  newAPIKeyResponse(apiKey),
+	})
+}
+
+// GetAPIKeysHandler lists the API keys of the logged user.
+// @Summary Get my API keys
+// @Description List the API keys of the logged user that were not revoked
+// @ID get-api-keys
+// @Tags API Keys
+// @Produce json
+// @Success 200 {object} APIKeysResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /api-keys [get]
+func GetAPIKeysHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	keys, err := listAPIKeys(tenantDB(c), claims.UserID)
+	if err != nil {
+		respondInternalError(c, "Error retrieving API keys", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIKeysResponse{APIKeys: keys})
+}
+
+// RevokeAPIKeyHandler revokes one of the API keys of the logged user.
+// @Summary Revoke one of my API keys
+// @Description Revoke an API key of the logged user
+// @ID revoke-api-key
+// @Tags API Keys
+// @Produce json
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /api-keys/{keyId} [delete]
+func RevokeAPIKeyHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	revokeAPIKeyOfUser(c, claims.UserID)
+}
+
+// GetUserAPIKeysMasterHandler lists the API keys of any user.
+// @Summary Get the API keys of a user
+// @Description List the API keys of a user that were not revoked
+// @ID get-user-api-keys-master
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIKeysResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/api-keys [get]
+func GetUserAPIKeysMasterHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return
+	}
+
+	keys, err := listAPIKeys(tenantDB(c), userID)
+	if err != nil {
+		respondInternalError(c, "Error retrieving API keys", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIKeysResponse{APIKeys: keys})
+}
+
+// RevokeUserAPIKeyMasterHandler revokes an API key of any user.
+// @Summary Revoke an API key of a user
+// @Description Revoke an API key of a user
+// @ID revoke-user-api-key-master
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "User ID"
+// @Param keyId path string true "API key ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/api-keys/{keyId} [delete]
+func RevokeUserAPIKeyMasterHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return
+	}
+	revokeAPIKeyOfUser(c, userID)
+}
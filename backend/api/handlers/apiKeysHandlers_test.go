@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func apiKeysTestRouter() *gin.Engine {
+	r := sessionsTestRouter()
+	r.POST("/api-keys", AuthMiddleware, CreateAPIKeyHandler)
+	r.GET("/api-keys", AuthMiddleware, GetAPIKeysHandler)
+	r.DELETE("/api-keys/:keyId", AuthMiddleware, RevokeAPIKeyHandler)
+	r.GET("/usersMaster/:id/api-keys", APIKeyScopes(ScopeAdmin, ScopeAdmin), AuthMiddleware, RequirePermission(models.PermissionUsersManage), GetUserAPIKeysMasterHandler)
+
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"user_id": c.MustGet("claims").(*Claims).UserID}) }
+	r.GET("/documents", APIKeyScopes(ScopeDocumentsRead, ScopeDocumentsWrite), AuthMiddleware, ok)
+	r.POST("/documents", APIKeyScopes(ScopeDocumentsRead, ScopeDocumentsWrite), AuthMiddleware, ok)
+	return r
+}
+
+func requestWithAPIKey(r *gin.Engine, method string, path string, key string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set(apiKeyHeader, key)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func createAPIKey(t *testing.T, r *gin.Engine, token string, body APIKeyBody) CreateAPIKeyResponse {
+	resp := requestJSONWithToken(r, "POST", "/api-keys", token, body)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var created CreateAPIKeyResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	return created
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "API Key User", "api-key@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer db.Where("user_id = ?", user.ID).Delete(&models.APIKey{})
+
+	r := apiKeysTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+
+	created := createAPIKey(t, r, loginResponse.AccessToken, APIKeyBody{Name: "backup script", Scopes: []string{ScopeDocumentsRead}})
+	assert.Equal(t, created.Key[:len(created.APIKey.Prefix)], created.APIKey.Prefix)
+	assert.Equal(t, []string{ScopeDocumentsRead}, created.APIKey.Scopes)
+
+	resp := requestWithAPIKey(r, "GET", "/documents", created.Key)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), user.ID.String())
+
+	// the key only reads
+	resp = requestWithAPIKey(r, "POST", "/documents", created.Key)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// and cannot be used where API keys are not accepted, such as to create more keys
+	resp = requestWithAPIKey(r, "GET", "/sessions", created.Key)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestWithAPIKey(r, "POST", "/api-keys", created.Key)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestWithAPIKey(r, "GET", "/documents", created.Key+"x")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the use was tracked and the key is never listed
+	resp = requestWithToken(r, "GET", "/api-keys", loginResponse.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), created.Key)
+
+	var listed APIKeysResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &listed))
+	assert.Equal(t, 1, len(listed.APIKeys))
+	assert.Equal(t, "backup script", listed.APIKeys[0].Name)
+	assert.NotNil(t, listed.APIKeys[0].LastUsedAt)
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "API Key Validation User", "api-key-validation@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := apiKeysTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+
+	past := time.Now().Add(-time.Hour)
+	for _, body := range []APIKeyBody{
+		{Name: "", Scopes: []string{ScopeDocumentsRead}},
+		{Name: "no scopes"},
+		{Name: "unknown scope", Scopes: []string{"documents:delete"}},
+		{Name: "expired", Scopes: []string{ScopeDocumentsRead}, ExpiresAt: &past},
+		{Name: "not a master", Scopes: []string{ScopeAdmin}},
+	} {
+		resp := requestJSONWithToken(r, "POST", "/api-keys", loginResponse.AccessToken, body)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, body.Name)
+	}
+}
+
+func TestAPIKeyExpiryAndRevocation(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "API Key Revocation User", "api-key-revocation@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer db.Where("user_id = ?", user.ID).Delete(&models.APIKey{})
+
+	r := apiKeysTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+
+	expiresAt := time.Now().Add(time.Hour)
+	expiring := createAPIKey(t, r, loginResponse.AccessToken, APIKeyBody{Name: "expiring", Scopes: []string{ScopeDocumentsRead}, ExpiresAt: &expiresAt})
+	resp := requestWithAPIKey(r, "GET", "/documents", expiring.Key)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	db.Model(&models.APIKey{}).Where("id = ?", expiring.APIKey.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	resp = requestWithAPIKey(r, "GET", "/documents", expiring.Key)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	revoked := createAPIKey(t, r, loginResponse.AccessToken, APIKeyBody{Name: "revoked", Scopes: []string{ScopeDocumentsWrite}})
+	resp = requestWithAPIKey(r, "POST", "/documents", revoked.Key)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = requestWithToken(r, "DELETE", "/api-keys/"+revoked.APIKey.ID.String(), loginResponse.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestWithToken(r, "DELETE", "/api-keys/"+revoked.APIKey.ID.String(), loginResponse.AccessToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestWithAPIKey(r, "POST", "/documents", revoked.Key)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestAdminAPIKey(t *testing.T) {
+	db := runInitDb()
+	master := createTestUser(t, db, "API Key Master", "api-key-master@example.com", "password", true)
+	defer deleteTestUser(db, master)
+	defer db.Where("user_id = ?", master.ID).Delete(&models.APIKey{})
+
+	r := apiKeysTestRouter()
+	loginResponse := login(t, r, master.Name, "password")
+
+	admin := createAPIKey(t, r, loginResponse.AccessToken, APIKeyBody{Name: "admin", Scopes: []string{ScopeAdmin}})
+	reader := createAPIKey(t, r, loginResponse.AccessToken, APIKeyBody{Name: "reader", Scopes: []string{ScopeDocumentsRead}})
+
+	resp := requestWithAPIKey(r, "GET", "/usersMaster/"+master.ID.String()+"/api-keys", admin.Key)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the keys of an admin only carry its permissions with the admin scope
+	resp = requestWithAPIKey(r, "GET", "/usersMaster/"+master.ID.String()+"/api-keys", reader.Key)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// and lose them with the role
+	assert.Nil(t, setUserRoleByName(db, master.ID, models.RoleAdmin, false))
+	resp = requestWithAPIKey(r, "GET", "/usersMaster/"+master.ID.String()+"/api-keys", admin.Key)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
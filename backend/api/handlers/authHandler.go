@@ -2,23 +2,33 @@ package handlers
 
 import (
 	"document-manager/api/models"
-	"document-manager/database"
+	"document-manager/api/responses"
+	"document-manager/config"
+	"document-manager/ldapauth"
+	"document-manager/metrics"
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-var jwtKey = []byte(os.Getenv("API_SECRET"))
 var messageStatusUnauthorized = "Invalid token"
 
+// jwtKey returns the key signing the tokens, the secret of the configuration.
+func jwtKey() []byte {
+	return []byte(config.Get().Auth.Secret)
+}
+
 type Claims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	IsMaster bool      `json:"is_master,omitempty"` //O uso de omitempty na tag JSON garante que o campo não será incluído no token se for nil.
+	UserID      uuid.UUID `json:"user_id"`
+	Permissions []string  `json:"permissions,omitempty"` // granted by the roles of the user when the token was issued
+	SessionID   uuid.UUID `json:"session_id"`            // models.Session the access token was issued for
 	jwt.StandardClaims
 }
 
@@ -27,44 +37,54 @@ type LoginBody struct {
 	Password        string `json:"password"`
 }
 
+type RefreshTokenBody struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 type LoginResponse struct {
 	Message      string       `json:"message"`
 	AccessToken  string       `json:"access_token"`
 	RefreshToken string       `json:"refresh_token"`
 	User         UserResponse `json:"user"`
+	// set for a user with roles but without two-factor authentication when the
+	// policy requires it: the tokens carry no permissions until it enrolls
+	TwoFactorEnrollmentRequired bool `json:"two_factor_enrollment_required,omitempty"`
 }
 
 func VerifyPassword(password, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func generateTokens(userID uuid.UUID, isMaster bool) (string, string, error) {
-	//gerar token de acesso
-	accessTokenExp := time.Now().Add(time.Hour * 24)
+func generateAccessToken(userID uuid.UUID, permissions []string, sessionID uuid.UUID) (string, error) {
+	accessTokenExp := time.Now().Add(time.Duration(config.Get().Auth.AccessTokenTTL))
 	accessTokenClaims := &Claims{
-		UserID:   userID,
-		IsMaster: isMaster,
+		UserID:      userID,
+		Permissions: permissions,
+		SessionID:   sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: accessTokenExp.Unix(),
 		},
 	}
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenStr, err := accessToken.SignedString(jwtKey)
+	return accessToken.SignedString(jwtKey())
+}
+
+// generateTokens issues an access token and a refresh token for the session
+// identified by sessionID. A new sessionID starts a new refresh token family.
+func generateTokens(db *gorm.DB, userID uuid.UUID, permissions []string, sessionID uuid.UUID) (string, string, error) {
+	//gerar token de acesso
+	accessTokenStr, err := generateAccessToken(userID, permissions, sessionID)
 	if err != nil {
 		return "", "", err
 	}
 
 	//  gerar token de atualização
-	refreshTokenExp := time.Now().Add(time.Hour * 24 * 7)
-	refreshTokenClaims := &Claims{
-		UserID:   userID,
-		IsMaster: isMaster,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: refreshTokenExp.Unix(),
-		},
-	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenStr, err := refreshToken.SignedString(jwtKey)
+	refreshTokenStr, err := issueRefreshToken(db, userID, sessionID)
 	if err != nil {
 		return "", "", err
 	}
@@ -73,6 +93,10 @@ func generateTokens(userID uuid.UUID, isMaster bool) (string, string, error) {
 }
 
 // LoginHandler make login of user.
+// Users unknown here or without a local password are authenticated against the LDAP directory, when configured.
+// When the user has two-factor authentication enabled the response is a TwoFactorChallengeResponse
+// and the login is completed by LoginTwoFactorHandler. When the password must be changed the
+// response is a PasswordChangeRequiredResponse and the login is completed by ChangeRequiredPasswordHandler.
 // @Summary Login
 // @Description login of users
 // @ID login
@@ -83,161 +107,294 @@ func generateTokens(userID uuid.UUID, isMaster bool) (string, string, error) {
 //
 //	@Success 200 {object} LoginResponse
 //
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /login [post]
 func LoginHandler(c *gin.Context) {
 	var loginData LoginBody
 
-	if err := c.BindJSON(&loginData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+	if err := c.ShouldBindJSON(&loginData); err != nil {
+		respondInvalid(c, "Invalid data", err)
+		return
+	}
+
+	account := loginAccountKey(c, loginData.UsernameOrEmail)
+	if !allowAccountAttempt(c, account) {
 		return
 	}
 
-	db := database.GetDB()
+	db := tenantDB(c)
 
 	var user models.User
-	if err := db.Where("name = ? OR email = ?", loginData.UsernameOrEmail, loginData.UsernameOrEmail).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	err := db.Where("name = ? OR email = ?", loginData.UsernameOrEmail, loginData.UsernameOrEmail).First(&user).Error
+	if err == nil {
+		// any error, not only a mismatch, must refuse the local login
+		err = VerifyPassword(loginData.Password, user.Password)
+	}
+	if err != nil {
+		// users without a local password, or unknown here, may be in the directory
+		user, err = loginWithDirectory(db, loginData.UsernameOrEmail, loginData.Password)
+		if err != nil {
+			recordFailedAttempt(c, account)
+			metrics.RecordLogin(metrics.LoginPassword, false)
+			respondError(c, responses.CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+	}
+	resetFailedAttempts(c, account)
+	metrics.RecordLogin(metrics.LoginPassword, true)
+
+	if user.TOTPEnabled {
+		challengeToken, err := issueChallengeToken(user.ID)
+		if err != nil {
+			respondInternalError(c, "Error generating tokens", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+			Message:           "Two-factor authentication required",
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		})
 		return
 	}
 
-	err := VerifyPassword(loginData.Password, user.Password)
+	finishLogin(c, db, user)
+}
 
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
+// loginWithDirectory authenticates the user against the LDAP directory, when
+// one is configured, and returns the matching local user.
+func loginWithDirectory(db *gorm.DB, login string, password string) (models.User, error) {
+	directory := ldapauth.GetAuthenticator()
+	if directory == nil {
+		return models.User{}, ldapauth.ErrInvalidCredentials
 	}
-	accessToken, refreshToken, err := generateTokens(user.ID, user.Master)
+
+	entry, err := directory.Authenticate(login, password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating tokens"})
-		return
+		if !errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			slog.ErrorContext(db.Statement.Context, "Error authenticating against the directory", "login", login, "error", err)
+		}
+		return models.User{}, err
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":       "Login successful",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-		"user":          user,
+	// emails in the directory are managed by its administrators, they are
+	// trusted as verified and take over the local user that did not verify it
+	isAdmin, mapped := directory.MasterFromGroups(entry)
+	user, err := provisionExternalUser(db, externalIdentity{
+		Issuer:        directory.ID(),
+		Subject:       entry.DN,
+		Email:         entry.Email,
+		EmailVerified: true,
+		Names:         []string{entry.Username},
+		Admin:         isAdmin,
+		AdminMapped:   mapped,
 	})
+	if err != nil {
+		slog.ErrorContext(db.Statement.Context, "Error provisioning directory user", "dn", entry.DN, "error", err)
+	}
+	return user, err
 }
 
-func AuthMiddleware(c *gin.Context) {
-	tokenString := c.GetHeader("Authorization")
-	println(">>", tokenString)
+// startSession creates a session for the authenticated user and issues its
+// tokens, unless its account waits for an approval or is suspended.
+func startSession(c *gin.Context, db *gorm.DB, user models.User) (accessToken string, refreshToken string, err error) {
+	switch user.Status {
+	case models.UserStatusPending:
+		return "", "", errAccountPending
+	case models.UserStatusSuspended:
+		return "", "", errAccountSuspended
+	}
 
-	if tokenString == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing token"})
-		c.Abort()
-		return
+	session, err := createSession(db, c, user.ID)
+	if err != nil {
+		return "", "", err
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
+	return generateTokens(db, user.ID, effectivePermissions(db, user), session.ID)
+}
 
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
-		c.Abort()
+// completeLogin starts a session for the authenticated user and responds with its tokens.
+func completeLogin(c *gin.Context, db *gorm.DB, user models.User) {
+	accessToken, refreshToken, err := startSession(c, db, user)
+	if errors.Is(err, errAccountPending) {
+		respondError(c, responses.CodeAccountPending, messageAccountPending)
 		return
 	}
-
-	claims, ok := token.Claims.(*Claims)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
-		c.Abort()
+	if errors.Is(err, errAccountSuspended) {
+		respondError(c, responses.CodeAccountSuspended, messageAccountSuspended)
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Error generating tokens", err)
 		return
 	}
 
-	c.Set("claims", claims)
+	roles, _ := userRoleNames(db, user.ID)
+	response := gin.H{
+		"message":       "Login successful",
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"user":          newUserResponse(user, roles),
+	}
+	if twoFactorEnrollmentRequired(db, user) {
+		response["two_factor_enrollment_required"] = true
+	}
 
-	c.Next()
+	c.JSON(http.StatusOK, response)
 }
 
-func AuthMiddlewareMaster(c *gin.Context) {
+func AuthMiddleware(c *gin.Context) {
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		claims, err := authenticateAPIKey(c, key)
+		if err != nil {
+			abortAPIKeyError(c, err)
+			return
+		}
+		c.Set("claims", claims)
+		setLogUser(c, claims.UserID)
+		c.Next()
+		return
+	}
+
 	tokenString := c.GetHeader("Authorization")
 
 	if tokenString == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing token"})
-		c.Abort()
+		respondError(c, responses.CodeUnauthorized, "Missing token")
 		return
 	}
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
+		return jwtKey(), nil
 	})
 
 	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
-		c.Abort()
+		respondError(c, responses.CodeInvalidToken, messageStatusUnauthorized)
 		return
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
-		c.Abort()
+		respondError(c, responses.CodeInvalidToken, messageStatusUnauthorized)
 		return
 	}
 
-	if !claims.IsMaster {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		c.Abort()
+	// a logout or a revocation ends the session before the token expires
+	if !sessionIsActive(tenantDB(c), claims.SessionID) {
+		respondError(c, responses.CodeInvalidToken, "Session revoked")
 		return
 	}
 
 	c.Set("claims", claims)
+	setLogUser(c, claims.UserID)
 
 	c.Next()
 }
 
 // RefreshTokenHandler handles the generation of a new access token using a valid refresh token.
+// The refresh token is rotated: the response carries a new refresh token and the one sent
+// can no longer be used. Reusing it revokes the whole session.
 // @Summary Refresh Access Token
 // @Description refresh access token
 // @ID refresh-token
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param refresh_token body string true "Refresh Token"
-// @Success 200 {object} LoginResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Param refresh_token body RefreshTokenBody true "Refresh Token"
+// @Success 200 {object} RefreshTokenResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /refresh-token [post]
 func RefreshTokenHandler(c *gin.Context) {
-	var requestBody struct {
-		RefreshToken string `json:"refresh_token"`
-	}
+	var requestBody RefreshTokenBody
 
-	if err := c.BindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{ErrorMessage: "Invalid data"})
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		respondInvalid(c, "Invalid data", err)
 		return
 	}
 
-	refreshTokenStr := requestBody.RefreshToken
-	token, err := jwt.ParseWithClaims(refreshTokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	db := tenantDB(c)
 
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
+	record, err := rotateRefreshToken(db, requestBody.RefreshToken)
+	if err != nil {
+		if err != errInvalidRefreshToken && err != errRefreshTokenReused {
+			respondInternalError(c, "Error generating tokens", err)
+			return
+		}
+		respondError(c, responses.CodeInvalidToken, messageStatusUnauthorized)
 		return
 	}
 
-	claims, ok := token.Claims.(*Claims) //verifica se token.Claims pode ser convertido para o tipo *Claims
-	if !ok || claims.ExpiresAt < time.Now().Unix() {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{ErrorMessage: messageStatusUnauthorized})
+	// the roles are read again so a demotion is honoured on the next refresh
+	var user models.User
+	if err := db.Where("id = ?", record.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeInvalidToken, messageStatusUnauthorized)
 		return
 	}
 
-	accessToken, _, err := generateTokens(claims.UserID, claims.IsMaster)
+	touchSession(db, record.FamilyID)
+
+	accessToken, refreshToken, err := generateTokens(db, user.ID, effectivePermissions(db, user), record.FamilyID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{ErrorMessage: "Error generating tokens"})
+		respondInternalError(c, "Error generating tokens", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": accessToken,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
 	})
 }
+
+// LogoutHandler revokes the session of the access token used in the request.
+// @Summary Logout
+// @Description Revoke the current session
+// @ID logout
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /logout [post]
+func LogoutHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	if err := revokeSession(db, claims.SessionID); err != nil {
+		respondInternalError(c, "Error revoking session", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
+}
+
+// LogoutAllHandler revokes every session of the logged user.
+// @Summary Logout everywhere
+// @Description Revoke all sessions of the logged user
+// @ID logout-all
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /logout-all [post]
+func LogoutAllHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	if err := revokeUserSessions(db, claims.UserID); err != nil {
+		respondInternalError(c, "Error revoking sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/ldapauth"
+	"document-manager/ldapauth/ldaptest"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// createTestUser creates a user with a local password, with the admin role when admin is set.
+func createTestUser(t *testing.T, db *gorm.DB, name string, email string, password string, admin bool) models.User {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	assert.Nil(t, err)
+	user := models.User{
+		ID:       uuid.New(),
+		Name:     name,
+		Email:    email,
+		Password: string(hashedPassword),
+	}
+	assert.Nil(t, db.Create(&user).Error)
+	if admin {
+		assert.Nil(t, setUserRoleByName(db, user.ID, models.RoleAdmin, true))
+	}
+	return user
+}
+
+func deleteTestUser(db *gorm.DB, user models.User) {
+	db.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{})
+	db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+	db.Where("user_id = ?", user.ID).Delete(&models.UserRole{})
+	db.Where("user_id = ?", user.ID).Delete(&models.PasswordHistory{})
+	db.Unscoped().Delete(&user)
+}
+
+func login(t *testing.T, r *gin.Engine, usernameOrEmail string, password string) LoginResponse {
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: usernameOrEmail, Password: password})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var loginResponse LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &loginResponse))
+	return loginResponse
+}
+
+func postWithToken(r *gin.Engine, path string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, nil)
+	req.Header.Set("Authorization", token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func authTestRouter() *gin.Engine {
+	r := gin.Default()
+	r.Use(TenantMiddleware)
+	r.POST("/login", LoginHandler)
+	r.POST("/refresh-token", RefreshTokenHandler)
+	r.POST("/logout", AuthMiddleware, LogoutHandler)
+	r.POST("/logout-all", AuthMiddleware, LogoutAllHandler)
+	return r
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Refresh User", "refresh@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+
+	resp := postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: loginResponse.RefreshToken})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var refreshed RefreshTokenResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &refreshed))
+	assert.NotEqual(t, "", refreshed.AccessToken)
+	assert.NotEqual(t, "", refreshed.RefreshToken)
+	assert.NotEqual(t, loginResponse.RefreshToken, refreshed.RefreshToken)
+
+	// reusing the rotated token is rejected and revokes the whole family
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: loginResponse.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: refreshed.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	var stored models.RefreshToken
+	err := db.Where("token_hash = ?", hashToken(refreshed.RefreshToken)).First(&stored).Error
+	assert.Nil(t, err)
+	assert.NotNil(t, stored.RevokedAt)
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	runInitDb()
+	r := authTestRouter()
+
+	resp := postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: "invalid"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestLogoutHandler(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Logout User", "logout@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+	first := login(t, r, user.Name, "password")
+	second := login(t, r, user.Email, "password")
+
+	resp := postWithToken(r, "/logout", first.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the access token of the session is no longer accepted
+	resp = postWithToken(r, "/logout", first.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: first.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the other session is still valid
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: second.RefreshToken})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestLogoutAllHandler(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Logout All User", "logout-all@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+	first := login(t, r, user.Name, "password")
+	second := login(t, r, user.Name, "password")
+
+	resp := postWithToken(r, "/logout-all", first.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: first.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: second.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestDeleteUserRevokesRefreshTokens(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Deleted Session User", "deleted-session@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+	r.DELETE("/users/:id", AuthMiddleware, gormHandlers().DeleteUserHandler)
+	loginResponse := login(t, r, user.Name, "password")
+
+	createUserForTokenAcess()
+	req, _ := http.NewRequest("DELETE", "/users/"+user.ID.String(), nil)
+	req.Header.Set("Authorization", accessToken)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: loginResponse.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func newTestDirectory(t *testing.T) *ldaptest.Server {
+	server := ldaptest.NewServer(ldaptest.Entry{
+		DN:       "uid=ldap-user,ou=people,dc=example,dc=com",
+		Password: "ldap-password",
+		Attributes: map[string][]string{
+			"uid":      {"ldap-user"},
+			"mail":     {"ldap-user@example.com"},
+			"memberOf": {"cn=admins,ou=groups,dc=example,dc=com"},
+		},
+	})
+	t.Cleanup(server.Close)
+
+	directory, err := ldapauth.New(ldapauth.Config{
+		URL:         server.URL,
+		BaseDN:      "ou=people,dc=example,dc=com",
+		MasterGroup: "cn=admins,ou=groups,dc=example,dc=com",
+	})
+	assert.Nil(t, err)
+	ldapauth.SetAuthenticator(directory)
+	t.Cleanup(func() { ldapauth.SetAuthenticator(nil) })
+	return server
+}
+
+func TestLoginWithDirectory(t *testing.T) {
+	db := runInitDb()
+	newTestDirectory(t)
+	defer deleteExternalUser(db, "ldap-user@example.com")
+
+	r := authTestRouter()
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: "ldap-user", Password: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	loginResponse := login(t, r, "ldap-user", "ldap-password")
+	assert.NotEqual(t, "", loginResponse.AccessToken)
+	assert.Equal(t, "ldap-user", loginResponse.User.Name)
+
+	// the user was created on the first login, with the master flag of its groups
+	var user models.User
+	assert.Nil(t, db.Where("email = ?", "ldap-user@example.com").First(&user).Error)
+	assert.True(t, userHasRole(db, user.ID, models.RoleAdmin))
+	assert.True(t, user.EmailVerified)
+
+	// the next login finds the same user, by name or email
+	loginResponse = login(t, r, "ldap-user@example.com", "ldap-password")
+	assert.Equal(t, user.ID, loginResponse.User.ID)
+
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "ldap-user@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestDirectoryTakesOverUnverifiedUser(t *testing.T) {
+	db := runInitDb()
+	newTestDirectory(t)
+	// someone registered the email of the directory user, without verifying it
+	user := createTestUser(t, db, "ldap-squatter", "ldap-user@example.com", "squatter-password", false)
+	defer deleteExternalUser(db, user.Email)
+
+	r := authTestRouter()
+	squatter := login(t, r, user.Name, "squatter-password")
+
+	loginResponse := login(t, r, "ldap-user", "ldap-password")
+	assert.Equal(t, user.ID, loginResponse.User.ID)
+
+	var claimed models.User
+	assert.Nil(t, db.First(&claimed, searchById, user.ID).Error)
+	assert.True(t, claimed.EmailVerified)
+	assert.Equal(t, "", claimed.Password)
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "squatter-password"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: squatter.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestLocalLoginSkipsDirectory(t *testing.T) {
+	db := runInitDb()
+	server := newTestDirectory(t)
+	user := createTestUser(t, db, "Local User", "local-user@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+	login(t, r, user.Name, "password")
+	assert.Equal(t, 0, server.Binds())
+
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
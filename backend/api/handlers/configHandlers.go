@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"document-manager/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetConfigHandler gets the configuration the server was started with.
+// @Summary Get server configuration
+// @Description Get the configuration loaded at startup from the configuration file, the environment and the flags, with its secrets redacted
+// @ID get-config
+// @Tags Settings
+// @Produce json
+// @Success 200 {object} config.Config
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/config [get]
+func GetConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get().Redacted())
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/config"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConfig(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Config Admin", "config-admin@example.com", "password", true)
+	user := createTestUser(t, db, "Config User", "config-user@example.com", "password", false)
+	defer deleteTestUser(db, admin)
+	defer deleteTestUser(db, user)
+	previous := config.Get()
+	cfg := *previous
+	cfg.Database.Password = "config-secret"
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	r := authTestRouter()
+	r.GET("/settings/config", AuthMiddleware, RequirePermission(models.PermissionSettingsManage), GetConfigHandler)
+
+	resp := requestJSONWithToken(r, "GET", "/settings/config", login(t, r, user.Name, "password").AccessToken, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestJSONWithToken(r, "GET", "/settings/config", login(t, r, admin.Name, "password").AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var response config.Config
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, config.Get().Server.Address, response.Server.Address)
+	assert.Equal(t, config.Get().Auth.AccessTokenTTL, response.Auth.AccessTokenTTL)
+	assert.Equal(t, "********", response.Database.Password)
+	assert.NotContains(t, resp.Body.String(), "config-secret")
+}
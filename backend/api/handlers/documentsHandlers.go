@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"document-manager/api/models"
-	"document-manager/database"
+	"document-manager/api/repositories"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/config"
+	"document-manager/metrics"
+	"document-manager/tracing"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -25,9 +31,14 @@ type DocumentResponse struct {
 	Description string    `json:"description"`
 	OwnerID     string    `json:"owner_id"`
 	OwnerName   string    `json:"owner_name"`
+	WorkspaceID uuid.UUID `json:"workspace_id"`
 	FilePath    string    `json:"filepath"`
 }
 
+type DocumentTransferBody struct {
+	WorkspaceID uuid.UUID `json:"workspace_id" binding:"required"`
+}
+
 type DocumentRequest struct {
 	Title       string `json:"title" binding:"required"`
 	Description string `json:"description"`
@@ -47,9 +58,82 @@ type MessageWithDocumentResponse struct {
 	Document DocumentResponse `json:"document"`
 }
 
+var messageDocumentNotFound = "Document not found"
+
+// findDocument loads the document of the id path parameter from the current
+// workspace, answering the request when it fails.
+func (h *Handlers) findDocument(c *gin.Context) (models.Document, bool) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid document ID")
+		return models.Document{}, false
+	}
+
+	document, err := h.documents.Get(c.Request.Context(), currentWorkspace(c).Workspace.ID, documentID)
+	if err != nil {
+		abortDocumentError(c, err, "Error retrieving document")
+		return models.Document{}, false
+	}
+	return document, true
+}
+
+// maxFileBytes returns the maximum size of an uploaded file, the largest
+// upload of the configuration or the smaller maximum of the storage settings,
+// answering the request when it fails.
+func (h *Handlers) maxFileBytes(c *gin.Context) (int64, bool) {
+	maxFileBytes, err := h.documents.MaxFileBytes(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, "Error retrieving storage settings", err)
+		return 0, false
+	}
+	if maxUploadBytes := config.Get().Documents.MaxUploadBytes(); maxFileBytes == 0 || maxFileBytes > maxUploadBytes {
+		maxFileBytes = maxUploadBytes
+	}
+	return maxFileBytes, true
+}
+
+// abortDocumentError answers the request with the error of the document
+// service, message describing the unexpected ones.
+func abortDocumentError(c *gin.Context, err error, message string) {
+	var exceeded *services.QuotaExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		abortWithProblem(c, http.StatusInsufficientStorage, StorageQuotaExceededResponse{
+			Problem: newProblem(c, responses.CodeStorageQuotaExceeded, "Storage quota of the "+exceeded.Subject+" exceeded"),
+			Quota:   exceeded.Subject,
+			Usage:   exceeded.Usage,
+		})
+	case errors.Is(err, repositories.ErrNotFound):
+		respondError(c, responses.CodeNotFound, messageDocumentNotFound)
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+// canChangeDocuments reports whether the logged user may change the documents
+// of the workspace of the request: its owners and editors may, other users
+// need the permission over the documents of everyone.
+func canChangeDocuments(c *gin.Context, permission string) bool {
+	switch currentWorkspace(c).Role {
+	case models.WorkspaceRoleOwner, models.WorkspaceRoleEditor:
+		return true
+	}
+	return c.MustGet("claims").(*Claims).HasPermission(permission)
+}
+
+func abortCannotChangeDocuments(c *gin.Context) {
+	respondError(c, responses.CodeForbidden, "You cannot change the documents of this workspace")
+}
+
+// canSetOwner reports whether the logged user may make ownerID the owner of a document.
+func canSetOwner(c *gin.Context, ownerID string) bool {
+	claims := c.MustGet("claims").(*Claims)
+	return ownerID == claims.UserID.String() || claims.HasPermission(models.PermissionDocumentsWriteAny)
+}
+
 // GetAllDocumentsHandler gets all documents.
 // @Summary Get all documents
-// @Description Get all documents
+// @Description Get the documents of the current workspace, selected with the X-Workspace-ID header
 // @ID get-all-documents
 // @Tags Documents
 // @Accept json
@@ -61,12 +145,13 @@ type MessageWithDocumentResponse struct {
 //
 //	@Success 200 {object} DocumentsResponse
 //
-// @Failure 401 {object} ErrorResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 401 {object} responses.Problem
+// @Failure 400 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents [get]
-func GetAllDocumentsHandler(c *gin.Context) {
+func (h *Handlers) GetAllDocumentsHandler(c *gin.Context) {
 	page := c.DefaultQuery("page", "1")
 	limit := c.DefaultQuery("limit", "10")
 	sort := c.DefaultQuery("sort", "id")
@@ -74,55 +159,23 @@ func GetAllDocumentsHandler(c *gin.Context) {
 
 	pageInt, err := strconv.Atoi(page)
 	if err != nil || pageInt < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'page' parameter"})
+		respondError(c, responses.CodeBadRequest, "Invalid 'page' parameter")
 		return
 	}
 
 	limitInt, err := strconv.Atoi(limit)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit' parameter"})
+	if err != nil || limitInt < 1 {
+		respondError(c, responses.CodeBadRequest, "Invalid 'limit' parameter")
 		return
 	}
 
-	var sortField string
-	switch sort {
-	case "id", "title", "owner", "created_at":
-		println(sort)
-		sortField = sort
-	default:
-		sortField = "id"
-	}
-
-	var sortOrder string
-	switch sortDir {
-	case "asc", "desc":
-		sortOrder = sortDir
-	default:
-		sortOrder = "asc"
-	}
-
-	db := database.GetDB()
-
-	var documents []models.Document
-
-	// Count total documents
-	var totalDocuments int64
-	db.Model(&models.Document{}).Count(&totalDocuments)
-
-	// Calculate offset based on page and limit
-	offset := (pageInt - 1) * limitInt
-
-	// Retrieve documents with pagination
-	query := db.Offset(offset).Limit(limitInt).Order(sortField + " " + sortOrder).Find(&documents)
-	if err = query.Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving documents", "details": err.Error()})
+	list, err := h.documents.List(c.Request.Context(), currentWorkspace(c).Workspace.ID, pageInt, limitInt, sort, sortDir)
+	if err != nil {
+		respondInternalError(c, "Error retrieving documents", err)
 		return
 	}
 
-	// Calculate total pages
-	totalPages := (totalDocuments + int64(limitInt) - 1) / int64(limitInt)
-
-	c.JSON(http.StatusOK, gin.H{"documents": documents, "total_documents": totalDocuments, "total_pages": totalPages})
+	c.JSON(http.StatusOK, gin.H{"documents": list.Documents, "total_documents": list.TotalDocuments, "total_pages": list.TotalPages})
 }
 
 // GetDocumentByIDHandler gets a document by ID.
@@ -134,25 +187,16 @@ func GetAllDocumentsHandler(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Document ID"
 // @Success 200 {object} DocumentResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/{id} [get]
-func GetDocumentByIDHandler(c *gin.Context) {
-	documentIDStr := c.Param("id")
-	documentID, err := uuid.Parse(documentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
-		return
-	}
-
-	db := database.GetDB()
-
-	var existingDocument models.Document
-	if err := db.Where("id = ?", documentID).First(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+func (h *Handlers) GetDocumentByIDHandler(c *gin.Context) {
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
 		return
 	}
 
@@ -168,46 +212,41 @@ func GetDocumentByIDHandler(c *gin.Context) {
 // @Produce octet-stream
 // @Param id path string true "Document ID"
 // @Success 200 {file} application/pdf
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/file/{id} [get]
-func GetDocumentFileByIDHandler(c *gin.Context) {
-	documentIDStr := c.Param("id")
-	documentID, err := uuid.Parse(documentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+func (h *Handlers) GetDocumentFileByIDHandler(c *gin.Context) {
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
 		return
 	}
 
-	db := database.GetDB()
-
-	var existingDocument models.Document
-	if err := db.Where("id = ?", documentID).First(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
-		return
-	}
+	_, span := tracing.StartStorage(c.Request.Context(), "read", existingDocument.FilePath)
+	var err error
+	defer func() { tracing.End(span, err) }()
 
 	// Verifique se o arquivo existe
 	_, err = os.Stat(existingDocument.FilePath)
 	if os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		respondError(c, responses.CodeNotFound, "File not found")
 		return
 	}
 
 	// Abra o arquivo para leitura
 	file, err := os.Open(existingDocument.FilePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error opening file"})
+		respondInternalError(c, "Error opening file", err)
 		return
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting file info"})
+		respondInternalError(c, "Error getting file info", err)
 		return
 	}
 
@@ -218,6 +257,9 @@ func GetDocumentFileByIDHandler(c *gin.Context) {
 	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
 
 	c.File(existingDocument.FilePath)
+	if written := c.Writer.Size(); written > 0 {
+		metrics.DownloadedBytes.Add(float64(written))
+	}
 
 	// Definir os cabeçalhos necessários para indicar que é um arquivo PDF
 	// c.Header("Content-Description", "File Transfer")
@@ -233,6 +275,12 @@ func GetDocumentFileByIDHandler(c *gin.Context) {
 
 }
 
+// documentFilePath returns where the file of a new document is stored, under
+// the storage prefix of the tenant of the request.
+func documentFilePath(c *gin.Context, documentID uuid.UUID) string {
+	return path.Join(config.Get().Documents.Path, currentTenant(c).StoragePrefix, documentID.String()+".pdf")
+}
+
 // CreateDocumentHandler creates a new document.
 // @Summary Create a new document
 // @Description Create a new document
@@ -242,66 +290,90 @@ func GetDocumentFileByIDHandler(c *gin.Context) {
 // @Produce json
 // @Param file formData file true "Document file"
 // @Success 201 {object} DocumentResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 507 {object} StorageQuotaExceededResponse
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/upload [post]
-func CreateDocumentHandler(c *gin.Context) {
-	err := c.Request.ParseMultipartForm(200 << 20) // 200 MB limit
+func (h *Handlers) CreateDocumentHandler(c *gin.Context) {
+	metrics.ActiveUploads.Inc()
+	defer metrics.ActiveUploads.Dec()
+
+	maxFileBytes, ok := h.maxFileBytes(c)
+	if !ok {
+		return
+	}
+	limitUploadSize(c, maxFileBytes)
+
+	err := c.Request.ParseMultipartForm(uploadMemoryBytes)
+	if isUploadTooLarge(maxFileBytes, err, 0) {
+		abortUploadTooLarge(c, maxFileBytes)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error parsing form", "details": err.Error()})
+		respondError(c, responses.CodeBadRequest, "Error parsing form")
 		return
 	}
 
 	var docRequest DocumentRequestFile
-	if err := c.Bind(&docRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data", "details": err.Error()})
+	if err := c.ShouldBind(&docRequest); err != nil {
+		respondInvalid(c, "Invalid form data", err)
 		return
 	}
 
-	//handle file upload
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required", "details": err.Error()})
+	if !canChangeDocuments(c, models.PermissionDocumentsWriteAny) {
+		abortCannotChangeDocuments(c)
 		return
 	}
-	defer file.Close()
-	documentID := uuid.New()
-	// filename := header.Filename + time.Now().Format("2006-01-02_15-04-05")
 
-	directory, err := os.Getwd() //get the current directory using the built-in function
-	if err != nil {
-		fmt.Println(err) //print the error if obtained
+	// the document belongs to the logged user unless another owner is allowed
+	if docRequest.OwnerID == "" {
+		docRequest.OwnerID = c.MustGet("claims").(*Claims).UserID.String()
+	}
+	if !canSetOwner(c, docRequest.OwnerID) {
+		respondError(c, responses.CodeMissingPermission, "Missing permission "+models.PermissionDocumentsWriteAny)
+		return
 	}
-	filepath := strings.Split(directory, "document-manager")[0] + "document-manager/documents/" + documentID.String() + ".pdf"
 
-	err = c.SaveUploadedFile(header, filepath)
+	//handle file upload
+	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file", "details": err.Error()})
+		respondInvalidFields(c, "File is required", responses.FieldError{Field: "file", Message: "is required"})
+		return
+	}
+	defer file.Close()
+	if isUploadTooLarge(maxFileBytes, nil, header.Size) {
+		abortUploadTooLarge(c, maxFileBytes)
 		return
 	}
 
-	db := database.GetDB()
-
+	documentID := uuid.New()
 	newDocument := models.Document{
 		ID:          documentID,
 		Title:       docRequest.Title,
 		Description: docRequest.Description,
 		OwnerID:     docRequest.OwnerID,
 		OwnerName:   docRequest.OwnerName,
-		FilePath:    filepath,
+		WorkspaceID: currentWorkspace(c).Workspace.ID,
+		FilePath:    documentFilePath(c, documentID),
 	}
 
-	if err := db.Create(&newDocument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating document", "details": err.Error()})
+	err = h.documents.Create(c.Request.Context(), &newDocument, services.Upload{File: file, Size: header.Size})
+	if err != nil {
+		abortDocumentError(c, err, "Error creating document")
 		return
 	}
+	metrics.UploadedBytes.Add(float64(header.Size))
 
 	documentResponse := DocumentResponse{
-		ID:        newDocument.ID,
-		Title:     newDocument.Title,
-		OwnerID:   newDocument.OwnerID,
-		OwnerName: newDocument.OwnerName,
+		ID:          newDocument.ID,
+		Title:       newDocument.Title,
+		OwnerID:     newDocument.OwnerID,
+		OwnerName:   newDocument.OwnerName,
+		WorkspaceID: newDocument.WorkspaceID,
 	}
 
 	c.JSON(http.StatusCreated, documentResponse)
@@ -316,71 +388,80 @@ func CreateDocumentHandler(c *gin.Context) {
 // @Produce json
 // @Param file formData file true "Document file"
 // @Success 200 {object} MessageWithDocumentResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 507 {object} StorageQuotaExceededResponse
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/upload/{id} [put]
-func UpdateDocumentHandler(c *gin.Context) {
-	documentIDStr := c.Param("id")
-	documentID, err := uuid.Parse(documentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+func (h *Handlers) UpdateDocumentHandler(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid document ID")
 		return
 	}
+	metrics.ActiveUploads.Inc()
+	defer metrics.ActiveUploads.Dec()
 
-	err = c.Request.ParseMultipartForm(200 << 20)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+	maxFileBytes, ok := h.maxFileBytes(c)
+	if !ok {
 		return
 	}
+	limitUploadSize(c, maxFileBytes)
 
-	var docRequest DocumentRequestFile
-	if err := c.Bind(&docRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data", "details": err.Error()})
+	err := c.Request.ParseMultipartForm(uploadMemoryBytes)
+	if isUploadTooLarge(maxFileBytes, err, 0) {
+		abortUploadTooLarge(c, maxFileBytes)
 		return
 	}
-
-	db := database.GetDB()
-
-	var existingDocument models.Document
-
-	// Verificar se o documento existe
-	if err := db.First(&existingDocument, "id = ?", documentID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Failed to parse form")
 		return
 	}
 
-	if docRequest.Title != "" {
-		existingDocument.Title = docRequest.Title
+	var docRequest DocumentRequestFile
+	if err := c.ShouldBind(&docRequest); err != nil {
+		respondInvalid(c, "Invalid form data", err)
+		return
 	}
-	if docRequest.Description != "" {
-		existingDocument.Description = docRequest.Description
+
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
+		return
 	}
-	if docRequest.OwnerID != "" {
-		existingDocument.OwnerID = docRequest.OwnerID
+	if !canChangeDocuments(c, models.PermissionDocumentsWriteAny) {
+		abortCannotChangeDocuments(c)
+		return
 	}
-	if docRequest.OwnerName != "" {
-		existingDocument.OwnerName = docRequest.OwnerName
+	if docRequest.OwnerID != "" && docRequest.OwnerID != existingDocument.OwnerID && !canSetOwner(c, docRequest.OwnerID) {
+		respondError(c, responses.CodeMissingPermission, "Missing permission "+models.PermissionDocumentsWriteAny)
+		return
 	}
 
-	// file, header, err := c.Request.FormFile("file")
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		respondInvalidFields(c, "File is required", responses.FieldError{Field: "file", Message: "is required"})
 		return
 	}
 	defer file.Close()
-
-	if err := db.Save(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document information"})
+	if isUploadTooLarge(maxFileBytes, nil, header.Size) {
+		abortUploadTooLarge(c, maxFileBytes)
 		return
 	}
 
-	err = c.SaveUploadedFile(header, existingDocument.FilePath)
+	changes := services.DocumentChanges{
+		Title:       docRequest.Title,
+		Description: docRequest.Description,
+		OwnerID:     docRequest.OwnerID,
+		OwnerName:   docRequest.OwnerName,
+	}
+	existingDocument, err = h.documents.Update(c.Request.Context(), existingDocument, changes, &services.Upload{File: file, Size: header.Size})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file", "details": err.Error()})
+		abortDocumentError(c, err, "Failed to update document information")
 		return
 	}
+	metrics.UploadedBytes.Add(float64(header.Size))
 
 	documentResponse := DocumentResponse{
 		ID:          existingDocument.ID,
@@ -388,6 +469,7 @@ func UpdateDocumentHandler(c *gin.Context) {
 		Description: existingDocument.Description,
 		OwnerID:     existingDocument.OwnerID,
 		OwnerName:   existingDocument.OwnerName,
+		WorkspaceID: existingDocument.WorkspaceID,
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Document updated successfully", "document": documentResponse})
@@ -400,49 +482,47 @@ func UpdateDocumentHandler(c *gin.Context) {
 // @Tags Documents
 // @Produce json
 // @Success 200 {object} MessageWithDocumentResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 507 {object} StorageQuotaExceededResponse
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/{id} [put]
-func UpdateDocumentWithoutFileHandler(c *gin.Context) {
-	documentIDStr := c.Param("id")
-	documentID, err := uuid.Parse(documentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+func (h *Handlers) UpdateDocumentWithoutFileHandler(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid document ID")
 		return
 	}
 
 	var docRequest DocumentRequest
-	if err := c.Bind(&docRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data", "details": err.Error()})
+	if err := c.ShouldBind(&docRequest); err != nil {
+		respondInvalid(c, "Invalid form data", err)
 		return
 	}
 
-	db := database.GetDB()
-
-	var existingDocument models.Document
-
-	// Verificar se o documento existe
-	if err := db.First(&existingDocument, "id = ?", documentID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
 		return
 	}
-
-	if docRequest.Title != "" {
-		existingDocument.Title = docRequest.Title
-	}
-	if docRequest.Description != "" {
-		existingDocument.Description = docRequest.Description
-	}
-	if docRequest.OwnerID != "" {
-		existingDocument.OwnerID = docRequest.OwnerID
+	if !canChangeDocuments(c, models.PermissionDocumentsWriteAny) {
+		abortCannotChangeDocuments(c)
+		return
 	}
-	if docRequest.OwnerName != "" {
-		existingDocument.OwnerName = docRequest.OwnerName
+	if docRequest.OwnerID != "" && docRequest.OwnerID != existingDocument.OwnerID && !canSetOwner(c, docRequest.OwnerID) {
+		respondError(c, responses.CodeMissingPermission, "Missing permission "+models.PermissionDocumentsWriteAny)
+		return
 	}
 
-	if err := db.Save(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document information"})
+	changes := services.DocumentChanges{
+		Title:       docRequest.Title,
+		Description: docRequest.Description,
+		OwnerID:     docRequest.OwnerID,
+		OwnerName:   docRequest.OwnerName,
+	}
+	existingDocument, err := h.documents.Update(c.Request.Context(), existingDocument, changes, nil)
+	if err != nil {
+		abortDocumentError(c, err, "Failed to update document information")
 		return
 	}
 
@@ -452,6 +532,7 @@ func UpdateDocumentWithoutFileHandler(c *gin.Context) {
 		Description: existingDocument.Description,
 		OwnerID:     existingDocument.OwnerID,
 		OwnerName:   existingDocument.OwnerName,
+		WorkspaceID: existingDocument.WorkspaceID,
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Document updated successfully", "document": documentResponse})
@@ -466,42 +547,101 @@ func UpdateDocumentWithoutFileHandler(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Document ID"
 // @Success 200 {string} MessageResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /documents/{id} [delete]
-func DeleteDocumentHandler(c *gin.Context) {
-	documentIDStr := c.Param("id")
-	documentID, err := uuid.Parse(documentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+func (h *Handlers) DeleteDocumentHandler(c *gin.Context) {
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
+		return
+	}
+	if !canChangeDocuments(c, models.PermissionDocumentsDeleteAny) {
+		abortCannotChangeDocuments(c)
 		return
 	}
 
-	db := database.GetDB()
+	if err := h.documents.Delete(c.Request.Context(), existingDocument); err != nil {
+		abortDocumentError(c, err, "Error deleting document")
+		return
+	}
 
-	var existingDocument models.Document
-	if err := db.Where("id = ?", documentID).First(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
+
+// TransferDocumentHandler moves a document to another workspace.
+// @Summary Transfer a document to another workspace
+// @Description Move a document of the current workspace to another workspace. The logged user must be able to change the documents of both
+// @ID transfer-document
+// @Tags Documents
+// @Accept json
+// @Produce json
+// @Param id path string true "Document ID"
+// @Param body body DocumentTransferBody true "Target workspace"
+// @Success 200 {object} MessageWithDocumentResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 507 {object} StorageQuotaExceededResponse
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /documents/{id}/transfer [post]
+func (h *Handlers) TransferDocumentHandler(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid document ID")
+		return
 	}
 
-	//delete associated file
-	directory, err := os.Getwd() //get the current directory using the built-in function
-	if err != nil {
-		fmt.Println(err) //print the error if obtained
+	var body DocumentTransferBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
 	}
-	filepath := strings.Split(directory, "document-manager")[0] + "document-manager/documents/" + documentIDStr + ".pdf"
 
-	if err := os.Remove(filepath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting document file", "details": err.Error()})
+	existingDocument, ok := h.findDocument(c)
+	if !ok {
+		return
+	}
+	if !canChangeDocuments(c, models.PermissionDocumentsWriteAny) {
+		abortCannotChangeDocuments(c)
 		return
 	}
 
-	if err := db.Delete(&existingDocument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting document", "details": err.Error()})
+	db := tenantDB(c)
+	claims := c.MustGet("claims").(*Claims)
+	var target models.Workspace
+	targetRole := ""
+	err := db.Where(searchById, body.WorkspaceID).First(&target).Error
+	if err == nil {
+		targetRole = workspaceRole(db, target.ID, claims.UserID)
+	}
+	if err != nil || (targetRole == "" && !claims.HasPermission(models.PermissionDocumentsReadAll)) {
+		respondError(c, responses.CodeNotFound, messageWorkspaceNotFound)
+		return
+	}
+	if targetRole != models.WorkspaceRoleOwner && targetRole != models.WorkspaceRoleEditor && !claims.HasPermission(models.PermissionDocumentsWriteAny) {
+		respondError(c, responses.CodeForbidden, "You cannot add documents to the target workspace")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+	existingDocument, err = h.documents.Transfer(c.Request.Context(), existingDocument, target.ID)
+	if err != nil {
+		abortDocumentError(c, err, "Error transferring document")
+		return
+	}
+
+	documentResponse := DocumentResponse{
+		ID:          existingDocument.ID,
+		Title:       existingDocument.Title,
+		Description: existingDocument.Description,
+		OwnerID:     existingDocument.OwnerID,
+		OwnerName:   existingDocument.OwnerName,
+		WorkspaceID: existingDocument.WorkspaceID,
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document transferred successfully", "document": documentResponse})
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"document-manager/api/models"
+	"document-manager/database"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,8 +25,9 @@ func TestGetAllDocumentsHandler(t *testing.T) {
 	runInitDb()
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.GET("/documents", AuthMiddleware, GetAllDocumentsHandler)
+	r.GET("/documents", AuthMiddleware, WorkspaceMiddleware, gormHandlers().GetAllDocumentsHandler)
 
 	req, _ := http.NewRequest("GET", "/documents", nil)
 	req.Header.Set("Authorization", accessToken)
@@ -34,6 +36,7 @@ func TestGetAllDocumentsHandler(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "tenant_id")
 
 	var response DocumentsResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &response)
@@ -47,23 +50,28 @@ func TestGetAllDocumentsHandler(t *testing.T) {
 func TestGetDocumentByIDHandler(t *testing.T) {
 	db := runInitDb()
 
+	createUserForTokenAcess()
+	workspace, err := database.PersonalWorkspace(db, uuid.MustParse(userId))
+	assert.Nil(t, err)
+
 	testDocumentID := uuid.New()
 	testDocument := models.Document{
-		ID:        testDocumentID,
-		Title:     "Test Document",
-		OwnerID:   userId,
-		OwnerName: userName,
+		ID:          testDocumentID,
+		Title:       "Test Document",
+		OwnerID:     userId,
+		OwnerName:   userName,
+		WorkspaceID: workspace.ID,
 	}
 
 	db.Create(&testDocument)
 
 	var existingDocument models.Document
-	err := db.First(&existingDocument, "id = ?", testDocument.ID).Error
+	err = db.First(&existingDocument, "id = ?", testDocument.ID).Error
 	assert.Nil(t, err)
 
 	r := gin.Default()
-	createUserForTokenAcess()
-	r.GET("/documents/:id", AuthMiddleware, GetDocumentByIDHandler)
+	r.Use(TenantMiddleware)
+	r.GET("/documents/:id", AuthMiddleware, WorkspaceMiddleware, gormHandlers().GetDocumentByIDHandler)
 
 	req, _ := http.NewRequest("GET", "/documents/"+testDocumentID.String(), nil)
 	req.Header.Set("Authorization", accessToken)
@@ -72,6 +80,7 @@ func TestGetDocumentByIDHandler(t *testing.T) {
 	r.ServeHTTP(resp, req)
 
 	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "tenant_id")
 
 	var documentResponse DocumentResponse
 	err = json.Unmarshal(resp.Body.Bytes(), &documentResponse)
@@ -103,7 +112,8 @@ func TestCreateDocumentHandler(t *testing.T) {
 	}
 
 	r := gin.Default()
-	r.POST("/documents", AuthMiddleware, CreateDocumentHandler)
+	r.Use(TenantMiddleware)
+	r.POST("/documents", AuthMiddleware, WorkspaceMiddleware, gormHandlers().CreateDocumentHandler)
 
 	// Criar um buffer para armazenar os dados do formulário
 	var b bytes.Buffer
@@ -156,7 +166,8 @@ func TestCreateDocumentHandler(t *testing.T) {
 func TestGetDocumentFileByIDHandler(t *testing.T) {
 	// Configurar o roteador e a rota
 	r := gin.Default()
-	r.GET("/documents/file/:id", AuthMiddleware, GetDocumentFileByIDHandler)
+	r.Use(TenantMiddleware)
+	r.GET("/documents/file/:id", AuthMiddleware, WorkspaceMiddleware, gormHandlers().GetDocumentFileByIDHandler)
 	// Criar uma solicitação HTTP para a rota com um ID de documento válido
 	req, _ := http.NewRequest("GET", "/documents/file/"+idDocumentExample, nil)
 	req.Header.Set("Authorization", accessToken)
@@ -190,8 +201,9 @@ func TestUpdateDocumentHandler(t *testing.T) {
 	}
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 
-	r.PUT("/documents/upload/:id", AuthMiddleware, UpdateDocumentHandler)
+	r.PUT("/documents/upload/:id", AuthMiddleware, WorkspaceMiddleware, gormHandlers().UpdateDocumentHandler)
 
 	// Criar um buffer para armazenar os dados do formulário
 	var b bytes.Buffer
@@ -241,7 +253,8 @@ func TestUpdateDocumentHandler(t *testing.T) {
 
 func TestUpdateDocumentWithoutFileHandler(t *testing.T) {
 	r := gin.Default()
-	r.PUT("/documents/:id", AuthMiddleware, UpdateDocumentWithoutFileHandler)
+	r.Use(TenantMiddleware)
+	r.PUT("/documents/:id", AuthMiddleware, WorkspaceMiddleware, gormHandlers().UpdateDocumentWithoutFileHandler)
 
 	updateDocumentData := DocumentRequest{
 		Title:       "Test Document update without file",
@@ -283,7 +296,8 @@ func TestDeleteDocumentHandler(t *testing.T) {
 
 	// Create a Gin router
 	r := gin.Default()
-	r.DELETE("/documents/:id", AuthMiddleware, DeleteDocumentHandler)
+	r.Use(TenantMiddleware)
+	r.DELETE("/documents/:id", AuthMiddleware, WorkspaceMiddleware, gormHandlers().DeleteDocumentHandler)
 
 	// Create a request to delete the test document
 	req, _ := http.NewRequest("DELETE", "/documents/"+idDocumentExample, nil)
@@ -312,3 +326,61 @@ func TestDeleteDocumentHandler(t *testing.T) {
 	_, fileErr := os.Stat(deletedDocument.FilePath)
 	assert.True(t, os.IsNotExist(fileErr)) // This should return true, indicating that the file is not found
 }
+
+func TestDocumentsScopedToWorkspace(t *testing.T) {
+	db := runInitDb()
+	owner := createTestUser(t, db, "Documents Owner", "documents-owner@example.com", "password", false)
+	other := createTestUser(t, db, "Documents Other", "documents-other@example.com", "password", false)
+	admin := createTestUser(t, db, "Documents Admin", "documents-admin@example.com", "password", true)
+	defer deleteTestUser(db, owner)
+	defer deleteTestUser(db, other)
+	defer deleteTestUser(db, admin)
+
+	personal, err := database.PersonalWorkspace(db, owner.ID)
+	assert.Nil(t, err)
+	document := models.Document{ID: uuid.New(), Title: "Owned Document", OwnerID: owner.ID.String(), OwnerName: owner.Name, WorkspaceID: personal.ID}
+	assert.Nil(t, db.Create(&document).Error)
+	defer db.Unscoped().Delete(&document)
+
+	r := workspacesTestRouter()
+	ownerLogin := login(t, r, owner.Name, "password")
+	otherLogin := login(t, r, other.Name, "password")
+	adminLogin := login(t, r, admin.Name, "password")
+
+	listIDs := func(token string, workspaceID string) []uuid.UUID {
+		resp := requestInWorkspace(r, "GET", "/documents?limit=1000", token, workspaceID, nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response DocumentsResponse
+		assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		ids := []uuid.UUID{}
+		for _, listed := range response.Documents {
+			ids = append(ids, listed.ID)
+		}
+		return ids
+	}
+	// without the header the personal workspace is used
+	assert.Equal(t, []uuid.UUID{document.ID}, listIDs(ownerLogin.AccessToken, ""))
+	assert.NotContains(t, listIDs(otherLogin.AccessToken, ""), document.ID)
+	assert.NotContains(t, listIDs(adminLogin.AccessToken, ""), document.ID)
+
+	// the workspaces of others are hidden, unless the token grants documents:read_all
+	resp := requestInWorkspace(r, "GET", "/documents", otherLogin.AccessToken, personal.ID.String(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, []uuid.UUID{document.ID}, listIDs(adminLogin.AccessToken, personal.ID.String()))
+
+	path := "/documents/" + document.ID.String()
+	resp = requestInWorkspace(r, "GET", path, otherLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = requestInWorkspace(r, "PUT", path, otherLogin.AccessToken, "", DocumentRequest{Title: "Taken"})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = requestInWorkspace(r, "DELETE", path, otherLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// giving the document away needs documents:write_any
+	resp = requestInWorkspace(r, "PUT", path, ownerLogin.AccessToken, "", DocumentRequest{Title: "Given", OwnerID: other.ID.String()})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestInWorkspace(r, "PUT", path, adminLogin.AccessToken, personal.ID.String(), DocumentRequest{Title: "Given", OwnerID: other.ID.String()})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestInWorkspace(r, "GET", path, ownerLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"document-manager/api/responses"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// the validation errors name the fields as the clients send them
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return field.Name
+		})
+	}
+}
+
+// newProblem returns the problem of code for the request of c.
+func newProblem(c *gin.Context, code responses.Code, detail string) responses.Problem {
+	problem := responses.New(code, detail)
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = c.GetString("request_id")
+	return problem
+}
+
+// abortWithProblem answers body, a responses.Problem or a struct embedding
+// one, as application/problem+json and stops the handlers of the request.
+func abortWithProblem(c *gin.Context, status int, body any) {
+	c.Header("Content-Type", responses.ContentType)
+	c.AbortWithStatusJSON(status, body)
+}
+
+// respondError answers the problem of code, whose detail is shown to the
+// users.
+func respondError(c *gin.Context, code responses.Code, detail string) {
+	problem := newProblem(c, code, detail)
+	abortWithProblem(c, problem.Status, problem)
+}
+
+// respondInternalError logs err and answers 500 with detail only: the errors
+// of the database and of the storage stay in the logs.
+func respondInternalError(c *gin.Context, detail string, err error) {
+	slog.ErrorContext(c.Request.Context(), detail, "error", err)
+	respondError(c, responses.CodeInternal, detail)
+}
+
+// respondInvalid answers the error of binding the request, listing the
+// invalid fields when they are known.
+func respondInvalid(c *gin.Context, detail string, err error) {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var fields []responses.FieldError
+	switch {
+	case errors.As(err, &validationErrors):
+		for _, fieldError := range validationErrors {
+			fields = append(fields, responses.FieldError{Field: fieldPath(fieldError), Message: validationMessage(fieldError)})
+		}
+	case errors.As(err, &typeError) && typeError.Field != "":
+		fields = append(fields, responses.FieldError{Field: typeError.Field, Message: "must be a " + jsonType(typeError.Type)})
+	}
+	if len(fields) == 0 {
+		respondError(c, responses.CodeBadRequest, detail)
+		return
+	}
+	respondInvalidFields(c, detail, fields...)
+}
+
+// respondInvalidFields answers a validation_failed problem listing fields.
+func respondInvalidFields(c *gin.Context, detail string, fields ...responses.FieldError) {
+	problem := newProblem(c, responses.CodeValidationFailed, detail)
+	problem.Errors = fields
+	abortWithProblem(c, problem.Status, problem)
+}
+
+// NoRouteHandler answers the requests of the paths without handler.
+func NoRouteHandler(c *gin.Context) {
+	respondError(c, responses.CodeNotFound, http.StatusText(http.StatusNotFound))
+}
+
+// fieldPath is the path of the field in the request, without the name of
+// the bound struct.
+func fieldPath(fieldError validator.FieldError) string {
+	_, path, found := strings.Cut(fieldError.Namespace(), ".")
+	if !found {
+		return fieldError.Field()
+	}
+	return path
+}
+
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fieldError.Param()
+	case "max":
+		return "must be at most " + fieldError.Param()
+	case "email":
+		return "must be an email address"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldError.Param()), ", ")
+	}
+	return "is invalid"
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "string"
+}
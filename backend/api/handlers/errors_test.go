@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/api/responses"
+	"document-manager/logging"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func problemOf(t *testing.T, resp *httptest.ResponseRecorder) responses.Problem {
+	assert.Equal(t, responses.ContentType, resp.Header().Get("Content-Type"))
+	var problem responses.Problem
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, resp.Code, problem.Status)
+	return problem
+}
+
+func TestProblems(t *testing.T) {
+	var out bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&out, "info"))
+	defer slog.SetDefault(previous)
+
+	router := gin.New()
+	router.Use(RequestIDMiddleware)
+	router.GET("/broken", func(c *gin.Context) {
+		respondInternalError(c, "Error retrieving documents", errors.New("dial tcp 10.0.0.5:5432: connection refused"))
+	})
+	router.POST("/documents", func(c *gin.Context) {
+		var body DocumentRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondInvalid(c, messageStatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusCreated, body)
+	})
+	router.NoRoute(NoRouteHandler)
+
+	// the internal errors are logged, not answered
+	request, _ := http.NewRequest("GET", "/broken", nil)
+	request.Header.Set(requestIDHeader, "trace-1234")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	problem := problemOf(t, resp)
+	assert.Equal(t, responses.Problem{
+		Type:      "about:blank",
+		Title:     "Internal Server Error",
+		Status:    http.StatusInternalServerError,
+		Detail:    "Error retrieving documents",
+		Instance:  "/broken",
+		Code:      responses.CodeInternal,
+		RequestID: "trace-1234",
+	}, problem)
+	assert.NotContains(t, resp.Body.String(), "connection refused")
+	assert.Contains(t, out.String(), "connection refused")
+	assert.Contains(t, out.String(), `"request_id":"trace-1234"`)
+
+	invalid := []struct {
+		body   string
+		code   responses.Code
+		errors []responses.FieldError
+	}{
+		{`{"description": "no title"}`, responses.CodeValidationFailed, []responses.FieldError{{Field: "title", Message: "is required"}}},
+		{`{"title": 12}`, responses.CodeValidationFailed, []responses.FieldError{{Field: "title", Message: "must be a string"}}},
+		{`{"title": `, responses.CodeBadRequest, nil},
+	}
+	for _, test := range invalid {
+		request, _ = http.NewRequest("POST", "/documents", strings.NewReader(test.body))
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, request)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, test.body)
+		problem = problemOf(t, resp)
+		assert.Equal(t, test.code, problem.Code, test.body)
+		assert.Equal(t, test.errors, problem.Errors, test.body)
+	}
+
+	request, _ = http.NewRequest("GET", "/unknown", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, responses.CodeNotFound, problemOf(t, resp).Code)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errExternalEmailRequired = errors.New("the identity provider did not return an email")
+var errExternalEmailNotVerified = errors.New("the email of the identity provider is not verified and belongs to another user")
+
+// externalIdentity is a user authenticated by an identity provider or a
+// directory instead of a local password.
+type externalIdentity struct {
+	// Issuer and Subject identify the user at the provider
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Names are the candidates for the name of a new user, in order of preference
+	Names []string
+	// Admin grants or takes the admin role, only when AdminMapped is set
+	Admin       bool
+	AdminMapped bool
+}
+
+// uniqueUserName returns base, or base followed by a number, that no user has yet.
+func uniqueUserName(db *gorm.DB, base string) (string, error) {
+	name := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := db.Model(&models.User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// provisionExternalUser returns the user linked to the identity. On the first
+// login the identity is linked to the user with the same email, taken over
+// with claimUnverifiedUser when that user never verified it, or a new user
+// without a local password is created.
+func provisionExternalUser(db *gorm.DB, identity externalIdentity) (models.User, error) {
+	var user models.User
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&link).Error
+		if err == nil {
+			return tx.Where(searchById, link.UserID).First(&user).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if identity.Email == "" {
+			return errExternalEmailRequired
+		}
+
+		err = tx.Where("email = ?", identity.Email).First(&user).Error
+		switch {
+		case err == nil:
+			if !identity.EmailVerified {
+				return errExternalEmailNotVerified
+			}
+			if !user.EmailVerified {
+				if err := claimUnverifiedUser(tx, &user); err != nil {
+					return err
+				}
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			base := strings.Split(identity.Email, "@")[0]
+			for _, candidate := range identity.Names {
+				if candidate != "" {
+					base = candidate
+					break
+				}
+			}
+			name, err := uniqueUserName(tx, base)
+			if err != nil {
+				return err
+			}
+
+			// the empty password can never match, these users log in through the provider
+			user = models.User{
+				ID:            uuid.New(),
+				Name:          name,
+				Email:         identity.Email,
+				EmailVerified: identity.EmailVerified,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			ID:      uuid.New(),
+			UserID:  user.ID,
+			Issuer:  identity.Issuer,
+			Subject: identity.Subject,
+			Email:   identity.Email,
+		}).Error
+	})
+	if err != nil {
+		return user, err
+	}
+
+	// the last admin keeps the role, or nobody could manage the roles anymore
+	if identity.AdminMapped {
+		err := setUserRoleByName(db, user.ID, models.RoleAdmin, identity.Admin)
+		if err != nil && !errors.Is(err, errLastAdmin) {
+			return user, err
+		}
+	}
+
+	return user, nil
+}
+
+// claimUnverifiedUser hands the user, whose email was never verified, over to
+// the identity that proved owning it. Whoever registered the email may not own
+// it: its password, second factor, sessions, API keys and the links already
+// sent stop working, so that only the provider logs in from now on.
+func claimUnverifiedUser(tx *gorm.DB, user *models.User) error {
+	updates := map[string]interface{}{
+		"password":             "",
+		"email_verified":       true,
+		"must_change_password": false,
+		"totp_enabled":         false,
+		"totp_secret":          "",
+		"totp_last_step":       0,
+	}
+	if err := tx.Model(&models.User{}).Where(searchById, user.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.UserToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", time.Now()).Error; err != nil {
+		return err
+	}
+	if err := revokeUserSessions(tx, user.ID); err != nil {
+		return err
+	}
+	if err := revokeUserAPIKeys(tx, user.ID); err != nil {
+		return err
+	}
+
+	user.Password = ""
+	user.EmailVerified = true
+	user.MustChangePassword = false
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	return nil
+}
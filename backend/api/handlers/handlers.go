@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"document-manager/api/repositories"
+	"document-manager/api/services"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Handlers are the handlers of the documents and the users, which reach the
+// database through the services only.
+type Handlers struct {
+	documents *services.DocumentService
+	users     *services.UserService
+}
+
+func New(documents *services.DocumentService, users *services.UserService) *Handlers {
+	return &Handlers{documents: documents, users: users}
+}
+
+// StorageQuotas are the storage quotas kept in the settings and the
+// storage_quota table of db.
+type StorageQuotas struct {
+	db *gorm.DB
+}
+
+func NewStorageQuotas(db *gorm.DB) *StorageQuotas {
+	return &StorageQuotas{db: db}
+}
+
+func (q *StorageQuotas) MaxFileBytes(ctx context.Context) (int64, error) {
+	return loadStorageSettings(q.db.WithContext(ctx)).MaxFileBytes, nil
+}
+
+func (q *StorageQuotas) Usage(ctx context.Context, subject string, subjectID string) (services.StorageUsage, error) {
+	db := repositories.DB(ctx, q.db)
+	if err := lockStorageSubject(db, subject, subjectID); err != nil {
+		return services.StorageUsage{}, err
+	}
+	return storageUsage(db, loadStorageSettings(db), subject, subjectID)
+}
+
+// SessionRevoker revokes the sessions, the refresh tokens and the API keys
+// kept in db.
+type SessionRevoker struct {
+	db *gorm.DB
+}
+
+func NewSessionRevoker(db *gorm.DB) *SessionRevoker {
+	return &SessionRevoker{db: db}
+}
+
+func (r *SessionRevoker) RevokeAccess(ctx context.Context, userID uuid.UUID) error {
+	db := r.db.WithContext(ctx)
+	if err := revokeUserSessions(db, userID); err != nil {
+		return err
+	}
+	return revokeUserAPIKeys(db, userID)
+}
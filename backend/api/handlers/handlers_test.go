@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"document-manager/api/services"
+	"document-manager/config"
+	"document-manager/database"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQuotas are storage quotas without a database, every user and
+// workspace using usage.
+type memoryQuotas struct {
+	maxFileBytes int64
+	usage        services.StorageUsage
+}
+
+func (q *memoryQuotas) MaxFileBytes(ctx context.Context) (int64, error) {
+	return q.maxFileBytes, nil
+}
+
+func (q *memoryQuotas) Usage(ctx context.Context, subject string, subjectID string) (services.StorageUsage, error) {
+	return q.usage, nil
+}
+
+// memoryRevoker records the users whose access was revoked.
+type memoryRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (r *memoryRevoker) RevokeAccess(ctx context.Context, userID uuid.UUID) error {
+	r.revoked = append(r.revoked, userID)
+	return nil
+}
+
+// memoryTest is a router on the in-memory repositories, whose requests are
+// made by user to workspace with role, without a database.
+type memoryTest struct {
+	router    *gin.Engine
+	documents *repositories.MemoryDocumentRepository
+	users     *repositories.MemoryUserRepository
+	revoker   *memoryRevoker
+	ctx       context.Context
+	user      models.User
+	workspace models.Workspace
+	role      string
+}
+
+func newMemoryTest(t *testing.T, quotas services.Quotas) *memoryTest {
+	tenant := models.Tenant{ID: uuid.New(), Slug: "memory", StoragePrefix: "memory"}
+	m := &memoryTest{
+		router:    gin.New(),
+		documents: repositories.NewMemoryDocumentRepository(),
+		users:     repositories.NewMemoryUserRepository(),
+		revoker:   &memoryRevoker{},
+		ctx:       database.WithTenant(context.Background(), tenant.ID),
+		user:      models.User{ID: uuid.New(), Name: "Memory User", Email: "memory@example.com", Status: models.UserStatusActive},
+		workspace: models.Workspace{ID: uuid.New(), Name: "Memory"},
+		role:      models.WorkspaceRoleOwner,
+	}
+	assert.Nil(t, m.users.Save(m.ctx, &m.user))
+
+	m.router.Use(func(c *gin.Context) {
+		c.Set("tenant", tenant)
+		c.Request = c.Request.WithContext(database.WithTenant(c.Request.Context(), tenant.ID))
+		c.Set("claims", &Claims{UserID: m.user.ID})
+		c.Set("workspace", &workspaceAccess{Workspace: m.workspace, Role: m.role})
+	})
+	h := New(
+		services.NewDocumentService(m.documents, quotas),
+		services.NewUserService(m.users, m.revoker),
+	)
+	m.router.GET("/documents", h.GetAllDocumentsHandler)
+	m.router.GET("/documents/:id", h.GetDocumentByIDHandler)
+	m.router.PUT("/documents/:id", h.UpdateDocumentWithoutFileHandler)
+	m.router.DELETE("/documents/:id", h.DeleteDocumentHandler)
+	m.router.POST("/documents/upload", h.CreateDocumentHandler)
+	m.router.GET("/users/:id", h.GetUserByIDHandler)
+	m.router.PUT("/users/:id", h.UpdateUserHandler)
+	m.router.DELETE("/users/:id", h.DeleteUserHandler)
+	return m
+}
+
+func TestDocumentHandlersWithoutDatabase(t *testing.T) {
+	previous := config.Get()
+	cfg := *previous
+	cfg.Documents.Path = t.TempDir()
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	quotas := &memoryQuotas{maxFileBytes: 1024, usage: services.StorageUsage{MaxFiles: 1}}
+	m := newMemoryTest(t, quotas)
+
+	resp := uploadTestFile(t, m.router, "POST", "/documents/upload", "", 2048)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	resp = uploadTestFile(t, m.router, "POST", "/documents/upload", "", 100)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var created DocumentResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.Equal(t, m.user.ID.String(), created.OwnerID)
+	assert.Equal(t, m.workspace.ID, created.WorkspaceID)
+
+	stored, err := m.documents.Get(m.ctx, m.workspace.ID, created.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), stored.FileSize)
+	assert.FileExists(t, stored.FilePath)
+
+	// the quota allows a single file
+	quotas.usage.Files = 1
+	resp = uploadTestFile(t, m.router, "POST", "/documents/upload", "", 100)
+	assert.Equal(t, http.StatusInsufficientStorage, resp.Code)
+
+	resp = requestJSONWithToken(m.router, "GET", "/documents?sort=owner&dir=desc", "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var list DocumentsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	assert.Equal(t, int64(1), list.TotalDocuments)
+	assert.Equal(t, int64(1), list.TotalPages)
+	resp = requestJSONWithToken(m.router, "GET", "/documents?limit=0", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = requestJSONWithToken(m.router, "PUT", "/documents/"+created.ID.String(), "", DocumentRequest{Title: "Renamed"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestJSONWithToken(m.router, "GET", "/documents/"+created.ID.String(), "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var document DocumentResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &document))
+	assert.Equal(t, "Renamed", document.Title)
+
+	// viewers only read, and documents of other workspaces are not found
+	m.role = models.WorkspaceRoleViewer
+	resp = requestJSONWithToken(m.router, "DELETE", "/documents/"+created.ID.String(), "", nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	m.role = models.WorkspaceRoleOwner
+	resp = requestJSONWithToken(m.router, "DELETE", "/documents/"+uuid.NewString(), "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestJSONWithToken(m.router, "DELETE", "/documents/"+created.ID.String(), "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	_, err = m.documents.Get(m.ctx, m.workspace.ID, created.ID)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+	_, err = os.Stat(stored.FilePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUserHandlersWithoutDatabase(t *testing.T) {
+	m := newMemoryTest(t, &memoryQuotas{})
+	other := models.User{ID: uuid.New(), Name: "Memory Other", Email: "memory-other@example.com"}
+	assert.Nil(t, m.users.Save(m.ctx, &other))
+	path := "/users/" + m.user.ID.String()
+
+	resp := requestJSONWithToken(m.router, "GET", "/users/"+other.ID.String(), "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestJSONWithToken(m.router, "GET", "/users/"+uuid.NewString(), "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestJSONWithToken(m.router, "PUT", "/users/"+other.ID.String(), "", UserBodyWithoutID{Name: "Renamed"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestJSONWithToken(m.router, "PUT", path, "", UserBodyWithoutID{})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = requestJSONWithToken(m.router, "PUT", path, "", UserBodyWithoutID{Email: "not an email"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	m.users.SetRoles(m.user.ID, models.RoleAdmin)
+	resp = requestJSONWithToken(m.router, "PUT", path, "", UserBodyWithoutID{Name: "Memory Renamed", Email: "renamed@example.com"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var response MessageWithUserResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, "Memory Renamed", response.User.Name)
+	assert.Equal(t, []string{models.RoleAdmin}, response.User.Roles)
+	saved, err := m.users.Get(m.ctx, m.user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed@example.com", saved.Email)
+	assert.False(t, saved.EmailVerified)
+
+	resp = requestJSONWithToken(m.router, "DELETE", path, "", nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	m.users.SetRoles(m.user.ID)
+	resp = requestJSONWithToken(m.router, "DELETE", path, "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []uuid.UUID{m.user.ID}, m.revoker.revoked)
+	_, err = m.users.Get(m.ctx, m.user.ID)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"document-manager/config"
+	"document-manager/database"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the checks of a readiness probe.
+const readinessTimeout = 5 * time.Second
+
+// HealthResponse is the answer of the health probes, with the result of
+// each check of the readiness, "ok" or "failing".
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readinessChecks are what the server needs to answer the requests.
+var readinessChecks = []struct {
+	name  string
+	check func(ctx context.Context) error
+}{
+	{"database", database.Ping},
+	{"storage", checkStorage},
+	{"migrations", checkMigrations},
+}
+
+// HealthzHandler answers as long as the server runs, for the liveness probes.
+func HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// ReadyzHandler answers 200 when the database answers, the documents
+// directory is writable and the schema has no pending migrations, and 503
+// otherwise, for the readiness probes. The reasons of the failures are
+// logged, not answered.
+func ReadyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	response := HealthResponse{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+	for _, readiness := range readinessChecks {
+		if err := readiness.check(ctx); err != nil {
+			slog.WarnContext(ctx, "Readiness check failed", "check", readiness.name, "error", err)
+			response.Checks[readiness.name] = "failing"
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[readiness.name] = "ok"
+	}
+	c.JSON(status, response)
+}
+
+// checkStorage creates and removes a file in the documents directory,
+// creating the directory like the first upload would.
+func checkStorage(ctx context.Context) error {
+	directory := config.Get().Documents.Path
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(directory, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func checkMigrations(ctx context.Context) error {
+	migrator, err := database.GetMigrator()
+	if err != nil {
+		return err
+	}
+	return migrator.Check(ctx)
+}
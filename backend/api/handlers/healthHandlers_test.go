@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"document-manager/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(t *testing.T, path string) (int, HealthResponse) {
+	r := gin.New()
+	r.GET("/healthz", HealthzHandler)
+	r.GET("/readyz", ReadyzHandler)
+	req, _ := http.NewRequest("GET", path, nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	var response HealthResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	return resp.Code, response
+}
+
+func TestHealthProbes(t *testing.T) {
+	runInitDb()
+	previous := config.Get()
+	cfg := *previous
+	cfg.Documents.Path = filepath.Join(t.TempDir(), "documents")
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	code, response := probe(t, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+
+	code, response = probe(t, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"database": "ok", "storage": "ok", "migrations": "ok"}, response.Checks)
+	entries, err := os.ReadDir(cfg.Documents.Path)
+	assert.Nil(t, err)
+	assert.Empty(t, entries, "the file of the check is removed")
+
+	// a file in place of the documents directory
+	cfg.Documents.Path = filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, os.WriteFile(cfg.Documents.Path, nil, 0o600))
+	code, response = probe(t, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", response.Status)
+	assert.Equal(t, "failing", response.Checks["storage"])
+	assert.Equal(t, "ok", response.Checks["database"])
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/passwords"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	settingPasswordPolicy       = "password_policy"
+	tokenPurposePasswordChange  = "password_change"
+	messagePasswordPolicyFailed = "Password does not meet the password policy"
+)
+
+var passwordChangeTTL = time.Minute * 10
+
+// errPasswordReused is returned for the passwords of the history of the user.
+var errPasswordReused = errors.New("password was used recently")
+
+type PasswordChangeRequiredResponse struct {
+	Message                string `json:"message"`
+	PasswordChangeRequired bool   `json:"password_change_required"`
+	PasswordChangeToken    string `json:"password_change_token"`
+}
+
+type PasswordChangeBody struct {
+	PasswordChangeToken string `json:"password_change_token" binding:"required"`
+	Password            string `json:"password"`
+}
+
+// loadPasswordPolicy returns the password policy of the tenant of db. It is
+// stored as JSON, being more than scalars.
+func loadPasswordPolicy(db *gorm.DB) passwords.Policy {
+	policy := passwords.DefaultPolicy()
+	if value := getSetting(db, settingPasswordPolicy, ""); value != "" {
+		if err := json.Unmarshal([]byte(value), &policy); err != nil {
+			return passwords.DefaultPolicy()
+		}
+	}
+	return policy
+}
+
+// hashNewPassword checks password against the policy and the history of the
+// user, then hashes it. The error is a *passwords.PolicyError or
+// errPasswordReused when the password is refused.
+func hashNewPassword(db *gorm.DB, user models.User, password string) (string, error) {
+	policy := loadPasswordPolicy(db)
+	if err := policy.Check(password, user.Name, user.Email); err != nil {
+		return "", err
+	}
+
+	if user.ID != uuid.Nil && policy.HistorySize > 0 {
+		var history []models.PasswordHistory
+		if err := db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(policy.HistorySize).Find(&history).Error; err != nil {
+			return "", err
+		}
+		for _, previous := range history {
+			if VerifyPassword(password, previous.Password) == nil {
+				return "", errPasswordReused
+			}
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashedPassword), err
+}
+
+// abortNewPassword answers the request with the reasons hashNewPassword
+// refused the password, as errors of the password field, and reports whether
+// it did.
+func abortNewPassword(c *gin.Context, err error) bool {
+	var policyError *passwords.PolicyError
+	var violations []string
+	switch {
+	case errors.As(err, &policyError):
+		violations = policyError.Violations
+	case errors.Is(err, errPasswordReused):
+		violations = []string{"was used recently"}
+	default:
+		return false
+	}
+	problem := newProblem(c, responses.CodePasswordPolicy, messagePasswordPolicyFailed)
+	for _, violation := range violations {
+		problem.Errors = append(problem.Errors, responses.FieldError{Field: "password", Message: violation})
+	}
+	abortWithProblem(c, problem.Status, problem)
+	return true
+}
+
+// setUserPassword gives the user the hashed password and keeps it in its
+// history, forgetting the passwords beyond the history size of the policy.
+// The user is saved by the caller.
+func setUserPassword(tx *gorm.DB, user *models.User, hashedPassword string) error {
+	now := time.Now()
+	user.Password = hashedPassword
+	user.PasswordChangedAt = &now
+	user.MustChangePassword = false
+
+	if err := tx.Create(&models.PasswordHistory{ID: uuid.New(), UserID: user.ID, Password: hashedPassword}).Error; err != nil {
+		return err
+	}
+	historySize := max(loadPasswordPolicy(tx).HistorySize, 1)
+	var expired []uuid.UUID
+	if err := tx.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).Order("created_at DESC").Offset(historySize).Pluck("id", &expired).Error; err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return tx.Where("id IN ?", expired).Delete(&models.PasswordHistory{}).Error
+}
+
+// passwordChangeRequired reports whether the user must change its local
+// password before logging in, when forced to or when it expired. The users of
+// the identity providers have none.
+func passwordChangeRequired(db *gorm.DB, user models.User) bool {
+	if user.Password == "" {
+		return false
+	}
+	if user.MustChangePassword {
+		return true
+	}
+	maxAgeDays := loadPasswordPolicy(db).MaxAgeDays
+	if maxAgeDays <= 0 {
+		return false
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return time.Since(changedAt) > time.Duration(maxAgeDays)*24*time.Hour
+}
+
+// finishLogin completes the login of a user authenticated with its local
+// password, unless the password must be changed first.
+func finishLogin(c *gin.Context, db *gorm.DB, user models.User) {
+	if !passwordChangeRequired(db, user) {
+		completeLogin(c, db, user)
+		return
+	}
+
+	token, err := issueLoginToken(user.ID, tokenPurposePasswordChange, passwordChangeTTL)
+	if err != nil {
+		respondInternalError(c, "Error generating tokens", err)
+		return
+	}
+	c.JSON(http.StatusOK, PasswordChangeRequiredResponse{
+		Message:                "Password change required",
+		PasswordChangeRequired: true,
+		PasswordChangeToken:    token,
+	})
+}
+
+// ChangeRequiredPasswordHandler completes a login whose password must be changed.
+// @Summary Change an expired password
+// @Description Exchange the password change token returned by the login and a new password for the tokens
+// @ID login-password-change
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body PasswordChangeBody true "Password change token and new password"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /login/password [post]
+func ChangeRequiredPasswordHandler(c *gin.Context) {
+	var body PasswordChangeBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	userID, err := parseLoginToken(body.PasswordChangeToken, tokenPurposePasswordChange)
+	if err != nil {
+		respondError(c, responses.CodeInvalidToken, messageInvalidAccountToken)
+		return
+	}
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, userID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	hashedPassword, err := hashNewPassword(db, user, body.Password)
+	if err != nil {
+		if !abortNewPassword(c, err) {
+			respondInternalError(c, "Error changing password", err)
+		}
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := setUserPassword(tx, &user, hashedPassword); err != nil {
+			return err
+		}
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error changing password", err)
+		return
+	}
+
+	completeLogin(c, db, user)
+}
+
+// issueLoginToken returns a short-lived token proving that the password of
+// the user was checked, for the next step of its login.
+func issueLoginToken(userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	claims := &accountTokenClaims{
+		UserID:  userID,
+		Purpose: purpose,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey())
+}
+
+func parseLoginToken(tokenString string, purpose string) (uuid.UUID, error) {
+	claims := &accountTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errInvalidAccountToken
+		}
+		return jwtKey(), nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != purpose {
+		return uuid.Nil, errInvalidAccountToken
+	}
+	return claims.UserID, nil
+}
+
+// GetPasswordPolicyHandler gets the password policy.
+// @Summary Get the password policy
+// @Description Get what new passwords must satisfy
+// @ID get-password-policy
+// @Tags Settings
+// @Produce json
+// @Success 200 {object} passwords.Policy
+// @Failure 401 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/password-policy [get]
+func GetPasswordPolicyHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, loadPasswordPolicy(tenantDB(c)))
+}
+
+// UpdatePasswordPolicyHandler updates the password policy.
+// @Summary Update the password policy
+// @Description Update what new passwords must satisfy. It applies to the passwords set from now on, and max_age_days to every login
+// @ID update-password-policy
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param policy body passwords.Policy true "Password policy"
+// @Success 200 {object} passwords.Policy
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/password-policy [put]
+func UpdatePasswordPolicyHandler(c *gin.Context) {
+	var policy passwords.Policy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	var fields []responses.FieldError
+	if policy.MinLength < 1 {
+		fields = append(fields, responses.FieldError{Field: "min_length", Message: "must be at least 1"})
+	}
+	if policy.HistorySize < 0 {
+		fields = append(fields, responses.FieldError{Field: "history_size", Message: "must be at least 0"})
+	}
+	if policy.MaxAgeDays < 0 {
+		fields = append(fields, responses.FieldError{Field: "max_age_days", Message: "must be at least 0"})
+	}
+	if len(fields) > 0 {
+		respondInvalidFields(c, messageStatusBadRequest, fields...)
+		return
+	}
+
+	value, err := json.Marshal(policy)
+	if err != nil {
+		respondInternalError(c, "Error updating settings", err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	if err := setSetting(db, settingPasswordPolicy, string(value)); err != nil {
+		respondInternalError(c, "Error updating settings", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loadPasswordPolicy(db))
+}
+
+// RequirePasswordChangeMasterHandler makes a user change its password on its next login.
+// @Summary Require a password change
+// @Description Make a user change its password on its next login
+// @ID require-password-change-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/password-change [post]
+func RequirePasswordChangeMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, c.Param("id")).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+	if user.Password == "" {
+		respondError(c, responses.CodeBadRequest, "The user has no local password")
+		return
+	}
+
+	if err := db.Model(&user).UpdateColumn("must_change_password", true).Error; err != nil {
+		respondInternalError(c, "Error updating user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "The user must change its password on its next login"})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/passwords"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func passwordPolicyTestRouter() *gin.Engine {
+	r := authTestRouter()
+	r.POST("/login/password", ChangeRequiredPasswordHandler)
+	r.PUT("/users/:id", AuthMiddleware, gormHandlers().UpdateUserHandler)
+	r.PUT("/settings/password-policy", AuthMiddleware, RequirePermission(models.PermissionSettingsManage), UpdatePasswordPolicyHandler)
+	r.POST("/usersMaster/:id/password-change", AuthMiddleware, RequirePermission(models.PermissionUsersManage), RequirePasswordChangeMasterHandler)
+	return r
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	db := runInitDb()
+	defer db.Where("key = ?", settingPasswordPolicy).Delete(&models.Setting{})
+	admin := createTestUser(t, db, "Policy Admin", "policy-admin@example.com", "admin-password", true)
+	defer deleteTestUser(db, admin)
+	user := createTestUser(t, db, "Policy User", "policy-user@example.com", "first-password", false)
+	defer deleteTestUser(db, user)
+
+	r := passwordPolicyTestRouter()
+	adminToken := login(t, r, admin.Name, "admin-password").AccessToken
+	token := login(t, r, user.Name, "first-password").AccessToken
+
+	policy := passwords.Policy{MinLength: 10, RequireDigit: true, HistorySize: 2}
+	resp := requestJSONWithToken(r, "PUT", "/settings/password-policy", adminToken, policy)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), token, UserBodyWithoutID{Name: user.Name, Password: "too-short"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	var problem responses.Problem
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, responses.CodePasswordPolicy, problem.Code)
+	assert.Equal(t, []responses.FieldError{
+		{Field: "password", Message: "must be at least 10 characters long"},
+		{Field: "password", Message: "must contain a digit"},
+	}, problem.Errors)
+
+	// the new password is hashed and kept in the history
+	for _, password := range []string{"second-password-2", "third-password-3"} {
+		resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), token, UserBodyWithoutID{Name: user.Name, Password: password})
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+	var updatedUser models.User
+	assert.Nil(t, db.First(&updatedUser, "id = ?", user.ID).Error)
+	assert.Nil(t, VerifyPassword("third-password-3", updatedUser.Password))
+	assert.NotNil(t, updatedUser.PasswordChangedAt)
+	var historyCount int64
+	assert.Nil(t, db.Model(&models.PasswordHistory{}).Where("user_id = ?", user.ID).Count(&historyCount).Error)
+	assert.Equal(t, int64(2), historyCount)
+
+	resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), token, UserBodyWithoutID{Name: user.Name, Password: "second-password-2"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	problem = responses.Problem{}
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, []responses.FieldError{{Field: "password", Message: "was used recently"}}, problem.Errors)
+
+	// a required change holds the login until a new password is chosen
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+user.ID.String()+"/password-change", adminToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "third-password-3"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var changeRequired PasswordChangeRequiredResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &changeRequired))
+	assert.True(t, changeRequired.PasswordChangeRequired)
+	assert.NotEmpty(t, changeRequired.PasswordChangeToken)
+
+	resp = postJSON(r, "/login/password", PasswordChangeBody{PasswordChangeToken: changeRequired.PasswordChangeToken, Password: "third-password-3"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = postJSON(r, "/login/password", PasswordChangeBody{PasswordChangeToken: "invalid", Password: "fourth-password-4"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = postJSON(r, "/login/password", PasswordChangeBody{PasswordChangeToken: changeRequired.PasswordChangeToken, Password: "fourth-password-4"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var loginResponse LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &loginResponse))
+	assert.NotEmpty(t, loginResponse.AccessToken)
+
+	login(t, r, user.Name, "fourth-password-4")
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"document-manager/api/responses"
+	"document-manager/ratelimit"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RateLimit limits the requests of each client IP to the endpoints of scope,
+// answering 429 with a Retry-After header once its bucket is empty.
+func RateLimit(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowAttempt(c, "ip:"+scope+":"+c.ClientIP(), ratelimit.GetConfig().IP) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// allowAttempt takes a token from the bucket key and answers 429 when it is
+// empty. A failing store lets the request through rather than locking
+// everyone out.
+func allowAttempt(c *gin.Context, key string, limit ratelimit.Limit) bool {
+	allowed, retryAfter, err := ratelimit.GetStore().Allow(c.Request.Context(), key, limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error checking the rate limit", "key", key, "error", err)
+		return true
+	}
+	if !allowed {
+		abortTooManyRequests(c, retryAfter, "Too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+func abortTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondError(c, responses.CodeRateLimited, message)
+}
+
+// loginAccountKey identifies the account a login attempt names in the tenant
+// of the request, whether it exists or not.
+func loginAccountKey(c *gin.Context, login string) string {
+	return "account:" + currentTenant(c).Slug + ":" + strings.ToLower(strings.TrimSpace(login))
+}
+
+// twoFactorAccountKey identifies the account of a second factor attempt.
+func twoFactorAccountKey(c *gin.Context, userID uuid.UUID) string {
+	return "2fa:" + currentTenant(c).Slug + ":" + userID.String()
+}
+
+// allowAccountAttempt answers 429 when the account is locked or tried too
+// often, whatever the client IP.
+func allowAccountAttempt(c *gin.Context, key string) bool {
+	lockedFor, err := ratelimit.GetStore().LockedFor(c.Request.Context(), key)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error checking the lockout", "key", key, "error", err)
+	}
+	if lockedFor > 0 {
+		abortTooManyRequests(c, lockedFor, "Account locked after too many failed attempts, try again later")
+		return false
+	}
+	return allowAttempt(c, key, ratelimit.GetConfig().Account)
+}
+
+// recordFailedAttempt counts a failed attempt on the account and locks it
+// once the failures reach the lockout threshold, for longer at every failure.
+func recordFailedAttempt(c *gin.Context, key string) {
+	lockout := ratelimit.GetConfig().Lockout
+	if lockout.Threshold <= 0 {
+		return
+	}
+	store := ratelimit.GetStore()
+	failures, err := store.AddFailure(c.Request.Context(), key, lockout.Window)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error counting a failed attempt", "key", key, "error", err)
+		return
+	}
+	if duration := lockout.For(failures); duration > 0 {
+		slog.WarnContext(c.Request.Context(), "Locking after failed attempts", "key", key, "duration", duration.String(), "failures", failures)
+		if err := store.Lock(c.Request.Context(), key, duration); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error locking", "key", key, "error", err)
+		}
+	}
+}
+
+// resetFailedAttempts forgets the failures of the account after a success.
+func resetFailedAttempts(c *gin.Context, key string) {
+	if err := ratelimit.GetStore().Reset(c.Request.Context(), key); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error resetting the failed attempts", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"document-manager/ratelimit"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// withRateLimits replaces the limits and the store until the test ends.
+func withRateLimits(t *testing.T, config ratelimit.Config) {
+	previousConfig, previousStore := ratelimit.GetConfig(), ratelimit.GetStore()
+	ratelimit.SetConfig(config)
+	ratelimit.SetStore(ratelimit.NewMemoryStore())
+	t.Cleanup(func() {
+		ratelimit.SetConfig(previousConfig)
+		ratelimit.SetStore(previousStore)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	db := runInitDb()
+	withRateLimits(t, ratelimit.Config{
+		Lockout: ratelimit.Lockout{Threshold: 3, Duration: time.Minute, MaxDuration: time.Hour, Window: time.Hour},
+	})
+	user := createTestUser(t, db, "Lockout User", "lockout@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := authTestRouter()
+
+	// a success forgets the failures
+	for i := 0; i < 2; i++ {
+		resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "wrong"})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+	login(t, r, user.Name, "password")
+
+	for i := 0; i < 3; i++ {
+		resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "wrong"})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	// the account is locked, even for the right password and another spelling of the login
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: "LOCKOUT User", Password: "password"})
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+
+	// unknown accounts are locked alike
+	for i := 0; i < 3; i++ {
+		postJSON(r, "/login", LoginBody{UsernameOrEmail: "nobody@example.com", Password: "wrong"})
+	}
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: "nobody@example.com", Password: "wrong"})
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+	// every further failure doubles the lockout
+	store := ratelimit.GetStore()
+	key := "account:default:lockout user"
+	assert.Nil(t, store.Lock(context.Background(), key, 0))
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	lockedFor, err := store.LockedFor(context.Background(), key)
+	assert.Nil(t, err)
+	assert.Greater(t, lockedFor, time.Minute)
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	withRateLimits(t, ratelimit.Config{IP: ratelimit.Limit{PerMinute: 1, Burst: 2}})
+
+	r := gin.Default()
+	r.POST("/limited", RateLimit("test"), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusNoContent, postJSON(r, "/limited", nil).Code)
+	}
+	resp := postJSON(r, "/limited", nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"document-manager/api/models"
+	"document-manager/config"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// hashToken returns the hash stored instead of a refresh token or an API key.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken creates a new opaque refresh token in the given family
+// and returns it. Only its hash is persisted.
+func issueRefreshToken(db *gorm.DB, userID uuid.UUID, familyID uuid.UUID) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	record := models.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(config.Get().Auth.RefreshTokenTTL)),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// rotateRefreshToken marks a refresh token as used and returns it, so a new
+// one can be issued in the same family. Presenting a token that was already
+// used or revoked means it leaked, so the whole session is revoked.
+func rotateRefreshToken(db *gorm.DB, token string) (*models.RefreshToken, error) {
+	var record models.RefreshToken
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	if record.UsedAt != nil || record.RevokedAt != nil {
+		if err := revokeSession(db, record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	// the condition on used_at makes a concurrent use of the same token count as a reuse
+	result := db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", record.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		if err := revokeSession(db, record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	return &record, nil
+}
+
+// revokeRefreshTokenFamily revokes every token of a login session.
+func revokeRefreshTokenFamily(db *gorm.DB, familyID uuid.UUID) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserRefreshTokens revokes every token of every session of a user.
+func revokeUserRefreshTokens(db *gorm.DB, userID uuid.UUID) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/mailer"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const settingRegistrationMode = "registration_mode"
+
+// who can sign up with POST /users
+const (
+	// anyone
+	registrationModeOpen = "open"
+	// only the invited email addresses
+	registrationModeInvite = "invite"
+	// anyone, but the accounts wait for the approval of an administrator unless invited
+	registrationModeApproval = "approval"
+)
+
+var inviteTTL = time.Hour * 24 * 7
+
+var errInvalidInvite = errors.New("invalid or expired invitation")
+var messageInvalidInvite = "Invalid or expired invitation"
+
+var errAccountPending = errors.New("account waiting for approval")
+var messageAccountPending = "Your account is waiting for the approval of an administrator"
+
+type RegistrationSettings struct {
+	// open, invite or approval
+	Mode string `json:"mode" enums:"open,invite,approval" binding:"oneof=open invite approval"`
+}
+
+type InviteBody struct {
+	Email string `json:"email"`
+}
+
+type InvitesResponse struct {
+	Invites []models.Invite `json:"invites"`
+}
+
+func loadRegistrationSettings(db *gorm.DB) RegistrationSettings {
+	mode := getSetting(db, settingRegistrationMode, registrationModeOpen)
+	if !isRegistrationMode(mode) {
+		mode = registrationModeOpen
+	}
+	return RegistrationSettings{Mode: mode}
+}
+
+func isRegistrationMode(mode string) bool {
+	return mode == registrationModeOpen || mode == registrationModeInvite || mode == registrationModeApproval
+}
+
+// redeemInvite uses up, for the new user, the invite or the invitation to a
+// workspace sent to its email address with token. The new user joins the
+// workspace of an invitation, so the owners of workspaces can invite too.
+func redeemInvite(tx *gorm.DB, token string, user models.User) error {
+	now := time.Now()
+
+	// the condition on used_at keeps the invite from being used twice
+	result := tx.Model(&models.Invite{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND LOWER(email) = LOWER(?)", hashToken(token), now, user.Email).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var invitation models.WorkspaceInvitation
+	err := tx.Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", hashToken(token), now).First(&invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !strings.EqualFold(invitation.Email, user.Email)) {
+		return errInvalidInvite
+	}
+	if err != nil {
+		return err
+	}
+	result = tx.Model(&models.WorkspaceInvitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Update("accepted_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errInvalidInvite
+	}
+	return tx.Create(&models.WorkspaceMember{WorkspaceID: invitation.WorkspaceID, UserID: user.ID, Role: invitation.Role}).Error
+}
+
+// GetRegistrationHandler tells who can sign up.
+// @Summary Get the registration mode
+// @Description Tell whether anyone can sign up, only the invited email addresses, or anyone after the approval of an administrator
+// @ID get-registration
+// @Tags Users
+// @Produce json
+// @Success 200 {object} RegistrationSettings
+// @Router /registration [get]
+func GetRegistrationHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, loadRegistrationSettings(tenantDB(c)))
+}
+
+// GetRegistrationSettingsHandler gets the registration settings.
+// @Summary Get the registration settings
+// @Description Get who can sign up
+// @ID get-registration-settings
+// @Tags Settings
+// @Produce json
+// @Success 200 {object} RegistrationSettings
+// @Failure 401 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/registration [get]
+func GetRegistrationSettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, loadRegistrationSettings(tenantDB(c)))
+}
+
+// UpdateRegistrationSettingsHandler updates the registration settings.
+// @Summary Update the registration settings
+// @Description Choose who can sign up: anyone (open), only the invited email addresses (invite), or anyone after the approval of an administrator (approval)
+// @ID update-registration-settings
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param settings body RegistrationSettings true "Registration settings"
+// @Success 200 {object} RegistrationSettings
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/registration [put]
+func UpdateRegistrationSettingsHandler(c *gin.Context) {
+	var settings RegistrationSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	if err := setSetting(db, settingRegistrationMode, settings.Mode); err != nil {
+		respondInternalError(c, "Error updating settings", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loadRegistrationSettings(db))
+}
+
+// CreateInviteHandler invites an email address to sign up.
+// @Summary Invite to sign up
+// @Description Send a single-use invitation to sign up to an email address. A new invite replaces the pending one of the address
+// @ID create-invite
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param invite body InviteBody true "Invite"
+// @Success 201 {object} models.Invite
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /invites [post]
+func CreateInviteHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	var body InviteBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	if !services.IsValidEmail(body.Email) {
+		respondInvalidFields(c, messageInvalidEmail, responses.FieldError{Field: "email", Message: "must be an email address"})
+		return
+	}
+
+	var count int64
+	if err := db.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", body.Email).Count(&count).Error; err != nil {
+		respondInternalError(c, "Error creating invite", err)
+		return
+	}
+	if count > 0 {
+		respondError(c, responses.CodeAlreadyExists, "Email already in use")
+		return
+	}
+
+	var inviter models.User
+	if err := db.Where(searchById, claims.UserID).First(&inviter).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		respondInternalError(c, "Error creating invite", err)
+		return
+	}
+
+	invite := models.Invite{
+		ID:          uuid.New(),
+		Email:       body.Email,
+		TokenHash:   hashToken(token),
+		InvitedByID: inviter.ID,
+		ExpiresAt:   time.Now().Add(inviteTTL),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("LOWER(email) = LOWER(?) AND used_at IS NULL", body.Email).Delete(&models.Invite{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&invite).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error creating invite", err)
+		return
+	}
+
+	msg, err := mailer.NewMessage(invite.Email, mailer.TemplateRegistrationInvite, map[string]string{
+		"InvitedBy": inviter.Name,
+		"Link":      appURL() + "/signup?invite_token=" + token,
+		"ExpiresIn": formatTTL(inviteTTL),
+	})
+	if err == nil {
+		err = mailer.GetMailer().Send(msg)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending invite", "invite", invite.ID, "error", err)
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// GetInvitesHandler lists the pending invites.
+// @Summary Get the invites
+// @Description List the invites to sign up that were neither used nor expired
+// @ID get-invites
+// @Tags Users
+// @Produce json
+// @Success 200 {object} InvitesResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /invites [get]
+func GetInvitesHandler(c *gin.Context) {
+	invites := []models.Invite{}
+	err := tenantDB(c).Where("used_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Find(&invites).Error
+	if err != nil {
+		respondInternalError(c, "Error retrieving invites", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, InvitesResponse{Invites: invites})
+}
+
+// RevokeInviteHandler revokes a pending invite.
+// @Summary Revoke an invite
+// @Description Revoke an invite to sign up that was not used
+// @ID revoke-invite
+// @Tags Users
+// @Produce json
+// @Param inviteId path string true "Invite ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /invites/{inviteId} [delete]
+func RevokeInviteHandler(c *gin.Context) {
+	inviteID, err := uuid.Parse(c.Param("inviteId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid invite ID")
+		return
+	}
+
+	result := tenantDB(c).Where("id = ? AND used_at IS NULL", inviteID).Delete(&models.Invite{})
+	if result.Error != nil {
+		respondInternalError(c, "Error revoking invite", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondError(c, responses.CodeNotFound, "Invite not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// findPendingUser finds the user of the id parameter whose account waits for
+// an approval, or answers the request.
+func findPendingUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var user models.User
+	if err := db.Where("id = ? AND status = ?", c.Param("id"), models.UserStatusPending).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, "Pending user not found")
+		return user, false
+	}
+	return user, true
+}
+
+// GetPendingUsersHandler lists the users waiting for the approval of their account.
+// @Summary Get the pending users
+// @Description List the users who signed up while the registration required an approval, and wait for it
+// @ID get-pending-users
+// @Tags Users
+// @Produce json
+// @Success 200 {object} UsersResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/pending [get]
+func GetPendingUsersHandler(c *gin.Context) {
+	var users []models.User
+	if err := tenantDB(c).Where("status = ?", models.UserStatusPending).Order("created_at").Find(&users).Error; err != nil {
+		respondInternalError(c, "Error retrieving users", err)
+		return
+	}
+
+	response := UsersResponse{Users: []UserResponse{}}
+	for _, user := range users {
+		response.Users = append(response.Users, newUserResponse(user, []string{}))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ApproveUserMasterHandler approves the account of a pending user.
+// @Summary Approve a user
+// @Description Let a user waiting for the approval of its account log in
+// @ID approve-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageWithUserResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/approve [post]
+func ApproveUserMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findPendingUser(c, db)
+	if !ok {
+		return
+	}
+
+	if err := db.Model(&user).Update("status", models.UserStatusActive).Error; err != nil {
+		respondInternalError(c, "Error approving user", err)
+		return
+	}
+
+	msg, err := mailer.NewMessage(user.Email, mailer.TemplateAccountApproved, map[string]string{
+		"Name": user.Name,
+		"Link": appURL() + "/login",
+	})
+	if err == nil {
+		err = mailer.GetMailer().Send(msg)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending approval email", "user", user.ID, "error", err)
+	}
+
+	roles, _ := userRoleNames(db, user.ID)
+	c.JSON(http.StatusOK, MessageWithUserResponse{Message: "User approved successfully", User: newUserResponse(user, roles)})
+}
+
+// RejectUserMasterHandler rejects the account of a pending user.
+// @Summary Reject a user
+// @Description Delete the account of a user waiting for its approval. The email address and the name can sign up again
+// @ID reject-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/reject [post]
+func RejectUserMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findPendingUser(c, db)
+	if !ok {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.PasswordHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error rejecting user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User rejected successfully"})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/mailer"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func registrationTestRouter() *gin.Engine {
+	r := authTestRouter()
+	r.POST("/users", CreateUserHandler)
+	r.PUT("/settings/registration", AuthMiddleware, RequirePermission(models.PermissionSettingsManage), UpdateRegistrationSettingsHandler)
+	r.GET("/invites", AuthMiddleware, RequirePermission(models.PermissionUsersManage), GetInvitesHandler)
+	r.POST("/invites", AuthMiddleware, RequirePermission(models.PermissionUsersManage), CreateInviteHandler)
+	r.GET("/usersMaster/pending", AuthMiddleware, RequirePermission(models.PermissionUsersManage), GetPendingUsersHandler)
+	r.POST("/usersMaster/:id/approve", AuthMiddleware, RequirePermission(models.PermissionUsersManage), ApproveUserMasterHandler)
+	r.POST("/usersMaster/:id/reject", AuthMiddleware, RequirePermission(models.PermissionUsersManage), RejectUserMasterHandler)
+	r.POST("/workspaces", AuthMiddleware, CreateWorkspaceHandler)
+	r.POST("/workspaces/:workspaceId/invitations", AuthMiddleware, CreateWorkspaceInvitationHandler)
+	return r
+}
+
+func signup(r *gin.Engine, name string, email string, inviteToken string) (UserResponse, int) {
+	resp := postJSON(r, "/users", SignupBody{Name: name, Email: email, Password: "new-user-password", InviteToken: inviteToken})
+	var userResponse UserResponse
+	json.Unmarshal(resp.Body.Bytes(), &userResponse)
+	return userResponse, resp.Code
+}
+
+func TestInviteOnlyRegistration(t *testing.T) {
+	db := runInitDb()
+	defer db.Where("key = ?", settingRegistrationMode).Delete(&models.Setting{})
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	admin := createTestUser(t, db, "Registration Admin", "registration-admin@example.com", "password", true)
+	defer deleteTestUser(db, admin)
+	defer deleteExternalUser(db, "invited@example.com")
+	defer deleteExternalUser(db, "teammate@example.com")
+
+	r := registrationTestRouter()
+	token := login(t, r, admin.Name, "password").AccessToken
+
+	resp := requestJSONWithToken(r, "PUT", "/settings/registration", token, RegistrationSettings{Mode: "closed"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = requestJSONWithToken(r, "PUT", "/settings/registration", token, RegistrationSettings{Mode: registrationModeInvite})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	_, code := signup(r, "Uninvited", "uninvited@example.com", "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	resp = requestJSONWithToken(r, "POST", "/invites", token, InviteBody{Email: admin.Email})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp = requestJSONWithToken(r, "POST", "/invites", token, InviteBody{Email: "invited@example.com"})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	inviteToken := tokenFromMessage(capture.last())
+	assert.NotEmpty(t, inviteToken)
+
+	resp = requestJSONWithToken(r, "GET", "/invites", token, nil)
+	var invites InvitesResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &invites))
+	assert.Equal(t, 1, len(invites.Invites))
+
+	// the invite is only for its address, and only once
+	_, code = signup(r, "Uninvited", "uninvited@example.com", inviteToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	user, code := signup(r, "Invited", "invited@example.com", inviteToken)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, models.UserStatusActive, user.Status)
+	_, code = signup(r, "Invited Again", "invited-again@example.com", inviteToken)
+	assert.Equal(t, http.StatusBadRequest, code)
+	login(t, r, "Invited", "new-user-password")
+
+	// the owners of a workspace invite to sign up and join it
+	resp = requestJSONWithToken(r, "POST", "/workspaces", token, WorkspaceBody{Name: "Registration"})
+	var workspace WorkspaceResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &workspace))
+	defer deleteTestWorkspace(db, workspace.ID)
+	resp = requestJSONWithToken(r, "POST", "/workspaces/"+workspace.ID.String()+"/invitations", token, WorkspaceInvitationBody{Email: "teammate@example.com", Role: models.WorkspaceRoleEditor})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	user, code = signup(r, "Teammate", "teammate@example.com", tokenFromMessage(capture.last()))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, models.WorkspaceRoleEditor, workspaceRole(db, workspace.ID, user.ID))
+}
+
+func TestApprovalRegistration(t *testing.T) {
+	db := runInitDb()
+	defer db.Where("key = ?", settingRegistrationMode).Delete(&models.Setting{})
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	admin := createTestUser(t, db, "Approval Admin", "approval-admin@example.com", "password", true)
+	defer deleteTestUser(db, admin)
+	defer deleteExternalUser(db, "approved@example.com")
+	defer deleteExternalUser(db, "rejected@example.com")
+
+	r := registrationTestRouter()
+	token := login(t, r, admin.Name, "password").AccessToken
+
+	resp := requestJSONWithToken(r, "PUT", "/settings/registration", token, RegistrationSettings{Mode: registrationModeApproval})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	approved, code := signup(r, "Approved", "approved@example.com", "")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, models.UserStatusPending, approved.Status)
+	rejected, code := signup(r, "Rejected", "rejected@example.com", "")
+	assert.Equal(t, http.StatusCreated, code)
+
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: "Approved", Password: "new-user-password"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestJSONWithToken(r, "GET", "/usersMaster/pending", token, nil)
+	var pending UsersResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &pending))
+	assert.Equal(t, 2, len(pending.Users))
+
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+approved.ID.String()+"/approve", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"approved@example.com"}, capture.last().To)
+	login(t, r, "Approved", "new-user-password")
+
+	// only pending users are approved or rejected
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+approved.ID.String()+"/reject", token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+rejected.ID.String()+"/reject", token, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "rejected@example.com").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// invited users need no approval
+	resp = requestJSONWithToken(r, "POST", "/invites", token, InviteBody{Email: "rejected@example.com"})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	user, code := signup(r, "Rejected", "rejected@example.com", tokenFromMessage(capture.last()))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, models.UserStatusActive, user.Status)
+}
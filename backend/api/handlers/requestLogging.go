@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"document-manager/api/responses"
+	"document-manager/logging"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from the clients.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware identifies the request by the X-Request-ID header of
+// the client or of a proxy in front of the server, or by a new one, and
+// returns it in the X-Request-ID header of the response. The lines logged
+// with the context of the request carry it.
+func RequestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !isValidRequestID(id) {
+		id = uuid.NewString()
+	}
+	c.Set("request_id", id)
+	c.Header(requestIDHeader, id)
+	c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+	c.Next()
+}
+
+// isValidRequestID accepts the IDs of up to 128 letters, digits and the
+// punctuation of UUIDs and trace IDs, to keep the logs readable.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':', r == '/', r == '+', r == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogger logs every request once answered, as a warning when the
+// client erred and as an error when the server did. The secrets of the
+// query are redacted.
+func RequestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", c.Writer.Size()),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		attrs = append(attrs, slog.String("query", logging.RedactQuery(query)))
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, slog.String("errors", c.Errors.String()))
+	}
+	slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// RecoverPanic answers 500 to the requests whose handler panicked, logging
+// the panic with the request ID.
+func RecoverPanic(c *gin.Context, recovered any) {
+	slog.ErrorContext(c.Request.Context(), "Panic handling the request", "panic", recovered, "stack", string(debug.Stack()))
+	respondError(c, responses.CodeInternal, "Internal server error")
+}
+
+// setLogUser adds the authenticated user to the context of the request, and
+// so to its log lines.
+func setLogUser(c *gin.Context, userID uuid.UUID) {
+	c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), userID.String()))
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/logging"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var out bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&out, "info"))
+	defer slog.SetDefault(previous)
+
+	userID := uuid.New()
+	router := gin.New()
+	router.Use(RequestIDMiddleware, RequestLogger, gin.CustomRecoveryWithWriter(io.Discard, RecoverPanic))
+	router.GET("/logged", func(c *gin.Context) {
+		setLogUser(c, userID)
+		c.JSON(http.StatusOK, MessageResponse{Message: "ok"})
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("broken handler")
+	})
+
+	request, _ := http.NewRequest("GET", "/logged?code=secret-code&page=2", nil)
+	request.Header.Set(requestIDHeader, "trace-1234")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "trace-1234", resp.Header().Get(requestIDHeader))
+
+	var line map[string]any
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "trace-1234", line["request_id"])
+	assert.Equal(t, userID.String(), line["user_id"])
+	assert.Equal(t, "/logged", line["path"])
+	assert.Equal(t, float64(http.StatusOK), line["status"])
+	assert.Equal(t, "code=********&page=2", line["query"])
+	assert.NotContains(t, out.String(), "secret-code")
+
+	// IDs that would garble the logs are replaced
+	out.Reset()
+	request, _ = http.NewRequest("GET", "/panic", nil)
+	request.Header.Set(requestIDHeader, "bad id\n"+strings.Repeat("x", 10))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	id := resp.Header().Get(requestIDHeader)
+	_, err := uuid.Parse(id)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "broken handler")
+	assert.Equal(t, 2, strings.Count(out.String(), `"request_id":"`+id+`"`))
+	assert.Contains(t, out.String(), `"level":"ERROR"`)
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var messageRoleNotFound = "Role not found"
+
+var errLastAdmin = errors.New("the last user with the admin role cannot lose it")
+
+type RoleBody struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	BuiltIn     bool      `json:"built_in"`
+}
+
+type RolesResponse struct {
+	Roles []RoleResponse `json:"roles"`
+	// every permission a role can grant
+	Permissions []string `json:"permissions"`
+}
+
+type MessageWithRoleResponse struct {
+	Message string       `json:"message"`
+	Role    RoleResponse `json:"role"`
+}
+
+func newRoleResponse(role models.Role) RoleResponse {
+	return RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: strings.Fields(role.Permissions),
+		BuiltIn:     role.BuiltIn,
+	}
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, granted := range permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the token grants the permission.
+func (claims *Claims) HasPermission(permission string) bool {
+	return hasPermission(claims.Permissions, permission)
+}
+
+// validPermissions checks every permission exists and returns them sorted without duplicates.
+func validPermissions(permissions []string) ([]string, bool) {
+	unique := map[string]bool{}
+	for _, permission := range permissions {
+		if !hasPermission(models.Permissions, permission) {
+			return nil, false
+		}
+		unique[permission] = true
+	}
+
+	result := make([]string, 0, len(unique))
+	for permission := range unique {
+		result = append(result, permission)
+	}
+	sort.Strings(result)
+	return result, true
+}
+
+// rolePermissions returns the permissions granted to the user by its roles.
+func rolePermissions(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var granted []string
+	err := db.Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Pluck("roles.permissions", &granted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []string
+	for _, list := range granted {
+		permissions = append(permissions, strings.Fields(list)...)
+	}
+	permissions, _ = validPermissions(permissions)
+	return permissions, nil
+}
+
+// userRoleNames returns the names of the roles of the user.
+func userRoleNames(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	names := []string{}
+	err := db.Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &names).Error
+	return names, err
+}
+
+func userHasRole(db *gorm.DB, userID uuid.UUID, roleName string) bool {
+	var count int64
+	db.Model(&models.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.name = ?", userID, roleName).
+		Count(&count)
+	return count > 0
+}
+
+// isLastAdmin reports whether the user is the only one with the admin role.
+func isLastAdmin(db *gorm.DB, userID uuid.UUID) bool {
+	if !userHasRole(db, userID, models.RoleAdmin) {
+		return false
+	}
+	var count int64
+	db.Model(&models.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.name = ?", models.RoleAdmin).
+		Count(&count)
+	return count <= 1
+}
+
+// setUserRole grants or takes a role from the user. Taking a role ends the
+// sessions of the user, as its tokens carry the permissions of the role.
+func setUserRole(db *gorm.DB, userID uuid.UUID, role models.Role, granted bool) error {
+	if granted {
+		return db.Where(models.UserRole{UserID: userID, RoleID: role.ID}).FirstOrCreate(&models.UserRole{}).Error
+	}
+
+	if role.Name == models.RoleAdmin && isLastAdmin(db, userID) {
+		return errLastAdmin
+	}
+	result := db.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&models.UserRole{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return revokeUserSessions(db, userID)
+}
+
+// setUserRoleByName is setUserRole for a role known by its name.
+func setUserRoleByName(db *gorm.DB, userID uuid.UUID, roleName string, granted bool) error {
+	var role models.Role
+	if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return setUserRole(db, userID, role, granted)
+}
+
+// revokeRoleHolderSessions ends the sessions of every user with the role.
+func revokeRoleHolderSessions(db *gorm.DB, roleID uuid.UUID) error {
+	var userIDs []uuid.UUID
+	if err := db.Model(&models.UserRole{}).Where("role_id = ?", roleID).Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := revokeUserSessions(db, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequirePermission lets only the requests whose token grants the permission
+// through. It must follow AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(*Claims)
+		if !claims.HasPermission(permission) {
+			respondError(c, responses.CodeMissingPermission, "Missing permission "+permission)
+			return
+		}
+		c.Next()
+	}
+}
+
+// findRole loads the role of the roleId path parameter, answering the request when it fails.
+func findRole(c *gin.Context, db *gorm.DB) (models.Role, bool) {
+	var role models.Role
+	roleID, err := uuid.Parse(c.Param("roleId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid role ID")
+		return role, false
+	}
+	if err := db.Where(searchById, roleID).First(&role).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageRoleNotFound)
+		return role, false
+	}
+	return role, true
+}
+
+// GetRolesHandler lists the roles.
+// @Summary Get all roles
+// @Description List the roles and every permission a role can grant
+// @ID get-roles
+// @Tags Roles
+// @Produce json
+// @Success 200 {object} RolesResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles [get]
+func GetRolesHandler(c *gin.Context) {
+	var roles []models.Role
+	if err := tenantDB(c).Order("name").Find(&roles).Error; err != nil {
+		respondInternalError(c, "Error retrieving roles", err)
+		return
+	}
+
+	response := RolesResponse{Roles: make([]RoleResponse, 0, len(roles)), Permissions: models.Permissions}
+	for _, role := range roles {
+		response.Roles = append(response.Roles, newRoleResponse(role))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateRoleHandler creates a role.
+// @Summary Create a role
+// @Description Create a role with a set of permissions
+// @ID create-role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param role body RoleBody true "Role"
+// @Success 201 {object} RoleResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles [post]
+func CreateRoleHandler(c *gin.Context) {
+	var body RoleBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		respondInvalidFields(c, "Name is required", responses.FieldError{Field: "name", Message: "is required"})
+		return
+	}
+	permissions, ok := validPermissions(body.Permissions)
+	if !ok {
+		respondInvalidFields(c, "Invalid permission", responses.FieldError{Field: "permissions", Message: "contains an unknown permission"})
+		return
+	}
+
+	role := models.Role{
+		ID:          uuid.New(),
+		Name:        body.Name,
+		Description: body.Description,
+		Permissions: strings.Join(permissions, " "),
+	}
+	if err := tenantDB(c).Create(&role).Error; err != nil {
+		respondError(c, responses.CodeAlreadyExists, "Error creating role. Name already in use")
+		return
+	}
+
+	c.JSON(http.StatusCreated, newRoleResponse(role))
+}
+
+// UpdateRoleHandler updates a role.
+// @Summary Update a role
+// @Description Update the name, description and permissions of a role. Only the description of built-in roles can change.
+// @ID update-role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param roleId path string true "Role ID"
+// @Param role body RoleBody true "Role"
+// @Success 200 {object} MessageWithRoleResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles/{roleId} [put]
+func UpdateRoleHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	role, ok := findRole(c, db)
+	if !ok {
+		return
+	}
+
+	var body RoleBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	permissions, ok := validPermissions(body.Permissions)
+	if !ok {
+		respondInvalidFields(c, "Invalid permission", responses.FieldError{Field: "permissions", Message: "contains an unknown permission"})
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if role.BuiltIn && ((name != "" && name != role.Name) || body.Permissions != nil) {
+		respondError(c, responses.CodeBadRequest, "Only the description of a built-in role can change")
+		return
+	}
+
+	// holders keep the removed permissions in their tokens until they log in again
+	reduced := false
+	if body.Permissions != nil {
+		for _, permission := range strings.Fields(role.Permissions) {
+			reduced = reduced || !hasPermission(permissions, permission)
+		}
+		role.Permissions = strings.Join(permissions, " ")
+	}
+	if name != "" {
+		role.Name = name
+	}
+	role.Description = body.Description
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&role).Error; err != nil {
+			return err
+		}
+		if reduced {
+			return revokeRoleHolderSessions(tx, role.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, responses.CodeConflict, "Error updating role")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully", "role": newRoleResponse(role)})
+}
+
+// DeleteRoleHandler deletes a role.
+// @Summary Delete a role
+// @Description Delete a role, taking it from its users. Built-in roles cannot be deleted.
+// @ID delete-role
+// @Tags Roles
+// @Produce json
+// @Param roleId path string true "Role ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles/{roleId} [delete]
+func DeleteRoleHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	role, ok := findRole(c, db)
+	if !ok {
+		return
+	}
+	if role.BuiltIn {
+		respondError(c, responses.CodeBadRequest, "Built-in roles cannot be deleted")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeRoleHolderSessions(tx, role.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error deleting role", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// GetRoleUsersHandler lists the users with a role.
+// @Summary Get the users of a role
+// @Description List the users with a role
+// @ID get-role-users
+// @Tags Roles
+// @Produce json
+// @Param roleId path string true "Role ID"
+// @Success 200 {object} UsersResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles/{roleId}/users [get]
+func GetRoleUsersHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	role, ok := findRole(c, db)
+	if !ok {
+		return
+	}
+
+	var users []models.User
+	err := db.Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", role.ID).
+		Order("users.name").
+		Find(&users).Error
+	if err != nil {
+		respondInternalError(c, "Error retrieving users", err)
+		return
+	}
+
+	response := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		roles, _ := userRoleNames(db, user.ID)
+		response = append(response, newUserResponse(user, roles))
+	}
+	c.JSON(http.StatusOK, UsersResponse{Users: response})
+}
+
+// AssignRoleHandler grants a role to a user.
+// @Summary Assign a role
+// @Description Grant a role to a user. The permissions are in the tokens issued from then on.
+// @ID assign-role
+// @Tags Roles
+// @Produce json
+// @Param roleId path string true "Role ID"
+// @Param userId path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles/{roleId}/users/{userId} [put]
+func AssignRoleHandler(c *gin.Context) {
+	changeUserRole(c, true)
+}
+
+// UnassignRoleHandler takes a role from a user.
+// @Summary Unassign a role
+// @Description Take a role from a user, ending its sessions
+// @ID unassign-role
+// @Tags Roles
+// @Produce json
+// @Param roleId path string true "Role ID"
+// @Param userId path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /roles/{roleId}/users/{userId} [delete]
+func UnassignRoleHandler(c *gin.Context) {
+	changeUserRole(c, false)
+}
+
+func changeUserRole(c *gin.Context, granted bool) {
+	db := tenantDB(c)
+
+	role, ok := findRole(c, db)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return
+	}
+	var user models.User
+	if err := db.Where(searchById, userID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	if err := setUserRole(db, user.ID, role, granted); err != nil {
+		if errors.Is(err, errLastAdmin) {
+			respondError(c, responses.CodeLastAdmin, "The last user with the admin role cannot lose it")
+			return
+		}
+		respondInternalError(c, "Error changing user role", err)
+		return
+	}
+
+	if granted {
+		c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Role unassigned successfully"})
+	}
+}
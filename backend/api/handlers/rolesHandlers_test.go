@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func rolesTestRouter() *gin.Engine {
+	r := sessionsTestRouter()
+	manage := RequirePermission(models.PermissionRolesManage)
+	r.GET("/roles", AuthMiddleware, manage, GetRolesHandler)
+	r.POST("/roles", AuthMiddleware, manage, CreateRoleHandler)
+	r.PUT("/roles/:roleId", AuthMiddleware, manage, UpdateRoleHandler)
+	r.DELETE("/roles/:roleId", AuthMiddleware, manage, DeleteRoleHandler)
+	r.GET("/roles/:roleId/users", AuthMiddleware, manage, GetRoleUsersHandler)
+	r.PUT("/roles/:roleId/users/:userId", AuthMiddleware, manage, AssignRoleHandler)
+	r.DELETE("/roles/:roleId/users/:userId", AuthMiddleware, manage, UnassignRoleHandler)
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/read-all", AuthMiddleware, RequirePermission(models.PermissionDocumentsReadAll), ok)
+	return r
+}
+
+func TestRolesHandlers(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Roles Admin", "roles-admin@example.com", "password", true)
+	user := createTestUser(t, db, "Roles User", "roles-user@example.com", "password", false)
+	defer deleteTestUser(db, admin)
+	defer deleteTestUser(db, user)
+	defer db.Where("name = ?", "auditor").Delete(&models.Role{})
+
+	r := rolesTestRouter()
+	adminLogin := login(t, r, admin.Name, "password")
+	userLogin := login(t, r, user.Name, "password")
+
+	resp := requestWithToken(r, "GET", "/roles", userLogin.AccessToken)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestWithToken(r, "GET", "/read-all", userLogin.AccessToken)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestJSONWithToken(r, "POST", "/roles", adminLogin.AccessToken, RoleBody{Name: "auditor", Permissions: []string{"documents:everything"}})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = requestJSONWithToken(r, "POST", "/roles", adminLogin.AccessToken, RoleBody{
+		Name:        "auditor",
+		Permissions: []string{models.PermissionDocumentsReadAll, models.PermissionAuditRead},
+	})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var role RoleResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &role))
+	assert.Equal(t, []string{models.PermissionAuditRead, models.PermissionDocumentsReadAll}, role.Permissions)
+	assert.False(t, role.BuiltIn)
+
+	resp = requestJSONWithToken(r, "POST", "/roles", adminLogin.AccessToken, RoleBody{Name: "auditor"})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = requestWithToken(r, "PUT", "/roles/"+role.ID.String()+"/users/"+user.ID.String(), adminLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the permissions are in the tokens issued after the assignment
+	userLogin = login(t, r, user.Name, "password")
+	assert.Equal(t, []string{"auditor"}, userLogin.User.Roles)
+	resp = requestWithToken(r, "GET", "/read-all", userLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = requestWithToken(r, "GET", "/roles/"+role.ID.String()+"/users", adminLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var holders UsersResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &holders))
+	assert.Equal(t, 1, len(holders.Users))
+	assert.Equal(t, user.ID, holders.Users[0].ID)
+
+	// removing a permission of the role ends the sessions of its users
+	resp = requestJSONWithToken(r, "PUT", "/roles/"+role.ID.String(), adminLogin.AccessToken, RoleBody{Permissions: []string{models.PermissionDocumentsReadAll}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestWithToken(r, "GET", "/read-all", userLogin.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// and so does taking the role
+	userLogin = login(t, r, user.Name, "password")
+	resp = requestWithToken(r, "DELETE", "/roles/"+role.ID.String()+"/users/"+user.ID.String(), adminLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestWithToken(r, "GET", "/sessions", userLogin.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.False(t, userHasRole(db, user.ID, "auditor"))
+
+	resp = requestWithToken(r, "DELETE", "/roles/"+role.ID.String(), adminLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestWithToken(r, "DELETE", "/roles/"+role.ID.String(), adminLogin.AccessToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestBuiltInAdminRole(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Built-in Admin", "built-in-admin@example.com", "password", true)
+	defer deleteTestUser(db, admin)
+
+	r := rolesTestRouter()
+	adminLogin := login(t, r, admin.Name, "password")
+	assert.Equal(t, []string{models.RoleAdmin}, adminLogin.User.Roles)
+
+	resp := requestWithToken(r, "GET", "/roles", adminLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var roles RolesResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &roles))
+	assert.Equal(t, models.Permissions, roles.Permissions)
+
+	var adminRole RoleResponse
+	for _, role := range roles.Roles {
+		if role.Name == models.RoleAdmin {
+			adminRole = role
+		}
+	}
+	assert.True(t, adminRole.BuiltIn)
+	assert.ElementsMatch(t, models.Permissions, adminRole.Permissions)
+
+	resp = requestJSONWithToken(r, "PUT", "/roles/"+adminRole.ID.String(), adminLogin.AccessToken, RoleBody{Permissions: []string{}})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = requestWithToken(r, "DELETE", "/roles/"+adminRole.ID.String(), adminLogin.AccessToken)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// the description of a built-in role can change
+	resp = requestJSONWithToken(r, "PUT", "/roles/"+adminRole.ID.String(), adminLogin.AccessToken, RoleBody{Description: adminRole.Description})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestLastAdminKeepsRole(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Last Admin", "last-admin@example.com", "password", true)
+	defer deleteTestUser(db, admin)
+
+	var role models.Role
+	assert.Nil(t, db.Where("name = ?", models.RoleAdmin).First(&role).Error)
+
+	// the other admins are removed in a transaction that is rolled back
+	tx := db.Begin()
+	defer tx.Rollback()
+	assert.Nil(t, tx.Where("role_id = ? AND user_id <> ?", role.ID, admin.ID).Delete(&models.UserRole{}).Error)
+
+	assert.ErrorIs(t, setUserRole(tx, admin.ID, role, false), errLastAdmin)
+	assert.True(t, userHasRole(tx, admin.ID, models.RoleAdmin))
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// sessionLastSeenInterval limits how often a request updates the last seen time of its session.
+var sessionLastSeenInterval = time.Minute
+
+var messageSessionNotFound = "Session not found"
+
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// describeUserAgent turns a User-Agent header into a short label such as "Firefox on Linux".
+func describeUserAgent(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	var system string
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		system = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		system = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		system = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		system = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		system = "Linux"
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	case userAgent != "":
+		return userAgent
+	default:
+		return "Unknown device"
+	}
+}
+
+// createSession records a new login of the user from the request.
+func createSession(db *gorm.DB, c *gin.Context, userID uuid.UUID) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	err := db.Create(&session).Error
+	return session, err
+}
+
+// sessionIsActive reports whether the session exists and was not revoked,
+// refreshing its last seen time on the way.
+func sessionIsActive(db *gorm.DB, sessionID uuid.UUID) bool {
+	var session models.Session
+	if err := db.Where("id = ? AND revoked_at IS NULL", sessionID).First(&session).Error; err != nil {
+		return false
+	}
+
+	if time.Since(session.LastSeenAt) > sessionLastSeenInterval {
+		touchSession(db, sessionID)
+	}
+	return true
+}
+
+func touchSession(db *gorm.DB, sessionID uuid.UUID) {
+	db.Model(&models.Session{}).Where("id = ?", sessionID).Update("last_seen_at", time.Now())
+}
+
+// revokeSession revokes a session and every refresh token issued for it.
+func revokeSession(db *gorm.DB, sessionID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL", sessionID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return revokeRefreshTokenFamily(tx, sessionID)
+	})
+}
+
+// revokeUserSessions revokes every session of a user.
+func revokeUserSessions(db *gorm.DB, userID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return revokeUserRefreshTokens(tx, userID)
+	})
+}
+
+func listActiveSessions(db *gorm.DB, userID uuid.UUID, currentSessionID uuid.UUID) ([]SessionResponse, error) {
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			UserID:     session.UserID,
+			Device:     describeUserAgent(session.UserAgent),
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+	return response, nil
+}
+
+// revokeSessionOfUser revokes a session only if it belongs to userID.
+func revokeSessionOfUser(c *gin.Context, userID uuid.UUID) {
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid session ID")
+		return
+	}
+
+	db := tenantDB(c)
+
+	var session models.Session
+	if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageSessionNotFound)
+		return
+	}
+
+	if err := revokeSession(db, session.ID); err != nil {
+		respondInternalError(c, "Error revoking session", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// GetSessionsHandler lists the active sessions of the logged user.
+// @Summary Get my sessions
+// @Description List the active sessions of the logged user
+// @ID get-sessions
+// @Tags Sessions
+// @Produce json
+// @Success 200 {object} SessionsResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /sessions [get]
+func GetSessionsHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	sessions, err := listActiveSessions(tenantDB(c), claims.UserID, claims.SessionID)
+	if err != nil {
+		respondInternalError(c, "Error retrieving sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSessionHandler revokes one of the sessions of the logged user.
+// @Summary Revoke one of my sessions
+// @Description Revoke a session of the logged user
+// @ID revoke-session
+// @Tags Sessions
+// @Produce json
+// @Param sessionId path string true "Session ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /sessions/{sessionId} [delete]
+func RevokeSessionHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	revokeSessionOfUser(c, claims.UserID)
+}
+
+// GetUserSessionsMasterHandler lists the active sessions of any user.
+// @Summary Get the sessions of a user
+// @Description List the active sessions of a user
+// @ID get-user-sessions-master
+// @Tags Sessions
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} SessionsResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/sessions [get]
+func GetUserSessionsMasterHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return
+	}
+
+	claims := c.MustGet("claims").(*Claims)
+
+	sessions, err := listActiveSessions(tenantDB(c), userID, claims.SessionID)
+	if err != nil {
+		respondInternalError(c, "Error retrieving sessions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeUserSessionMasterHandler revokes a session of any user.
+// @Summary Revoke a session of a user
+// @Description Revoke a session of a user
+// @ID revoke-user-session-master
+// @Tags Sessions
+// @Produce json
+// @Param id path string true "User ID"
+// @Param sessionId path string true "Session ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/sessions/{sessionId} [delete]
+func RevokeUserSessionMasterHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return
+	}
+	revokeSessionOfUser(c, userID)
+}
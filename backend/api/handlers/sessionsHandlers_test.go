@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithToken(r *gin.Engine, method string, path string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+// currentSessionID returns the ID of the session the access token belongs to.
+func currentSessionID(t *testing.T, r *gin.Engine, token string) string {
+	resp := requestWithToken(r, "GET", "/sessions", token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var response SessionsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	for _, session := range response.Sessions {
+		if session.Current {
+			return session.ID.String()
+		}
+	}
+	return ""
+}
+
+func sessionsTestRouter() *gin.Engine {
+	r := authTestRouter()
+	r.GET("/sessions", AuthMiddleware, GetSessionsHandler)
+	r.DELETE("/sessions/:sessionId", AuthMiddleware, RevokeSessionHandler)
+	r.GET("/usersMaster/:id/sessions", AuthMiddleware, RequirePermission(models.PermissionUsersManage), GetUserSessionsMasterHandler)
+	r.DELETE("/usersMaster/:id/sessions/:sessionId", AuthMiddleware, RequirePermission(models.PermissionUsersManage), RevokeUserSessionMasterHandler)
+	return r
+}
+
+func TestGetSessionsHandler(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Sessions User", "sessions@example.com", "password", false)
+	defer deleteTestUser(db, user)
+
+	r := sessionsTestRouter()
+	first := login(t, r, user.Name, "password")
+	login(t, r, user.Name, "password")
+
+	resp := requestWithToken(r, "GET", "/sessions", first.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var response SessionsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, 2, len(response.Sessions))
+
+	current := 0
+	for _, session := range response.Sessions {
+		assert.Equal(t, user.ID, session.UserID)
+		if session.Current {
+			current++
+		}
+	}
+	assert.Equal(t, 1, current)
+}
+
+func TestRevokeSessionHandler(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Revoke Session User", "revoke-session@example.com", "password", false)
+	other := createTestUser(t, db, "Other Session User", "other-session@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer deleteTestUser(db, other)
+
+	r := sessionsTestRouter()
+	first := login(t, r, user.Name, "password")
+	second := login(t, r, user.Name, "password")
+	otherLogin := login(t, r, other.Name, "password")
+
+	secondSessionID := currentSessionID(t, r, second.AccessToken)
+	assert.NotEqual(t, "", secondSessionID)
+
+	// a user cannot revoke the sessions of somebody else
+	resp := requestWithToken(r, "DELETE", "/sessions/"+secondSessionID, otherLogin.AccessToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestWithToken(r, "DELETE", "/sessions/"+secondSessionID, first.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// the access token of the revoked session is rejected right away
+	resp = requestWithToken(r, "GET", "/sessions", second.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: second.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = requestWithToken(r, "GET", "/sessions", first.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestUserSessionsMasterHandlers(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Master Sessions User", "master-sessions@example.com", "password", false)
+	master := createTestUser(t, db, "Master Sessions Admin", "master-sessions-admin@example.com", "password", true)
+	defer deleteTestUser(db, user)
+	defer deleteTestUser(db, master)
+
+	r := sessionsTestRouter()
+	userLogin := login(t, r, user.Name, "password")
+	masterLogin := login(t, r, master.Name, "password")
+
+	resp := requestWithToken(r, "GET", "/usersMaster/"+user.ID.String()+"/sessions", userLogin.AccessToken)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestWithToken(r, "GET", "/usersMaster/"+user.ID.String()+"/sessions", masterLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var response SessionsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, 1, len(response.Sessions))
+	assert.False(t, response.Sessions[0].Current)
+
+	path := "/usersMaster/" + user.ID.String() + "/sessions/" + response.Sessions[0].ID.String()
+	resp = requestWithToken(r, "DELETE", path, masterLogin.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = requestWithToken(r, "GET", "/sessions", userLogin.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	chrome := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	safari := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+
+	assert.Equal(t, "Firefox on Linux", describeUserAgent(firefox))
+	assert.Equal(t, "Chrome on Windows", describeUserAgent(chrome))
+	assert.Equal(t, "Safari on iOS", describeUserAgent(safari))
+	assert.Equal(t, "curl/8.5.0", describeUserAgent("curl/8.5.0"))
+	assert.Equal(t, "Unknown device", describeUserAgent(""))
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const settingRequireMasterTwoFactor = "require_master_2fa"
+
+type SecuritySettings struct {
+	// applies to every user whose roles grant permissions
+	RequireMasterTwoFactor bool `json:"require_master_2fa"`
+}
+
+// getSetting returns the value of a setting, or fallback when it was never set.
+func getSetting(db *gorm.DB, key string, fallback string) string {
+	var setting models.Setting
+	if err := db.Where("key = ?", key).First(&setting).Error; err != nil {
+		return fallback
+	}
+	return setting.Value
+}
+
+func setSetting(db *gorm.DB, key string, value string) error {
+	// not Save: the tenant, part of the primary key, is only assigned on create
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Setting{Key: key, Value: value}).Error
+}
+
+func loadSecuritySettings(db *gorm.DB) SecuritySettings {
+	requireMasterTwoFactor, _ := strconv.ParseBool(getSetting(db, settingRequireMasterTwoFactor, "false"))
+	return SecuritySettings{RequireMasterTwoFactor: requireMasterTwoFactor}
+}
+
+// GetSecuritySettingsHandler gets the security settings.
+// @Summary Get security settings
+// @Description Get the security settings of the server
+// @ID get-security-settings
+// @Tags Settings
+// @Produce json
+// @Success 200 {object} SecuritySettings
+// @Failure 401 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/security [get]
+func GetSecuritySettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, loadSecuritySettings(tenantDB(c)))
+}
+
+// UpdateSecuritySettingsHandler updates the security settings.
+// @Summary Update security settings
+// @Description Update the security settings of the server
+// @ID update-security-settings
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param settings body SecuritySettings true "Security settings"
+// @Success 200 {object} SecuritySettings
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/security [put]
+func UpdateSecuritySettingsHandler(c *gin.Context) {
+	var settings SecuritySettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	if err := setSetting(db, settingRequireMasterTwoFactor, strconv.FormatBool(settings.RequireMasterTwoFactor)); err != nil {
+		respondInternalError(c, "Error updating settings", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loadSecuritySettings(db))
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"document-manager/api/responses"
+	"document-manager/metrics"
+	"document-manager/sso"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const tokenPurposeSSOState = "sso_state"
+const ssoStateCookie = "sso_state"
+
+var ssoStateTTL = time.Minute * 10
+
+// ssoStateClaims keep what the callback needs to check, signed in a cookie
+// for the time the user spends at the identity provider.
+type ssoStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Purpose  string `json:"purpose"`
+	jwt.StandardClaims
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// ssoRedirect sends the browser back to the frontend. The values go in the
+// fragment so the tokens never reach a server log.
+func ssoRedirect(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, appURL()+"/sso-callback#"+values.Encode())
+}
+
+func ssoRedirectError(c *gin.Context, message string) {
+	metrics.RecordLogin(metrics.LoginSSO, false)
+	ssoRedirect(c, url.Values{"error": {message}})
+}
+
+// SSOLoginHandler starts a single sign-on login at the identity provider.
+// @Summary Single sign-on login
+// @Description Redirect to the OpenID Connect identity provider
+// @ID sso-login
+// @Tags Auth
+// @Success 302
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /sso/login [get]
+func SSOLoginHandler(c *gin.Context) {
+	provider := sso.GetProvider()
+	if provider == nil {
+		respondError(c, responses.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		respondInternalError(c, "Error starting single sign-on", err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		respondInternalError(c, "Error starting single sign-on", err)
+		return
+	}
+	verifier := sso.NewVerifier()
+
+	claims := &ssoStateClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Purpose:  tokenPurposeSSOState,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ssoStateTTL).Unix(),
+		},
+	}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey())
+	if err != nil {
+		respondInternalError(c, "Error starting single sign-on", err)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, cookie, int(ssoStateTTL.Seconds()), "/api/sso", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, verifier))
+}
+
+// SSOCallbackHandler completes a single sign-on login and redirects to the
+// frontend with the tokens, or with a challenge token when the user has
+// two-factor authentication enabled.
+// @Summary Single sign-on callback
+// @Description Redirect target of the identity provider
+// @ID sso-callback
+// @Tags Auth
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 302
+// @Failure 404 {object} responses.Problem
+// @Router /sso/callback [get]
+func SSOCallbackHandler(c *gin.Context) {
+	provider := sso.GetProvider()
+	if provider == nil {
+		respondError(c, responses.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		ssoRedirectError(c, providerError)
+		return
+	}
+
+	cookie, err := c.Cookie(ssoStateCookie)
+	if err != nil {
+		ssoRedirectError(c, "missing_state")
+		return
+	}
+	c.SetCookie(ssoStateCookie, "", -1, "/api/sso", "", c.Request.TLS != nil, true)
+
+	claims := &ssoStateClaims{}
+	token, err := jwt.ParseWithClaims(cookie, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errInvalidAccountToken
+		}
+		return jwtKey(), nil
+	})
+	if err != nil || !token.Valid || claims.Purpose != tokenPurposeSSOState || claims.State != c.Query("state") {
+		ssoRedirectError(c, "invalid_state")
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), claims.Verifier, claims.Nonce)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error completing single sign-on", "error", err)
+		ssoRedirectError(c, "login_failed")
+		return
+	}
+
+	db := tenantDB(c)
+
+	groupAdmin, groupMapped := provider.MasterFromGroups(identity)
+	user, err := provisionExternalUser(db, externalIdentity{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Names:         []string{identity.PreferredUsername, identity.Name},
+		Admin:         groupAdmin,
+		AdminMapped:   groupMapped,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error provisioning single sign-on user", "subject", identity.Subject, "error", err)
+		if errors.Is(err, errExternalEmailRequired) || errors.Is(err, errExternalEmailNotVerified) {
+			ssoRedirectError(c, "email_not_verified")
+			return
+		}
+		ssoRedirectError(c, "login_failed")
+		return
+	}
+
+	if user.TOTPEnabled {
+		challengeToken, err := issueChallengeToken(user.ID)
+		if err != nil {
+			ssoRedirectError(c, "login_failed")
+			return
+		}
+		metrics.RecordLogin(metrics.LoginSSO, true)
+		ssoRedirect(c, url.Values{"two_factor_required": {"true"}, "challenge_token": {challengeToken}})
+		return
+	}
+
+	accessToken, refreshToken, err := startSession(c, db, user)
+	if errors.Is(err, errAccountPending) {
+		ssoRedirectError(c, "account_pending")
+		return
+	}
+	if errors.Is(err, errAccountSuspended) {
+		ssoRedirectError(c, "account_suspended")
+		return
+	}
+	if err != nil {
+		ssoRedirectError(c, "login_failed")
+		return
+	}
+
+	values := url.Values{"access_token": {accessToken}, "refresh_token": {refreshToken}}
+	if twoFactorEnrollmentRequired(db, user) {
+		values.Set("two_factor_enrollment_required", "true")
+	}
+	metrics.RecordLogin(metrics.LoginSSO, true)
+	ssoRedirect(c, values)
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/sso"
+	"document-manager/sso/ssotest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func ssoTestRouter() *gin.Engine {
+	r := sessionsTestRouter()
+	r.GET("/sso/login", SSOLoginHandler)
+	r.GET("/sso/callback", SSOCallbackHandler)
+	return r
+}
+
+func newTestSSOProvider(t *testing.T) *ssotest.Server {
+	server := ssotest.NewServer("document-manager")
+	t.Cleanup(server.Close)
+
+	provider, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:      server.URL,
+		ClientID:    "document-manager",
+		RedirectURL: "http://localhost:3450/api/sso/callback",
+		MasterGroup: "admins",
+	})
+	assert.Nil(t, err)
+	sso.SetProvider(provider)
+	t.Cleanup(func() { sso.SetProvider(nil) })
+	return server
+}
+
+// ssoLogin goes through the whole single sign-on flow and returns the values
+// of the fragment the browser is redirected to.
+func ssoLogin(t *testing.T, r *gin.Engine) url.Values {
+	req, _ := http.NewRequest("GET", "/sso/login", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusFound, resp.Code)
+
+	cookies := resp.Result().Cookies()
+	assert.Equal(t, 1, len(cookies))
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	providerResp, err := client.Get(resp.Header().Get("Location"))
+	assert.Nil(t, err)
+	providerResp.Body.Close()
+	assert.Equal(t, http.StatusFound, providerResp.StatusCode)
+
+	callback, err := url.Parse(providerResp.Header.Get("Location"))
+	assert.Nil(t, err)
+
+	req, _ = http.NewRequest("GET", "/sso/callback?"+callback.RawQuery, nil)
+	req.AddCookie(cookies[0])
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusFound, resp.Code)
+
+	location, err := url.Parse(resp.Header().Get("Location"))
+	assert.Nil(t, err)
+	values, err := url.ParseQuery(location.Fragment)
+	assert.Nil(t, err)
+	return values
+}
+
+func deleteExternalUser(db *gorm.DB, email string) {
+	var user models.User
+	if db.Where("email = ?", email).First(&user).Error == nil {
+		db.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{})
+		deleteTestUser(db, user)
+	}
+}
+
+func TestSSONotConfigured(t *testing.T) {
+	r := ssoTestRouter()
+	req, _ := http.NewRequest("GET", "/sso/login", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestSSOCreatesUser(t *testing.T) {
+	db := runInitDb()
+	server := newTestSSOProvider(t)
+	defer deleteExternalUser(db, "sso-new@example.com")
+
+	server.SetUser(ssotest.User{
+		Subject:           "sso-new",
+		Email:             "sso-new@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "sso-new",
+	})
+
+	r := ssoTestRouter()
+	values := ssoLogin(t, r)
+	assert.Equal(t, "", values.Get("error"))
+	assert.NotEqual(t, "", values.Get("refresh_token"))
+
+	resp := requestWithToken(r, "GET", "/sessions", values.Get("access_token"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var user models.User
+	assert.Nil(t, db.Where("email = ?", "sso-new@example.com").First(&user).Error)
+	assert.Equal(t, "sso-new", user.Name)
+	assert.True(t, user.EmailVerified)
+	assert.False(t, userHasRole(db, user.ID, models.RoleAdmin))
+
+	// the next login finds the same user through the identity
+	values = ssoLogin(t, r)
+	assert.NotEqual(t, "", values.Get("access_token"))
+
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "sso-new@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// local login is not possible without a password
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: ""})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestSSOLinksUserByVerifiedEmail(t *testing.T) {
+	db := runInitDb()
+	server := newTestSSOProvider(t)
+	user := createTestUser(t, db, "sso-existing", "sso-existing@example.com", "password", false)
+	defer deleteExternalUser(db, user.Email)
+	assert.Nil(t, db.Model(&user).Update("email_verified", true).Error)
+
+	r := ssoTestRouter()
+
+	// an unverified email cannot take over the account
+	server.SetUser(ssotest.User{Subject: "sso-existing", Email: user.Email})
+	values := ssoLogin(t, r)
+	assert.Equal(t, "email_not_verified", values.Get("error"))
+	assert.Equal(t, "", values.Get("access_token"))
+
+	server.SetUser(ssotest.User{Subject: "sso-existing", Email: user.Email, EmailVerified: true, Groups: []string{"admins"}})
+	values = ssoLogin(t, r)
+	assert.NotEqual(t, "", values.Get("access_token"))
+
+	var identity models.UserIdentity
+	assert.Nil(t, db.Where("subject = ?", "sso-existing").First(&identity).Error)
+	assert.Equal(t, user.ID, identity.UserID)
+
+	// the admin group of the identity provider gives the user the admin role
+	var linked models.User
+	assert.Nil(t, db.First(&linked, searchById, user.ID).Error)
+	assert.True(t, userHasRole(db, user.ID, models.RoleAdmin))
+	assert.True(t, linked.EmailVerified)
+	login(t, r, user.Name, "password")
+
+	// and leaving the group takes it back
+	server.SetUser(ssotest.User{Subject: "sso-existing", Email: user.Email, EmailVerified: true})
+	ssoLogin(t, r)
+	assert.False(t, userHasRole(db, user.ID, models.RoleAdmin))
+}
+
+func TestSSOTakesOverUnverifiedUser(t *testing.T) {
+	db := runInitDb()
+	server := newTestSSOProvider(t)
+	// someone registered the email of somebody else, without verifying it
+	user := createTestUser(t, db, "sso-squatter", "sso-victim@example.com", "squatter-password", false)
+	defer deleteExternalUser(db, user.Email)
+
+	r := ssoTestRouter()
+	squatter := login(t, r, user.Name, "squatter-password")
+	key := models.APIKey{ID: uuid.New(), UserID: user.ID, Name: "squatter", Prefix: "dm_squat", KeyHash: "sso-squatter-key", Scopes: "documents:read"}
+	assert.Nil(t, db.Create(&key).Error)
+	defer db.Delete(&key)
+	assert.Nil(t, db.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP"}).Error)
+
+	// the owner of the email logs in through the provider
+	server.SetUser(ssotest.User{Subject: "sso-victim", Email: user.Email, EmailVerified: true})
+	values := ssoLogin(t, r)
+	assert.Equal(t, "", values.Get("error"))
+	assert.NotEqual(t, "", values.Get("access_token"))
+
+	var identity models.UserIdentity
+	assert.Nil(t, db.Where("subject = ?", "sso-victim").First(&identity).Error)
+	assert.Equal(t, user.ID, identity.UserID)
+
+	// and nothing the squatter set up works anymore
+	var claimed models.User
+	assert.Nil(t, db.First(&claimed, searchById, user.ID).Error)
+	assert.True(t, claimed.EmailVerified)
+	assert.False(t, claimed.TOTPEnabled)
+	assert.Equal(t, "", claimed.Password)
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "squatter-password"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = requestWithToken(r, "GET", "/sessions", squatter.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Nil(t, db.First(&key, searchById, key.ID).Error)
+	assert.NotNil(t, key.RevokedAt)
+}
+
+func TestSSOCallbackRejectsWrongState(t *testing.T) {
+	newTestSSOProvider(t)
+	r := ssoTestRouter()
+
+	req, _ := http.NewRequest("GET", "/sso/login", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	cookies := resp.Result().Cookies()
+
+	req, _ = http.NewRequest("GET", "/sso/callback?code=code&state=other", nil)
+	req.AddCookie(cookies[0])
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusFound, resp.Code)
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	values, _ := url.ParseQuery(location.Fragment)
+	assert.Equal(t, "invalid_state", values.Get("error"))
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/config"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	settingStorageMaxFileBytes      = "storage_max_file_bytes"
+	settingStorageUserMaxBytes      = "storage_user_max_bytes"
+	settingStorageUserMaxFiles      = "storage_user_max_files"
+	settingStorageWorkspaceMaxBytes = "storage_workspace_max_bytes"
+	settingStorageWorkspaceMaxFiles = "storage_workspace_max_files"
+)
+
+// uploads may be this much larger than their file, for the other form fields
+const multipartOverhead = 1 << 20
+
+// the files of the uploads are kept in memory up to this size, and in
+// temporary files beyond it
+const uploadMemoryBytes = 32 << 20
+
+// StorageSettings are the default storage quotas of the users and the
+// workspaces. Zero means unlimited.
+type StorageSettings struct {
+	MaxFileBytes      int64 `json:"max_file_bytes" binding:"min=0"`
+	UserMaxBytes      int64 `json:"user_max_bytes" binding:"min=0"`
+	UserMaxFiles      int64 `json:"user_max_files" binding:"min=0"`
+	WorkspaceMaxBytes int64 `json:"workspace_max_bytes" binding:"min=0"`
+	WorkspaceMaxFiles int64 `json:"workspace_max_files" binding:"min=0"`
+}
+
+// StorageQuotaBody replaces the default quota of a user or a workspace.
+type StorageQuotaBody struct {
+	MaxBytes int64 `json:"max_bytes" binding:"min=0"`
+	MaxFiles int64 `json:"max_files" binding:"min=0"`
+}
+
+type StorageUsageResponse struct {
+	User         services.StorageUsage `json:"user"`
+	Workspace    services.StorageUsage `json:"workspace"`
+	MaxFileBytes int64                 `json:"max_file_bytes"`
+}
+
+// StorageQuotaExceededResponse is the storage_quota_exceeded problem, with the
+// quota, of the user or of the workspace, and its usage.
+type StorageQuotaExceededResponse struct {
+	responses.Problem
+	Quota string                `json:"quota"`
+	Usage services.StorageUsage `json:"usage"`
+}
+
+func loadStorageSettings(db *gorm.DB) StorageSettings {
+	setting := func(key string) int64 {
+		value, _ := strconv.ParseInt(getSetting(db, key, "0"), 10, 64)
+		return value
+	}
+	return StorageSettings{
+		MaxFileBytes:      setting(settingStorageMaxFileBytes),
+		UserMaxBytes:      setting(settingStorageUserMaxBytes),
+		UserMaxFiles:      setting(settingStorageUserMaxFiles),
+		WorkspaceMaxBytes: setting(settingStorageWorkspaceMaxBytes),
+		WorkspaceMaxFiles: setting(settingStorageWorkspaceMaxFiles),
+	}
+}
+
+// storageUsage returns what the user or the workspace identified by
+// subjectID stores, with its quota.
+func storageUsage(db *gorm.DB, settings StorageSettings, subject string, subjectID string) (services.StorageUsage, error) {
+	var usage services.StorageUsage
+	column := "owner_id"
+	if subject == models.StorageQuotaWorkspace {
+		column = "workspace_id"
+	}
+	err := db.Model(&models.Document{}).
+		Select("COALESCE(SUM(file_size), 0) AS used_bytes, COUNT(*) AS files").
+		Where(column+" = ?", subjectID).
+		Scan(&usage).Error
+	if err != nil {
+		return usage, err
+	}
+
+	usage.MaxBytes, usage.MaxFiles = settings.UserMaxBytes, settings.UserMaxFiles
+	if subject == models.StorageQuotaWorkspace {
+		usage.MaxBytes, usage.MaxFiles = settings.WorkspaceMaxBytes, settings.WorkspaceMaxFiles
+	}
+
+	// owners of documents created before the owner was checked may not be users
+	if _, err := uuid.Parse(subjectID); err != nil {
+		return usage, nil
+	}
+	var quota models.StorageQuota
+	err = db.Where("subject = ? AND subject_id = ?", subject, subjectID).First(&quota).Error
+	switch {
+	case err == nil:
+		usage.MaxBytes, usage.MaxFiles = quota.MaxBytes, quota.MaxFiles
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return usage, err
+	}
+	return usage, nil
+}
+
+// lockStorageSubject locks the row of the user or the workspace whose storage
+// is checked until the end of the transaction of db, so that the documents
+// added to it are counted one at a time. The quota may come from the
+// settings, without a storage_quota row to lock. SQLite writes one
+// transaction at a time.
+func lockStorageSubject(db *gorm.DB, subject string, subjectID string) error {
+	if db.Dialector.Name() != config.DriverPostgres {
+		return nil
+	}
+	// owners of documents created before the owner was checked may not be users
+	if _, err := uuid.Parse(subjectID); err != nil {
+		return nil
+	}
+	var model interface{} = &models.User{}
+	if subject == models.StorageQuotaWorkspace {
+		model = &models.Workspace{}
+	}
+	var ids []uuid.UUID
+	return db.Model(model).Clauses(clause.Locking{Strength: "UPDATE"}).Where(searchById, subjectID).Pluck("id", &ids).Error
+}
+
+// limitUploadSize stops reading uploads larger than the maximum file size.
+func limitUploadSize(c *gin.Context, maxFileBytes int64) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFileBytes+multipartOverhead)
+}
+
+// isUploadTooLarge reports whether the upload was stopped by limitUploadSize
+// or its file is larger than the maximum file size.
+func isUploadTooLarge(maxFileBytes int64, err error, size int64) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError) || size > maxFileBytes
+}
+
+func abortUploadTooLarge(c *gin.Context, maxFileBytes int64) {
+	respondError(c, responses.CodePayloadTooLarge, "File larger than "+strconv.FormatInt(maxFileBytes, 10)+" bytes")
+}
+
+// GetStorageUsageHandler gets the storage used by the logged user and the current workspace.
+// @Summary Get storage usage
+// @Description Get the storage used by the logged user and by the workspace selected with the X-Workspace-ID header, with their quotas. Zero means unlimited
+// @ID get-storage-usage
+// @Tags Storage
+// @Produce json
+// @Param X-Workspace-ID header string false "Workspace ID, the personal workspace when empty"
+// @Success 200 {object} StorageUsageResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /storage/usage [get]
+func GetStorageUsageHandler(c *gin.Context) {
+	db := tenantDB(c)
+	settings := loadStorageSettings(db)
+	claims := c.MustGet("claims").(*Claims)
+
+	userUsage, err := storageUsage(db, settings, models.StorageQuotaUser, claims.UserID.String())
+	if err != nil {
+		respondInternalError(c, "Error retrieving storage usage", err)
+		return
+	}
+	workspaceUsage, err := storageUsage(db, settings, models.StorageQuotaWorkspace, currentWorkspace(c).Workspace.ID.String())
+	if err != nil {
+		respondInternalError(c, "Error retrieving storage usage", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, StorageUsageResponse{User: userUsage, Workspace: workspaceUsage, MaxFileBytes: settings.MaxFileBytes})
+}
+
+// GetStorageSettingsHandler gets the default storage quotas.
+// @Summary Get storage settings
+// @Description Get the maximum file size and the default quotas of the users and the workspaces. Zero means unlimited
+// @ID get-storage-settings
+// @Tags Settings
+// @Produce json
+// @Success 200 {object} StorageSettings
+// @Failure 401 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage [get]
+func GetStorageSettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, loadStorageSettings(tenantDB(c)))
+}
+
+// UpdateStorageSettingsHandler updates the default storage quotas.
+// @Summary Update storage settings
+// @Description Update the maximum file size and the default quotas of the users and the workspaces. Zero means unlimited
+// @ID update-storage-settings
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param settings body StorageSettings true "Storage settings"
+// @Success 200 {object} StorageSettings
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage [put]
+func UpdateStorageSettingsHandler(c *gin.Context) {
+	var settings StorageSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for key, value := range map[string]int64{
+			settingStorageMaxFileBytes:      settings.MaxFileBytes,
+			settingStorageUserMaxBytes:      settings.UserMaxBytes,
+			settingStorageUserMaxFiles:      settings.UserMaxFiles,
+			settingStorageWorkspaceMaxBytes: settings.WorkspaceMaxBytes,
+			settingStorageWorkspaceMaxFiles: settings.WorkspaceMaxFiles,
+		} {
+			if err := setSetting(tx, key, strconv.FormatInt(value, 10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondInternalError(c, "Error updating settings", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loadStorageSettings(db))
+}
+
+// setStorageQuota replaces the default quota of the user or the workspace of
+// the path parameter param.
+func setStorageQuota(c *gin.Context, subject string, model interface{}, param string) {
+	subjectID, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, messageStatusBadRequest)
+		return
+	}
+
+	var body StorageQuotaBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	db := tenantDB(c)
+
+	if err := db.Where(searchById, subjectID).First(model).Error; err != nil {
+		respondError(c, responses.CodeNotFound, "The "+subject+" was not found")
+		return
+	}
+
+	quota := models.StorageQuota{Subject: subject, SubjectID: subjectID, MaxBytes: body.MaxBytes, MaxFiles: body.MaxFiles}
+	// not Save: the tenant, part of the primary key, is only assigned on create
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&quota).Error; err != nil {
+		respondInternalError(c, "Error updating storage quota", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quota)
+}
+
+// deleteStorageQuota gives the user or the workspace of the path parameter
+// param the default quota again.
+func deleteStorageQuota(c *gin.Context, subject string, param string) {
+	subjectID, err := uuid.Parse(c.Param(param))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, messageStatusBadRequest)
+		return
+	}
+
+	if err := tenantDB(c).Where("subject = ? AND subject_id = ?", subject, subjectID).Delete(&models.StorageQuota{}).Error; err != nil {
+		respondInternalError(c, "Error deleting storage quota", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Storage quota deleted successfully"})
+}
+
+// SetUserStorageQuotaHandler sets the storage quota of a user.
+// @Summary Set the storage quota of a user
+// @Description Replace the default storage quota of a user. Zero means unlimited
+// @ID set-user-storage-quota
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param body body StorageQuotaBody true "Storage quota"
+// @Success 200 {object} models.StorageQuota
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage/users/{userId} [put]
+func SetUserStorageQuotaHandler(c *gin.Context) {
+	setStorageQuota(c, models.StorageQuotaUser, &models.User{}, "userId")
+}
+
+// DeleteUserStorageQuotaHandler gives a user the default storage quota again.
+// @Summary Delete the storage quota of a user
+// @Description Give a user the default storage quota again
+// @ID delete-user-storage-quota
+// @Tags Settings
+// @Produce json
+// @Param userId path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage/users/{userId} [delete]
+func DeleteUserStorageQuotaHandler(c *gin.Context) {
+	deleteStorageQuota(c, models.StorageQuotaUser, "userId")
+}
+
+// SetWorkspaceStorageQuotaHandler sets the storage quota of a workspace.
+// @Summary Set the storage quota of a workspace
+// @Description Replace the default storage quota of a workspace. Zero means unlimited
+// @ID set-workspace-storage-quota
+// @Tags Settings
+// @Accept json
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param body body StorageQuotaBody true "Storage quota"
+// @Success 200 {object} models.StorageQuota
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage/workspaces/{workspaceId} [put]
+func SetWorkspaceStorageQuotaHandler(c *gin.Context) {
+	setStorageQuota(c, models.StorageQuotaWorkspace, &models.Workspace{}, "workspaceId")
+}
+
+// DeleteWorkspaceStorageQuotaHandler gives a workspace the default storage quota again.
+// @Summary Delete the storage quota of a workspace
+// @Description Give a workspace the default storage quota again
+// @ID delete-workspace-storage-quota
+// @Tags Settings
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /settings/storage/workspaces/{workspaceId} [delete]
+func DeleteWorkspaceStorageQuotaHandler(c *gin.Context) {
+	deleteStorageQuota(c, models.StorageQuotaWorkspace, "workspaceId")
+}
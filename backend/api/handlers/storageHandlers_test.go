@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/config"
+	"document-manager/database"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func storageTestRouter() *gin.Engine {
+	r := workspacesTestRouter()
+	h := gormHandlers()
+	r.POST("/documents/upload", AuthMiddleware, WorkspaceMiddleware, h.CreateDocumentHandler)
+	r.PUT("/documents/upload/:id", AuthMiddleware, WorkspaceMiddleware, h.UpdateDocumentHandler)
+	r.GET("/storage/usage", AuthMiddleware, WorkspaceMiddleware, GetStorageUsageHandler)
+	settings := r.Group("/settings", AuthMiddleware, RequirePermission(models.PermissionSettingsManage))
+	settings.PUT("/storage", UpdateStorageSettingsHandler)
+	settings.PUT("/storage/users/:userId", SetUserStorageQuotaHandler)
+	settings.DELETE("/storage/users/:userId", DeleteUserStorageQuotaHandler)
+	settings.PUT("/storage/workspaces/:workspaceId", SetWorkspaceStorageQuotaHandler)
+	return r
+}
+
+// uploadTestFile sends a file of size bytes to path.
+func uploadTestFile(t *testing.T, r *gin.Engine, method string, path string, token string, size int) *httptest.ResponseRecorder {
+	var b bytes.Buffer
+	writer := multipart.NewWriter(&b)
+	assert.Nil(t, writer.WriteField("title", "Storage Document"))
+	part, err := writer.CreateFormFile("file", "file.pdf")
+	assert.Nil(t, err)
+	_, err = part.Write(bytes.Repeat([]byte("x"), size))
+	assert.Nil(t, err)
+	writer.Close()
+
+	req, _ := http.NewRequest(method, path, &b)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestStorageQuotas(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Storage User", "storage-user@example.com", "password", false)
+	admin := createTestUser(t, db, "Storage Admin", "storage-admin@example.com", "password", true)
+	defer deleteTestUser(db, user)
+	defer deleteTestUser(db, admin)
+	personal, err := database.PersonalWorkspace(db, user.ID)
+	assert.Nil(t, err)
+
+	r := storageTestRouter()
+	userLogin := login(t, r, user.Name, "password")
+	adminLogin := login(t, r, admin.Name, "password")
+
+	var created []DocumentResponse
+	defer func() {
+		for _, document := range created {
+			var existing models.Document
+			if db.Where(searchById, document.ID).First(&existing).Error == nil {
+				os.Remove(existing.FilePath)
+				db.Delete(&existing)
+			}
+		}
+		db.Where("subject_id IN ?", []string{user.ID.String(), personal.ID.String()}).Delete(&models.StorageQuota{})
+		db.Where("key LIKE ?", "storage_%").Delete(&models.Setting{})
+	}()
+	upload := func(size int, expected int) *httptest.ResponseRecorder {
+		resp := uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, size)
+		assert.Equal(t, expected, resp.Code, resp.Body.String())
+		if resp.Code == http.StatusCreated {
+			var document DocumentResponse
+			assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &document))
+			created = append(created, document)
+		}
+		return resp
+	}
+	usage := func() StorageUsageResponse {
+		resp := requestInWorkspace(r, "GET", "/storage/usage", userLogin.AccessToken, "", nil)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var response StorageUsageResponse
+		assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		return response
+	}
+
+	// only users with settings:manage set quotas
+	resp := requestJSONWithToken(r, "PUT", "/settings/storage", userLogin.AccessToken, StorageSettings{})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestJSONWithToken(r, "PUT", "/settings/storage", adminLogin.AccessToken, StorageSettings{MaxFileBytes: 1000})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestJSONWithToken(r, "PUT", "/settings/storage/users/"+user.ID.String(), adminLogin.AccessToken, StorageQuotaBody{MaxBytes: 1400, MaxFiles: 2})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	upload(600, http.StatusCreated)
+	assert.Equal(t, services.StorageUsage{UsedBytes: 600, Files: 1, MaxBytes: 1400, MaxFiles: 2}, usage().User)
+	assert.Equal(t, int64(1000), usage().MaxFileBytes)
+
+	upload(2000, http.StatusRequestEntityTooLarge)
+	resp = upload(1000, http.StatusInsufficientStorage)
+	var exceeded StorageQuotaExceededResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &exceeded))
+	assert.Equal(t, responses.CodeStorageQuotaExceeded, exceeded.Code)
+	assert.Equal(t, models.StorageQuotaUser, exceeded.Quota)
+	assert.Equal(t, int64(600), exceeded.Usage.UsedBytes)
+
+	upload(500, http.StatusCreated)
+	upload(100, http.StatusInsufficientStorage)
+
+	// replacing a file only counts what it grows
+	resp = uploadTestFile(t, r, "PUT", "/documents/upload/"+created[0].ID.String(), userLogin.AccessToken, 1000)
+	assert.Equal(t, http.StatusInsufficientStorage, resp.Code)
+	resp = uploadTestFile(t, r, "PUT", "/documents/upload/"+created[0].ID.String(), userLogin.AccessToken, 300)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, services.StorageUsage{UsedBytes: 800, Files: 2, MaxBytes: 1400, MaxFiles: 2}, usage().User)
+
+	// without its own quota the user has the default one, unlimited
+	resp = requestJSONWithToken(r, "DELETE", "/settings/storage/users/"+user.ID.String(), adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	upload(100, http.StatusCreated)
+
+	resp = requestJSONWithToken(r, "PUT", "/settings/storage/workspaces/"+personal.ID.String(), adminLogin.AccessToken, StorageQuotaBody{MaxFiles: 3})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = upload(100, http.StatusInsufficientStorage)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &exceeded))
+	assert.Equal(t, models.StorageQuotaWorkspace, exceeded.Quota)
+	assert.Equal(t, services.StorageUsage{UsedBytes: 900, Files: 3, MaxBytes: 0, MaxFiles: 3}, usage().Workspace)
+}
+
+func TestUploadLimit(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Upload Limit User", "upload-limit@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	previous := config.Get()
+	cfg := *previous
+	cfg.Documents.MaxUploadMB = 1
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	r := storageTestRouter()
+	userLogin := login(t, r, user.Name, "password")
+	defer func() {
+		var documents []models.Document
+		db.Where("owner_id = ?", user.ID.String()).Find(&documents)
+		for _, document := range documents {
+			os.Remove(document.FilePath)
+			db.Delete(&document)
+		}
+		db.Where("key LIKE ?", "storage_%").Delete(&models.Setting{})
+	}()
+
+	// the largest upload of the configuration applies without storage settings
+	resp := uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, 1<<20)
+	assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	resp = uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, 1<<20+1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	resp = uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, 3<<20)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Equal(t, responses.CodePayloadTooLarge, problemOf(t, resp).Code)
+
+	// and the smaller of the two when both are set
+	assert.Nil(t, setSetting(db, settingStorageMaxFileBytes, strconv.Itoa(2<<20)))
+	resp = uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, 1<<20+1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Nil(t, setSetting(db, settingStorageMaxFileBytes, "1000"))
+	resp = uploadTestFile(t, r, "POST", "/documents/upload", userLogin.AccessToken, 1001)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
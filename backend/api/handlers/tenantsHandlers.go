@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/config"
+	"document-manager/database"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const tenantHeader = "X-Tenant"
+
+type TenantResponse struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// tenantFromHost returns the subdomain of the tenant domain the host belongs
+// to, or an empty string.
+func tenantFromHost(host string) string {
+	domain := strings.ToLower(config.Get().Tenants.Domain)
+	if domain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	subdomain, found := strings.CutSuffix(strings.ToLower(host), "."+domain)
+	if !found || strings.Contains(subdomain, ".") {
+		return ""
+	}
+	return subdomain
+}
+
+// TenantMiddleware resolves the tenant of the request from the X-Tenant header
+// or the subdomain of the host, and falls back to the default tenant. The
+// queries made through tenantDB only see the data of that tenant.
+func TenantMiddleware(c *gin.Context) {
+	slug := c.GetHeader(tenantHeader)
+	if slug == "" {
+		slug = tenantFromHost(c.Request.Host)
+	}
+	if slug == "" {
+		slug = models.DefaultTenantSlug
+	}
+
+	tenant, err := database.FindTenant(slug)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondError(c, responses.CodeNotFound, "Tenant not found")
+		} else {
+			respondInternalError(c, "Error retrieving tenant", err)
+		}
+		return
+	}
+
+	c.Set("tenant", tenant)
+	c.Request = c.Request.WithContext(database.WithTenant(c.Request.Context(), tenant.ID))
+	c.Next()
+}
+
+// currentTenant returns the tenant set by TenantMiddleware.
+func currentTenant(c *gin.Context) models.Tenant {
+	return c.MustGet("tenant").(models.Tenant)
+}
+
+// tenantDB returns the database scoped to the tenant of the request.
+func tenantDB(c *gin.Context) *gorm.DB {
+	return database.GetDB().WithContext(c.Request.Context())
+}
+
+// GetTenantHandler gets the tenant of the request.
+// @Summary Get the tenant
+// @Description Get the organization the request is made to, resolved from the X-Tenant header or the subdomain
+// @ID get-tenant
+// @Tags Tenants
+// @Produce json
+// @Param X-Tenant header string false "Tenant slug"
+// @Success 200 {object} TenantResponse
+// @Failure 404 {object} responses.Problem
+// @Router /tenant [get]
+func GetTenantHandler(c *gin.Context) {
+	tenant := currentTenant(c)
+	c.JSON(http.StatusOK, TenantResponse{Slug: tenant.Slug, Name: tenant.Name})
+}
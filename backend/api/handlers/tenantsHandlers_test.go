@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"document-manager/api/models"
+	"document-manager/config"
+	"document-manager/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func tenantsTestRouter() *gin.Engine {
+	r := workspacesTestRouter()
+	r.GET("/tenant", GetTenantHandler)
+	r.GET("/users", AuthMiddleware, gormHandlers().GetAllUsersHandler)
+	return r
+}
+
+// requestInTenant sends a request to the tenant, with body as JSON when not nil.
+func requestInTenant(r *gin.Engine, method string, path string, tenant string, token string, body interface{}) *httptest.ResponseRecorder {
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tenantHeader, tenant)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+// tenantDBForTest returns the database scoped to the tenant.
+func tenantDBForTest(tenant models.Tenant) *gorm.DB {
+	return database.GetDB().WithContext(database.WithTenant(context.Background(), tenant.ID))
+}
+
+func createTestTenant(t *testing.T, slug string, masterPassword string) models.Tenant {
+	tenant, err := database.CreateTenant(slug, strings.ToUpper(slug), masterPassword)
+	assert.Nil(t, err)
+	return tenant
+}
+
+// deleteTestTenant deletes the tenant and everything it owns.
+func deleteTestTenant(tenant models.Tenant) {
+	db := tenantDBForTest(tenant).Session(&gorm.Session{AllowGlobalUpdate: true})
+	for _, model := range []interface{}{
+		&models.Document{}, &models.WorkspaceInvitation{}, &models.WorkspaceMember{}, &models.Workspace{},
+		&models.RefreshToken{}, &models.Session{}, &models.UserRole{}, &models.Role{}, &models.User{},
+	} {
+		db.Delete(model)
+	}
+	database.GetDB().Delete(&tenant)
+}
+
+// createTestDocument creates a document of the user in its personal workspace.
+func createTestDocument(t *testing.T, db *gorm.DB, owner models.User, title string) models.Document {
+	workspace, err := database.PersonalWorkspace(db, owner.ID)
+	assert.Nil(t, err)
+	document := models.Document{ID: uuid.New(), Title: title, OwnerID: owner.ID.String(), OwnerName: owner.Name, WorkspaceID: workspace.ID}
+	assert.Nil(t, db.Create(&document).Error)
+	return document
+}
+
+func TestTenantIsolation(t *testing.T) {
+	runInitDb()
+	tenantA := createTestTenant(t, "tenant-a-test", "password-a")
+	tenantB := createTestTenant(t, "tenant-b-test", "password-b")
+	defer deleteTestTenant(tenantA)
+	defer deleteTestTenant(tenantB)
+
+	dbA := tenantDBForTest(tenantA)
+	dbB := tenantDBForTest(tenantB)
+
+	// every tenant has its own master user, with the same name
+	var masterA, masterB models.User
+	assert.Nil(t, dbA.Where("name = ?", "master").First(&masterA).Error)
+	assert.Nil(t, dbB.Where("name = ?", "master").First(&masterB).Error)
+	assert.NotEqual(t, masterA.ID, masterB.ID)
+	assert.Equal(t, tenantA.ID, masterA.TenantID)
+
+	documentA := createTestDocument(t, dbA, masterA, "Document of A")
+	documentB := createTestDocument(t, dbB, masterB, "Document of B")
+	var workspaceB models.Workspace
+	assert.Nil(t, dbB.Where(searchById, documentB.WorkspaceID).First(&workspaceB).Error)
+
+	r := tenantsTestRouter()
+
+	resp := requestInTenant(r, "POST", "/login", tenantB.Slug, "", LoginBody{UsernameOrEmail: "master", Password: "password-a"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = requestInTenant(r, "POST", "/login", tenantA.Slug, "", LoginBody{UsernameOrEmail: "master", Password: "password-a"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var loginA LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &loginA))
+	assert.Equal(t, []string{models.RoleAdmin}, loginA.User.Roles)
+
+	// the permissions of the master user only apply to its tenant
+	resp = requestInTenant(r, "GET", "/documents", tenantA.Slug, loginA.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), documentA.ID.String())
+	assert.NotContains(t, resp.Body.String(), documentB.ID.String())
+
+	resp = requestInTenant(r, "GET", "/documents/"+documentB.ID.String(), tenantA.Slug, loginA.AccessToken, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = requestInTenant(r, "DELETE", "/documents/"+documentB.ID.String(), tenantA.Slug, loginA.AccessToken, nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	req, _ := http.NewRequest("GET", "/documents/"+documentB.ID.String(), nil)
+	req.Header.Set(tenantHeader, tenantA.Slug)
+	req.Header.Set(workspaceHeader, workspaceB.ID.String())
+	req.Header.Set("Authorization", loginA.AccessToken)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestInTenant(r, "GET", "/users", tenantA.Slug, loginA.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), masterA.ID.String())
+	assert.NotContains(t, resp.Body.String(), masterB.ID.String())
+
+	// the tokens of a tenant are refused by the others
+	resp = requestInTenant(r, "GET", "/documents", tenantB.Slug, loginA.AccessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = requestInTenant(r, "POST", "/refresh-token", tenantB.Slug, "", RefreshTokenBody{RefreshToken: loginA.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = requestInTenant(r, "POST", "/refresh-token", tenantA.Slug, "", RefreshTokenBody{RefreshToken: loginA.RefreshToken})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestTenantScopedQueries(t *testing.T) {
+	runInitDb()
+	tenantA := createTestTenant(t, "tenant-c-test", "password-c")
+	tenantB := createTestTenant(t, "tenant-d-test", "password-d")
+	defer deleteTestTenant(tenantA)
+	defer deleteTestTenant(tenantB)
+
+	dbA := tenantDBForTest(tenantA)
+	dbB := tenantDBForTest(tenantB)
+
+	var masterB models.User
+	assert.Nil(t, dbB.Where("name = ?", "master").First(&masterB).Error)
+	documentB := createTestDocument(t, dbB, masterB, "Document of D")
+	assert.Equal(t, tenantB.ID, documentB.TenantID)
+
+	// queries without tenant fail instead of seeing every tenant
+	var documents []models.Document
+	assert.ErrorIs(t, database.GetDB().Find(&documents).Error, database.ErrNoTenant)
+
+	assert.Nil(t, dbA.Find(&documents).Error)
+	for _, document := range documents {
+		assert.Equal(t, tenantA.ID, document.TenantID)
+	}
+
+	var count int64
+	assert.Nil(t, dbA.Model(&models.User{}).Where(searchById, masterB.ID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	result := dbA.Model(&models.Document{}).Where(searchById, documentB.ID).Update("title", "Changed")
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+	result = dbA.Delete(&models.Document{}, "id = ?", documentB.ID)
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	// a row of another tenant cannot be saved
+	documentB.Title = "Changed"
+	assert.ErrorIs(t, dbA.Save(&documentB).Error, database.ErrOtherTenant)
+
+	var unchanged models.Document
+	assert.Nil(t, dbB.Where(searchById, documentB.ID).First(&unchanged).Error)
+	assert.Equal(t, "Document of D", unchanged.Title)
+}
+
+func TestTenantResolution(t *testing.T) {
+	runInitDb()
+	tenant := createTestTenant(t, "tenant-e-test", "password-e")
+	defer deleteTestTenant(tenant)
+	previous := config.Get()
+	cfg := *previous
+	cfg.Tenants.Domain = "docs.example.com"
+	config.Set(&cfg)
+	defer config.Set(previous)
+
+	r := tenantsTestRouter()
+	get := func(host string, header string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/tenant", nil)
+		req.Host = host
+		if header != "" {
+			req.Header.Set(tenantHeader, header)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	var response TenantResponse
+	resp := get("tenant-e-test.docs.example.com:3450", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, TenantResponse{Slug: "tenant-e-test", Name: "TENANT-E-TEST"}, response)
+
+	resp = get("localhost:3450", tenant.Slug)
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, tenant.Slug, response.Slug)
+
+	resp = get("docs.example.com", "")
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, models.DefaultTenantSlug, response.Slug)
+
+	resp = get("unknown.docs.example.com", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// the files of the tenant are kept apart
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("tenant", tenant)
+	assert.Contains(t, documentFilePath(c, uuid.New()), "/documents/tenant-e-test/")
+
+	_, err := database.CreateTenant("Not a slug", "", "")
+	assert.ErrorIs(t, err, database.ErrInvalidTenantSlug)
+
+	// nobody would know the password of its master user
+	_, err = database.CreateTenant("tenant-f-test", "", "")
+	assert.ErrorIs(t, err, database.ErrNoMasterPassword)
+	assert.ErrorContains(t, err, "MASTER_PASSWORD_TENANT_F_TEST")
+	_, err = database.FindTenant("tenant-f-test")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/metrics"
+	"document-manager/totp"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const tokenPurposeTwoFactorChallenge = "2fa_challenge"
+
+var twoFactorIssuer = "Document Manager"
+var twoFactorChallengeTTL = time.Minute * 5
+var recoveryCodeCount = 10
+
+var messageInvalidTwoFactorCode = "Invalid two-factor authentication code"
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type TwoFactorCodeBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorLoginBody struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+type TwoFactorChallengeResponse struct {
+	Message           string `json:"message"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+// effectivePermissions returns the permissions granted to the tokens of the
+// user. When the policy requires it, a user whose roles grant permissions but
+// without two-factor authentication logs in without them until it enrolls.
+func effectivePermissions(db *gorm.DB, user models.User) []string {
+	permissions, err := rolePermissions(db, user.ID)
+	if err != nil || len(permissions) == 0 {
+		return nil
+	}
+	if !user.TOTPEnabled && loadSecuritySettings(db).RequireMasterTwoFactor {
+		return nil
+	}
+	return permissions
+}
+
+// twoFactorEnrollmentRequired reports whether the policy withholds the
+// permissions of the user until it enables two-factor authentication.
+func twoFactorEnrollmentRequired(db *gorm.DB, user models.User) bool {
+	if user.TOTPEnabled || !loadSecuritySettings(db).RequireMasterTwoFactor {
+		return false
+	}
+	permissions, err := rolePermissions(db, user.ID)
+	return err == nil && len(permissions) > 0
+}
+
+// issueChallengeToken returns the short-lived token proving that the password
+// of the user was checked, exchanged for the real tokens with a second factor.
+func issueChallengeToken(userID uuid.UUID) (string, error) {
+	return issueLoginToken(userID, tokenPurposeTwoFactorChallenge, twoFactorChallengeTTL)
+}
+
+func parseChallengeToken(tokenString string) (uuid.UUID, error) {
+	return parseLoginToken(tokenString, tokenPurposeTwoFactorChallenge)
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes replaces the recovery codes of the user and returns the new ones.
+func generateRecoveryCodes(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 6)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// verifySecondFactor accepts a TOTP code, each code only once, or an unused recovery code.
+func verifySecondFactor(db *gorm.DB, user *models.User, code string) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 1); ok {
+		if step <= user.TOTPLastStep {
+			return false
+		}
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// LoginTwoFactorHandler completes a login with a two-factor authentication code.
+// @Summary Login second step
+// @Description Exchange the challenge token returned by the login and a TOTP or recovery code for the tokens
+// @ID login-2fa
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorLoginBody true "Challenge token and code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /login/2fa [post]
+func LoginTwoFactorHandler(c *gin.Context) {
+	var body TwoFactorLoginBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	userID, err := parseChallengeToken(body.ChallengeToken)
+	if err != nil {
+		respondError(c, responses.CodeInvalidToken, messageInvalidAccountToken)
+		return
+	}
+
+	account := twoFactorAccountKey(c, userID)
+	if !allowAccountAttempt(c, account) {
+		return
+	}
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, userID).First(&user).Error; err != nil || !user.TOTPEnabled {
+		respondError(c, responses.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	if !verifySecondFactor(db, &user, body.Code) {
+		recordFailedAttempt(c, account)
+		metrics.RecordLogin(metrics.LoginTwoFactor, false)
+		respondError(c, responses.CodeInvalidCredentials, messageInvalidTwoFactorCode)
+		return
+	}
+	resetFailedAttempts(c, account)
+	metrics.RecordLogin(metrics.LoginTwoFactor, true)
+
+	finishLogin(c, db, user)
+}
+
+// EnrollTwoFactorHandler starts the two-factor authentication enrollment of the logged user.
+// @Summary Enroll two-factor authentication
+// @Description Generate a TOTP secret for the logged user. It is enabled once confirmed with a code
+// @ID enroll-2fa
+// @Tags Two-factor authentication
+// @Produce json
+// @Success 200 {object} TwoFactorEnrollResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /2fa/enroll [post]
+func EnrollTwoFactorHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, claims.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	if user.TOTPEnabled {
+		respondError(c, responses.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondInternalError(c, "Error generating secret", err)
+		return
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		respondInternalError(c, "Error saving secret", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, TwoFactorEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: totp.URI(twoFactorIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactorHandler enables two-factor authentication with a code of the enrolled secret.
+// @Summary Confirm two-factor authentication
+// @Description Enable two-factor authentication and return the recovery codes, shown only once
+// @ID confirm-2fa
+// @Tags Two-factor authentication
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeBody true "TOTP code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /2fa/confirm [post]
+func ConfirmTwoFactorHandler(c *gin.Context) {
+	var body TwoFactorCodeBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, claims.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	if user.TOTPEnabled {
+		respondError(c, responses.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		respondError(c, responses.CodeBadRequest, "Two-factor authentication enrollment was not started")
+		return
+	}
+
+	if !verifySecondFactor(db, &user, body.Code) {
+		respondError(c, responses.CodeInvalidTwoFactor, messageInvalidTwoFactorCode)
+		return
+	}
+
+	if err := db.Model(&user).Update("totp_enabled", true).Error; err != nil {
+		respondInternalError(c, "Error enabling two-factor authentication", err)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		respondInternalError(c, "Error generating recovery codes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodesResponse{Message: "Two-factor authentication enabled", RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the logged user.
+// @Summary Regenerate recovery codes
+// @Description Replace the recovery codes of the logged user. Requires a current code
+// @ID regenerate-recovery-codes
+// @Tags Two-factor authentication
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeBody true "TOTP or recovery code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /2fa/recovery-codes [post]
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	user, ok := userWithSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	codes, err := generateRecoveryCodes(tenantDB(c), user.ID)
+	if err != nil {
+		respondInternalError(c, "Error generating recovery codes", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodesResponse{Message: "Recovery codes regenerated", RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler disables two-factor authentication for the logged user.
+// @Summary Disable two-factor authentication
+// @Description Disable two-factor authentication for the logged user. Requires a current code
+// @ID disable-2fa
+// @Tags Two-factor authentication
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorCodeBody true "TOTP or recovery code"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /2fa/disable [post]
+func DisableTwoFactorHandler(c *gin.Context) {
+	user, ok := userWithSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	db := tenantDB(c)
+
+	if permissions, _ := rolePermissions(db, user.ID); len(permissions) > 0 && loadSecuritySettings(db).RequireMasterTwoFactor {
+		respondError(c, responses.CodeTwoFactorRequired, "Two-factor authentication is required for users with roles")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}
+		if err := tx.Model(&models.User{}).Where(searchById, user.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error disabling two-factor authentication", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// userWithSecondFactor loads the logged user and checks the code in the body,
+// writing the error response when something is wrong.
+func userWithSecondFactor(c *gin.Context) (models.User, bool) {
+	var body TwoFactorCodeBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return models.User{}, false
+	}
+
+	claims := c.MustGet("claims").(*Claims)
+
+	db := tenantDB(c)
+
+	var user models.User
+	if err := db.Where(searchById, claims.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return models.User{}, false
+	}
+
+	if !user.TOTPEnabled {
+		respondError(c, responses.CodeBadRequest, "Two-factor authentication is not enabled")
+		return models.User{}, false
+	}
+
+	if !verifySecondFactor(db, &user, body.Code) {
+		respondError(c, responses.CodeInvalidTwoFactor, messageInvalidTwoFactorCode)
+		return models.User{}, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/api/models"
+	"document-manager/totp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func twoFactorTestRouter() *gin.Engine {
+	r := authTestRouter()
+	r.POST("/login/2fa", LoginTwoFactorHandler)
+	r.POST("/2fa/enroll", AuthMiddleware, EnrollTwoFactorHandler)
+	r.POST("/2fa/confirm", AuthMiddleware, ConfirmTwoFactorHandler)
+	r.POST("/2fa/disable", AuthMiddleware, DisableTwoFactorHandler)
+	r.POST("/2fa/recovery-codes", AuthMiddleware, RegenerateRecoveryCodesHandler)
+	r.GET("/settings/security", AuthMiddleware, RequirePermission(models.PermissionSettingsManage), GetSecuritySettingsHandler)
+	r.PUT("/settings/security", AuthMiddleware, RequirePermission(models.PermissionSettingsManage), UpdateSecuritySettingsHandler)
+	return r
+}
+
+func requestJSONWithToken(r *gin.Engine, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	reqBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+// enrollTwoFactor enables two-factor authentication for the owner of the
+// token and returns the secret and the recovery codes.
+func enrollTwoFactor(t *testing.T, r *gin.Engine, token string) (string, []string) {
+	resp := requestWithToken(r, "POST", "/2fa/enroll", token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var enrollResponse TwoFactorEnrollResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &enrollResponse))
+	assert.Contains(t, enrollResponse.OtpauthURI, "secret="+enrollResponse.Secret)
+
+	code, err := totp.CodeAt(enrollResponse.Secret, totp.Step(time.Now()))
+	assert.Nil(t, err)
+
+	resp = requestJSONWithToken(r, "POST", "/2fa/confirm", token, TwoFactorCodeBody{Code: code})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var codesResponse RecoveryCodesResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &codesResponse))
+	assert.Equal(t, recoveryCodeCount, len(codesResponse.RecoveryCodes))
+
+	return enrollResponse.Secret, codesResponse.RecoveryCodes
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Two Factor User", "two-factor@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer db.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+
+	r := twoFactorTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+	secret, recoveryCodes := enrollTwoFactor(t, r, loginResponse.AccessToken)
+
+	// the password is no longer enough
+	resp := postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "password"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var challenge TwoFactorChallengeResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &challenge))
+	assert.True(t, challenge.TwoFactorRequired)
+	assert.NotEqual(t, "", challenge.ChallengeToken)
+
+	var partial LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &partial))
+	assert.Equal(t, "", partial.AccessToken)
+
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: "000000"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the code used to confirm the enrollment cannot be used again, the next one can
+	var enrolled models.User
+	assert.Nil(t, db.First(&enrolled, searchById, user.ID).Error)
+	code, _ := totp.CodeAt(secret, enrolled.TOTPLastStep)
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: code})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	code, _ = totp.CodeAt(secret, enrolled.TOTPLastStep+1)
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: code})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var completed LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &completed))
+	assert.NotEqual(t, "", completed.AccessToken)
+	assert.NotEqual(t, "", completed.RefreshToken)
+
+	// recovery codes work once
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: recoveryCodes[0]})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: recoveryCodes[0]})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the challenge token is not an access token
+	resp = requestWithToken(r, "POST", "/logout", challenge.ChallengeToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// disabling requires a code
+	resp = requestJSONWithToken(r, "POST", "/2fa/disable", completed.AccessToken, TwoFactorCodeBody{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = requestJSONWithToken(r, "POST", "/2fa/disable", completed.AccessToken, TwoFactorCodeBody{Code: recoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	login(t, r, user.Name, "password")
+}
+
+func TestRegenerateRecoveryCodesHandler(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Recovery Codes User", "recovery-codes@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer db.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+
+	r := twoFactorTestRouter()
+	loginResponse := login(t, r, user.Name, "password")
+	_, recoveryCodes := enrollTwoFactor(t, r, loginResponse.AccessToken)
+
+	resp := requestJSONWithToken(r, "POST", "/2fa/recovery-codes", loginResponse.AccessToken, TwoFactorCodeBody{Code: recoveryCodes[0]})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var codesResponse RecoveryCodesResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &codesResponse))
+	assert.Equal(t, recoveryCodeCount, len(codesResponse.RecoveryCodes))
+
+	// the old codes were replaced
+	resp = requestJSONWithToken(r, "POST", "/2fa/recovery-codes", loginResponse.AccessToken, TwoFactorCodeBody{Code: recoveryCodes[1]})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestRequireMasterTwoFactorPolicy(t *testing.T) {
+	db := runInitDb()
+	master := createTestUser(t, db, "Policy Master", "policy-master@example.com", "password", true)
+	defer deleteTestUser(db, master)
+	defer db.Where("user_id = ?", master.ID).Delete(&models.RecoveryCode{})
+	defer setSetting(db, settingRequireMasterTwoFactor, "false")
+
+	r := twoFactorTestRouter()
+	masterLogin := login(t, r, master.Name, "password")
+	assert.False(t, masterLogin.TwoFactorEnrollmentRequired)
+
+	resp := requestJSONWithToken(r, "PUT", "/settings/security", masterLogin.AccessToken, SecuritySettings{RequireMasterTwoFactor: true})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// without two-factor authentication the admin logs in without its permissions
+	masterLogin = login(t, r, master.Name, "password")
+	assert.True(t, masterLogin.TwoFactorEnrollmentRequired)
+	resp = requestWithToken(r, "GET", "/settings/security", masterLogin.AccessToken)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	secret, _ := enrollTwoFactor(t, r, masterLogin.AccessToken)
+
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: master.Name, Password: "password"})
+	var challenge TwoFactorChallengeResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &challenge))
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now())+1)
+	resp = postJSON(r, "/login/2fa", TwoFactorLoginBody{ChallengeToken: challenge.ChallengeToken, Code: code})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var completed LoginResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &completed))
+	assert.False(t, completed.TwoFactorEnrollmentRequired)
+
+	resp = requestWithToken(r, "GET", "/settings/security", completed.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var settings SecuritySettings
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &settings))
+	assert.True(t, settings.RequireMasterTwoFactor)
+
+	// the policy keeps users with roles from disabling it
+	code, _ = totp.CodeAt(secret, totp.Step(time.Now())-1)
+	db.Model(&models.User{}).Where(searchById, master.ID).Update("totp_last_step", 0)
+	resp = requestJSONWithToken(r, "POST", "/2fa/disable", completed.AccessToken, TwoFactorCodeBody{Code: code})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
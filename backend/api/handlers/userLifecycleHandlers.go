@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/database"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var errAccountSuspended = errors.New("account suspended")
+var messageAccountSuspended = "Your account is suspended"
+
+type ReassignDocumentsBody struct {
+	// user receiving the documents
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+type ReassignDocumentsResponse struct {
+	Message   string `json:"message"`
+	Documents int64  `json:"documents"`
+}
+
+type UserStatsResponse struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	Status         string     `json:"status"`
+	Roles          []string   `json:"roles"`
+	Documents      int64      `json:"documents"`
+	StorageBytes   int64      `json:"storage_bytes"`
+	Workspaces     int64      `json:"workspaces"`
+	ActiveSessions int64      `json:"active_sessions"`
+	APIKeys        int64      `json:"api_keys"`
+	LastLoginAt    *time.Time `json:"last_login_at"`
+	LastSeenAt     *time.Time `json:"last_seen_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// findManagedUser finds the user of the id parameter, or answers the request.
+func findManagedUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var user models.User
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
+		return user, false
+	}
+	if err := db.Where(searchById, userID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return user, false
+	}
+	return user, true
+}
+
+// canManageUser reports whether the logged user may change the user of the
+// id parameter: itself, or anyone with the users:manage permission.
+func canManageUser(c *gin.Context, userID string) bool {
+	claims := c.MustGet("claims").(*Claims)
+	return claims.UserID.String() == userID || claims.HasPermission(models.PermissionUsersManage)
+}
+
+// PromoteUserMasterHandler gives a user the admin role.
+// @Summary Promote a user
+// @Description Give a user the built-in admin role, with every permission
+// @ID promote-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/promote [post]
+func PromoteUserMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+	if user.Status != models.UserStatusActive {
+		respondError(c, responses.CodeBadRequest, "Only active users can be promoted")
+		return
+	}
+
+	if err := setUserRoleByName(db, user.ID, models.RoleAdmin, true); err != nil {
+		respondInternalError(c, "Error changing user role", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User promoted successfully"})
+}
+
+// DemoteUserMasterHandler takes the admin role from a user.
+// @Summary Demote a user
+// @Description Take the built-in admin role from a user and end its sessions. The last user with the admin role keeps it
+// @ID demote-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/demote [post]
+func DemoteUserMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+
+	if err := setUserRoleByName(db, user.ID, models.RoleAdmin, false); err != nil {
+		if errors.Is(err, errLastAdmin) {
+			respondError(c, responses.CodeLastAdmin, "The last user with the admin role cannot lose it")
+			return
+		}
+		respondInternalError(c, "Error changing user role", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User demoted successfully"})
+}
+
+// SuspendUserMasterHandler suspends the account of a user.
+// @Summary Suspend a user
+// @Description Keep a user from logging in, ending its sessions and refusing its API keys until reactivated. Its data is kept
+// @ID suspend-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/suspend [post]
+func SuspendUserMasterHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+	if user.ID == claims.UserID {
+		respondError(c, responses.CodeConflict, "You cannot suspend yourself")
+		return
+	}
+	if user.Status != models.UserStatusActive {
+		respondError(c, responses.CodeConflict, "Only active users can be suspended")
+		return
+	}
+	if isLastAdmin(db, user.ID) {
+		respondError(c, responses.CodeLastAdmin, "You cannot suspend the last user with the admin role")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("status", models.UserStatusSuspended).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		respondInternalError(c, "Error suspending user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User suspended successfully"})
+}
+
+// UnsuspendUserMasterHandler reactivates the account of a suspended user.
+// @Summary Reactivate a user
+// @Description Let a suspended user log in and use its API keys again
+// @ID unsuspend-user-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/unsuspend [post]
+func UnsuspendUserMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+	if user.Status != models.UserStatusSuspended {
+		respondError(c, responses.CodeConflict, "The user is not suspended")
+		return
+	}
+
+	if err := db.Model(&user).Update("status", models.UserStatusActive).Error; err != nil {
+		respondInternalError(c, "Error reactivating user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User reactivated successfully"})
+}
+
+// ForcePasswordResetMasterHandler makes a user choose a new password through a password reset email.
+// @Summary Force a password reset
+// @Description Replace the password of a user with a random one, end its sessions and email it a password reset link
+// @ID force-password-reset-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/password-reset [post]
+func ForcePasswordResetMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		respondError(c, responses.CodeBadRequest, "The user has no local password")
+		return
+	}
+
+	// nobody knows the random password, so the current one stops working
+	// and only the reset link sets a new one
+	randomPassword, err := randomToken()
+	if err != nil {
+		respondInternalError(c, "Error resetting password", err)
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		respondInternalError(c, "Error resetting password", err)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).UpdateColumn("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		respondInternalError(c, "Error resetting password", err)
+		return
+	}
+
+	if err := sendPasswordResetEmail(db, user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending password reset email", "user", user.ID, "error", err)
+		respondInternalError(c, "Error sending password reset email", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "A password reset link was sent to the user"})
+}
+
+// ReassignDocumentsMasterHandler gives the documents of a user to another one.
+// @Summary Reassign the documents of a user
+// @Description Make another user the owner of every document of a user, such as one leaving. The documents of its personal workspace move to the personal workspace of the other user
+// @ID reassign-documents-master
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param body body ReassignDocumentsBody true "New owner"
+// @Success 200 {object} ReassignDocumentsResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/documents/reassign [post]
+func ReassignDocumentsMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+
+	var body ReassignDocumentsBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	if body.UserID == user.ID {
+		respondInvalidFields(c, messageStatusBadRequest, responses.FieldError{Field: "user_id", Message: "must be another user"})
+		return
+	}
+	var target models.User
+	if err := db.Where(searchById, body.UserID).First(&target).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	var reassigned int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var personal models.Workspace
+		err := tx.Where("personal_user_id = ?", user.ID).First(&personal).Error
+		switch {
+		case err == nil:
+			targetPersonal, err := database.PersonalWorkspace(tx, target.ID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Document{}).Where("workspace_id = ?", personal.ID).Update("workspace_id", targetPersonal.ID).Error; err != nil {
+				return err
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		result := tx.Model(&models.Document{}).
+			Where("owner_id = ?", user.ID.String()).
+			Updates(map[string]interface{}{"owner_id": target.ID.String(), "owner_name": target.Name})
+		reassigned = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error reassigning documents", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ReassignDocumentsResponse{Message: "Documents reassigned successfully", Documents: reassigned})
+}
+
+// GetUserStatsMasterHandler gets the statistics of a user.
+// @Summary Get the statistics of a user
+// @Description Get the documents, storage, workspaces, sessions, API keys and last activity of a user
+// @ID get-user-stats-master
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} UserStatsResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Security ApiKey
+// @Router /usersMaster/{id}/stats [get]
+func GetUserStatsMasterHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	user, ok := findManagedUser(c, db)
+	if !ok {
+		return
+	}
+
+	stats := UserStatsResponse{UserID: user.ID, Status: user.Status, CreatedAt: user.CreatedAt}
+	usage, err := storageUsage(db, loadStorageSettings(db), models.StorageQuotaUser, user.ID.String())
+	if err == nil {
+		stats.Documents, stats.StorageBytes = usage.Files, usage.UsedBytes
+		stats.Roles, err = userRoleNames(db, user.ID)
+	}
+	if err == nil {
+		err = db.Model(&models.WorkspaceMember{}).Where("user_id = ?", user.ID).Count(&stats.Workspaces).Error
+	}
+	if err == nil {
+		err = db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&stats.ActiveSessions).Error
+	}
+	if err == nil {
+		err = db.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&stats.APIKeys).Error
+	}
+	var lastSession models.Session
+	if err == nil {
+		err = db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(1).Find(&lastSession).Error
+	}
+	if err != nil {
+		respondInternalError(c, "Error retrieving user statistics", err)
+		return
+	}
+	if lastSession.ID != uuid.Nil {
+		stats.LastLoginAt = &lastSession.CreatedAt
+		var lastSeen models.Session
+		if db.Where("user_id = ?", user.ID).Order("last_seen_at DESC").First(&lastSeen).Error == nil {
+			stats.LastSeenAt = &lastSeen.LastSeenAt
+		}
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/database"
+	"document-manager/mailer"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func userLifecycleTestRouter() *gin.Engine {
+	r := authTestRouter()
+	h := gormHandlers()
+	r.PUT("/users/:id", AuthMiddleware, h.UpdateUserHandler)
+	r.DELETE("/users/:id", AuthMiddleware, h.DeleteUserHandler)
+
+	manage := RequirePermission(models.PermissionUsersManage)
+	r.GET("/usersMaster/:id/stats", AuthMiddleware, manage, GetUserStatsMasterHandler)
+	r.POST("/usersMaster/:id/promote", AuthMiddleware, manage, RequirePermission(models.PermissionRolesManage), PromoteUserMasterHandler)
+	r.POST("/usersMaster/:id/demote", AuthMiddleware, manage, RequirePermission(models.PermissionRolesManage), DemoteUserMasterHandler)
+	r.POST("/usersMaster/:id/suspend", AuthMiddleware, manage, SuspendUserMasterHandler)
+	r.POST("/usersMaster/:id/unsuspend", AuthMiddleware, manage, UnsuspendUserMasterHandler)
+	r.POST("/usersMaster/:id/password-reset", AuthMiddleware, manage, ForcePasswordResetMasterHandler)
+	r.POST("/usersMaster/:id/documents/reassign", AuthMiddleware, manage, ReassignDocumentsMasterHandler)
+	return r
+}
+
+func TestUsersOnlyChangeThemselves(t *testing.T) {
+	db := runInitDb()
+	user := createTestUser(t, db, "Lifecycle Self", "lifecycle-self@example.com", "password", false)
+	other := createTestUser(t, db, "Lifecycle Other", "lifecycle-other@example.com", "password", false)
+	defer deleteTestUser(db, user)
+	defer deleteTestUser(db, other)
+
+	r := userLifecycleTestRouter()
+	token := login(t, r, user.Name, "password").AccessToken
+
+	resp := requestJSONWithToken(r, "PUT", "/users/"+other.ID.String(), token, UserBodyWithoutID{Name: "Renamed"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestJSONWithToken(r, "DELETE", "/users/"+other.ID.String(), token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+other.ID.String()+"/suspend", token, nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), token, UserBodyWithoutID{Name: "Lifecycle Renamed"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var response MessageWithUserResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, "Lifecycle Renamed", response.User.Name)
+	assert.NotContains(t, resp.Body.String(), "password")
+}
+
+func TestPromoteAndSuspendUser(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Lifecycle Admin", "lifecycle-admin@example.com", "password", true)
+	user := createTestUser(t, db, "Lifecycle User", "lifecycle-user@example.com", "password", false)
+	defer deleteTestUser(db, admin)
+	defer deleteTestUser(db, user)
+
+	r := userLifecycleTestRouter()
+	adminLogin := login(t, r, admin.Name, "password")
+	userLogin := login(t, r, user.Name, "password")
+	userPath := "/usersMaster/" + user.ID.String()
+
+	resp := requestJSONWithToken(r, "POST", userPath+"/promote", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, userHasRole(db, user.ID, models.RoleAdmin))
+	resp = requestJSONWithToken(r, "POST", userPath+"/demote", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.False(t, userHasRole(db, user.ID, models.RoleAdmin))
+
+	resp = requestJSONWithToken(r, "POST", "/usersMaster/"+admin.ID.String()+"/suspend", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	// the demotion ended the sessions of the user
+	userLogin = login(t, r, user.Name, "password")
+	resp = requestJSONWithToken(r, "POST", userPath+"/suspend", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestJSONWithToken(r, "POST", userPath+"/suspend", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "password"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: userLogin.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), userLogin.AccessToken, UserBodyWithoutID{Name: "Suspended"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = requestJSONWithToken(r, "POST", userPath+"/unsuspend", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	login(t, r, user.Name, "password")
+
+	resp = requestJSONWithToken(r, "GET", userPath+"/stats", adminLogin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var stats UserStatsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &stats))
+	assert.Equal(t, models.UserStatusActive, stats.Status)
+	assert.Equal(t, int64(1), stats.ActiveSessions)
+	assert.NotNil(t, stats.LastLoginAt)
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	db := runInitDb()
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	admin := createTestUser(t, db, "Reset Admin", "reset-admin@example.com", "password", true)
+	user := createTestUser(t, db, "Reset User", "reset-user@example.com", "password", false)
+	defer deleteTestUser(db, admin)
+	defer deleteTestUser(db, user)
+
+	r := userLifecycleTestRouter()
+	r.POST("/password-reset/confirm", ConfirmPasswordResetHandler)
+	adminToken := login(t, r, admin.Name, "password").AccessToken
+	userLogin := login(t, r, user.Name, "password")
+
+	resp := requestJSONWithToken(r, "POST", "/usersMaster/"+user.ID.String()+"/password-reset", adminToken, nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = postJSON(r, "/login", LoginBody{UsernameOrEmail: user.Name, Password: "password"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = postJSON(r, "/refresh-token", RefreshTokenBody{RefreshToken: userLogin.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	msg := capture.last()
+	assert.Equal(t, []string{user.Email}, msg.To)
+	resp = postJSON(r, "/password-reset/confirm", PasswordResetBody{Token: tokenFromMessage(msg), Password: "reset-user-password"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	login(t, r, user.Name, "reset-user-password")
+}
+
+func TestReassignDocuments(t *testing.T) {
+	db := runInitDb()
+	admin := createTestUser(t, db, "Reassign Admin", "reassign-admin@example.com", "password", true)
+	leaving := createTestUser(t, db, "Leaving User", "leaving@example.com", "password", false)
+	successor := createTestUser(t, db, "Successor User", "successor@example.com", "password", false)
+	defer deleteTestUser(db, admin)
+	defer deleteTestUser(db, leaving)
+	defer deleteTestUser(db, successor)
+
+	personal, err := database.PersonalWorkspace(db, leaving.ID)
+	assert.Nil(t, err)
+	defer deleteTestWorkspace(db, personal.ID)
+	successorPersonal, err := database.PersonalWorkspace(db, successor.ID)
+	assert.Nil(t, err)
+	defer deleteTestWorkspace(db, successorPersonal.ID)
+	shared := models.Workspace{ID: uuid.New(), Name: "Reassign"}
+	assert.Nil(t, db.Create(&shared).Error)
+	defer deleteTestWorkspace(db, shared.ID)
+
+	documents := []models.Document{
+		{ID: uuid.New(), Title: "Personal", OwnerID: leaving.ID.String(), OwnerName: leaving.Name, WorkspaceID: personal.ID},
+		{ID: uuid.New(), Title: "Shared", OwnerID: leaving.ID.String(), OwnerName: leaving.Name, WorkspaceID: shared.ID},
+	}
+	assert.Nil(t, db.Create(&documents).Error)
+	defer db.Where("id IN ?", []uuid.UUID{documents[0].ID, documents[1].ID}).Delete(&models.Document{})
+
+	r := userLifecycleTestRouter()
+	token := login(t, r, admin.Name, "password").AccessToken
+	path := "/usersMaster/" + leaving.ID.String() + "/documents/reassign"
+
+	resp := requestJSONWithToken(r, "POST", path, token, ReassignDocumentsBody{UserID: leaving.ID})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = requestJSONWithToken(r, "POST", path, token, ReassignDocumentsBody{UserID: uuid.New()})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestJSONWithToken(r, "POST", path, token, ReassignDocumentsBody{UserID: successor.ID})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var response ReassignDocumentsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal(t, int64(2), response.Documents)
+
+	var reassigned []models.Document
+	assert.Nil(t, db.Where("owner_id = ?", successor.ID.String()).Order("title").Find(&reassigned).Error)
+	assert.Equal(t, 2, len(reassigned))
+	assert.Equal(t, successorPersonal.ID, reassigned[0].WorkspaceID)
+	assert.Equal(t, shared.ID, reassigned[1].WorkspaceID)
+	assert.Equal(t, successor.Name, reassigned[1].OwnerName)
+}
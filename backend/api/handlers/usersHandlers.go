@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"document-manager/api/models"
-	"document-manager/database"
+	"document-manager/api/repositories"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,18 +14,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-type ErrorResponse struct {
-	ErrorMessage string `json:"error"`
-}
-
-type ErrorResponseWithDetails struct {
-	ErrorMessage string `json:"error"`
-	Details      string `json:"details"`
-}
-
 type MessageResponse struct {
 	Message string `json:"message"`
 }
@@ -35,14 +30,15 @@ type UsersResponse struct {
 	Users []UserResponse `json:"users"`
 }
 type UserResponse struct {
-	ID        uuid.UUID  `json:"id"`
-	Name      string     `json:"name"`
-	Email     string     `json:"email"`
-	Master    bool       `json:"master"`
-	Password  string     `json:"password"`
-	CreatedAt time.Time  `json:"createdAt"`
-	UpdatedAt time.Time  `json:"updatedAt"`
-	DeletedAt *time.Time `json:"deletedAt"`
+	ID            uuid.UUID  `json:"id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	Roles         []string   `json:"roles"`
+	EmailVerified bool       `json:"emailVerified"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	DeletedAt     *time.Time `json:"deletedAt"`
 }
 
 type UserBody struct {
@@ -58,11 +54,49 @@ type UserBodyWithoutID struct {
 	Password string `json:"password"`
 }
 
+type SignupBody struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// token of an invite, or of an invitation to a workspace, sent to the email address
+	InviteToken string `json:"invite_token"`
+}
+
 var messageStatusNotFound = "User not found"
 var messageStatusBadRequest = "Invalid data"
 var errorCreatingUser = "Error creating user"
 var errorDeletingUser = "Error deleting user"
 var searchById = "id = ?"
+var messageInvalidEmail = "Invalid email address"
+
+// newUserResponse returns the user without its password.
+func newUserResponse(user models.User, roles []string) UserResponse {
+	return UserResponse{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		Roles:         roles,
+		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		DeletedAt:     user.DeletedAt,
+	}
+}
+
+// findUser loads the user, answering the request when it fails.
+func (h *Handlers) findUser(c *gin.Context, userID uuid.UUID) (models.User, bool) {
+	user, err := h.users.Get(c.Request.Context(), userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return user, false
+	}
+	if err != nil {
+		respondInternalError(c, "Error retrieving user", err)
+		return user, false
+	}
+	return user, true
+}
 
 // GetAllUsersHandler gets all users.
 // @Summary Get all users
@@ -78,12 +112,12 @@ var searchById = "id = ?"
 //
 //	@Success 200 {object} UsersResponse
 //
-// @Failure 401 {object} ErrorResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 401 {object} responses.Problem
+// @Failure 400 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
 // @Router /users [get]
-func GetAllUsersHandler(c *gin.Context) {
+func (h *Handlers) GetAllUsersHandler(c *gin.Context) {
 	//extract query params
 	start := c.DefaultQuery("start", "0")
 	limit := c.DefaultQuery("limit", "10")
@@ -92,46 +126,33 @@ func GetAllUsersHandler(c *gin.Context) {
 
 	//validate and convert params
 	startInt, err := strconv.Atoi(start)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'start' parameter"})
+	if err != nil || startInt < 0 {
+		respondError(c, responses.CodeBadRequest, "Invalid 'start' parameter")
 		return
 	}
 
 	limitInt, err := strconv.Atoi(limit)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit' parameter"})
+		respondError(c, responses.CodeBadRequest, "Invalid 'limit' parameter")
 		return
 	}
 
-	//validate and sanitize sorting field
-	var sortField string
-	switch sort {
-	case "id", "name", "email":
-		sortField = sort
-	default:
-		sortField = "id"
-	}
-
-	var sortOrder string
-	switch sortDir {
-	case "asc", "desc":
-		sortOrder = sortDir
-	default:
-		sortOrder = "asc"
-	}
-
-	db := database.GetDB()
-
-	var users []models.User
-
-	//query the database with pagination and sorting
-	query := db.Offset(startInt).Limit(limitInt).Order(sortField + " " + sortOrder).Find(&users)
-	if err = query.Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving users", "details": err.Error()})
+	users, err := h.users.List(c.Request.Context(), startInt, limitInt, sort, sortDir)
+	if err != nil {
+		respondInternalError(c, "Error retrieving users", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	response := UsersResponse{Users: make([]UserResponse, 0, len(users))}
+	for _, user := range users {
+		roles, err := h.users.RoleNames(c.Request.Context(), user.ID)
+		if err != nil {
+			respondInternalError(c, "Error retrieving users", err)
+			return
+		}
+		response.Users = append(response.Users, newUserResponse(user, roles))
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetUserByIDHandler gets a user by ID.
@@ -143,127 +164,193 @@ func GetAllUsersHandler(c *gin.Context) {
 // @Produce json
 // @Param id path string true "User ID"
 // @Success 200 {object} UserResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
 // @Router /users/{id} [get]
-func GetUserByIDHandler(c *gin.Context) {
-	userIDStr := c.Param("id")
-	userID, err := uuid.Parse(userIDStr)
+func (h *Handlers) GetUserByIDHandler(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, responses.CodeBadRequest, "Invalid user ID")
 		return
 	}
 
-	db := database.GetDB()
-
-	var existingUser models.User
-	if err := db.Where(searchById, userID).First(&existingUser).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": messageStatusNotFound})
+	existingUser, ok := h.findUser(c, userID)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, existingUser)
-
+	roles, err := h.users.RoleNames(c.Request.Context(), existingUser.ID)
+	if err != nil {
+		respondInternalError(c, "Error retrieving user", err)
+		return
+	}
+	c.JSON(http.StatusOK, newUserResponse(existingUser, roles))
 }
 
 // CreateUserHandler creates a new user.
 // @Summary Create a new user
-// @Description Create a new user
+// @Description Sign up. Depending on the registration mode, an invitation to the email address is required, or the account waits for the approval of an administrator unless invited
 // @ID create-user
 // @Tags Users
 // @Accept json
 // @Produce json
-// @Param user body UserBodyWithoutID true "User object"
+// @Param user body SignupBody true "User object"
 // @Success 201 {object} UserResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 400 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /users [post]
 func CreateUserHandler(c *gin.Context) {
-	var newUser models.User
+	var body SignupBody
 	// request body json
-	if err := c.BindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": messageStatusBadRequest})
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	if !services.IsValidEmail(body.Email) {
+		respondInvalidFields(c, messageInvalidEmail, responses.FieldError{Field: "email", Message: "must be an email address"})
+		return
+	}
+
+	db := tenantDB(c)
+
+	mode := loadRegistrationSettings(db).Mode
+	if mode == registrationModeInvite && body.InviteToken == "" {
+		respondError(c, responses.CodeInvitationRequired, "Registration requires an invitation")
 		return
 	}
 
 	//gerar um novo uuid
-	newUser.ID = uuid.New()
+	newUser := models.User{
+		ID:     uuid.New(),
+		Name:   body.Name,
+		Email:  body.Email,
+		Status: models.UserStatusActive,
+	}
+	if mode == registrationModeApproval && body.InviteToken == "" {
+		newUser.Status = models.UserStatusPending
+	}
 
 	//transformar senha do usuário em hash
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashNewPassword(db, newUser, body.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorCreatingUser, "details": err.Error()})
+		if !abortNewPassword(c, err) {
+			respondInternalError(c, errorCreatingUser, err)
+		}
 		return
 	}
-	newUser.Password = string(hashedPassword)
 
-	db := database.GetDB()
-
-	if err := db.Create(&newUser).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := setUserPassword(tx, &newUser, hashedPassword); err != nil {
+			return err
+		}
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		if body.InviteToken == "" {
+			return nil
+		}
+		return redeemInvite(tx, body.InviteToken, newUser)
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInvite) {
+			respondError(c, responses.CodeInvalidLink, messageInvalidInvite)
+			return
+		}
 		if strings.Contains(err.Error(), "name") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Error creating user. Name already in use", "details": err.Error()})
+			respondError(c, responses.CodeAlreadyExists, "Error creating user. Name already in use")
 			return
 		}
 		if strings.Contains(err.Error(), "email") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Error creating user. Email already in use", "details": err.Error()})
+			respondError(c, responses.CodeAlreadyExists, "Error creating user. Email already in use")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorCreatingUser, "details": err.Error()})
+		respondInternalError(c, errorCreatingUser, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, newUser)
+	// a failure to send the email must not fail the sign up, the user can
+	// ask for a new one later
+	if err := sendVerificationEmail(db, newUser); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending verification email", "user", newUser.ID, "error", err)
+	}
+
+	c.JSON(http.StatusCreated, newUserResponse(newUser, []string{}))
 }
 
-// CreateUserMasterHandler creates a new user master.
-// @Summary Create a new user master
-// @Description Create a new user master
+// CreateUserMasterHandler creates a new user with the admin role.
+// @Summary Create a new admin user
+// @Description Create a new user with the admin role
 // @ID create-user-master
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param user body UserBodyWithoutID true "User object"
 // @Success 201 {object} UserResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /usersMaster [post]
 func CreateUserMasterHandler(c *gin.Context) {
-	var newUser models.User
+	var body UserBodyWithoutID
 	// request body json
-	if err := c.BindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": messageStatusBadRequest})
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
 		return
 	}
+	newUser := models.User{Name: body.Name, Email: body.Email}
 
-	//gerar um novo uuid
-	newUser.ID = uuid.New()
+	if !services.IsValidEmail(newUser.Email) {
+		respondInvalidFields(c, messageInvalidEmail, responses.FieldError{Field: "email", Message: "must be an email address"})
+		return
+	}
+
+	db := tenantDB(c)
 
 	//transformar senha do usuário em hash
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashNewPassword(db, newUser, body.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorCreatingUser, "details": err.Error()})
+		if !abortNewPassword(c, err) {
+			respondInternalError(c, errorCreatingUser, err)
+		}
 		return
 	}
-	newUser.Password = string(hashedPassword)
-	newUser.Master = true
 
-	db := database.GetDB()
+	//gerar um novo uuid
+	newUser.ID = uuid.New()
+	newUser.EmailVerified = false
+	newUser.TOTPEnabled = false
+	newUser.Status = models.UserStatusActive
 
-	if err := db.Create(&newUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorCreatingUser, "details": err.Error()})
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := setUserPassword(tx, &newUser, hashedPassword); err != nil {
+			return err
+		}
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		return setUserRoleByName(tx, newUser.ID, models.RoleAdmin, true)
+	})
+	if err != nil {
+		respondInternalError(c, errorCreatingUser, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, newUser)
+	c.JSON(http.StatusCreated, newUserResponse(newUser, []string{models.RoleAdmin}))
 }
 
 // UpdateUserHandler updates a user by ID.
 // @Summary Update a user by ID
-// @Description Update a user by ID
+// @Description Update a user by ID. Users update themselves, and the users with the users:manage permission anyone, the users with roles needing the roles:manage permission too. The sessions of a user whose password is changed by another one are ended
 // @ID update-user
 // @Tags Users
 // @Accept json
@@ -271,134 +358,196 @@ func CreateUserMasterHandler(c *gin.Context) {
 // @Param id path string true "User ID"
 // @Param user body UserBodyWithoutID true "User object"
 // @Success 200 {object} MessageWithUserResponse
-// @Failure 400 {object} ErrorResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
 // @Router /users/{id} [put]
-func UpdateUserHandler(c *gin.Context) {
+func (h *Handlers) UpdateUserHandler(c *gin.Context) {
 	userID := c.Param("id")
-
-	db := database.GetDB()
-
-	var existingUser models.User
-	if err := db.Where(searchById, userID).First(&existingUser).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": messageStatusNotFound})
+	if !canManageUser(c, userID) {
+		respondError(c, responses.CodeForbidden, "You can only update your own user")
 		return
 	}
 
-	var updatedUser models.User
-	if err := c.BindJSON(&updatedUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": messageStatusBadRequest})
+	// an invalid ID is a user that does not exist
+	id, _ := uuid.Parse(userID)
+	existingUser, ok := h.findUser(c, id)
+	if !ok {
 		return
 	}
 
-	if updatedUser.Name == "" && updatedUser.Email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and Email cannot be empty"})
-		return
+	// the users with roles may hold permissions the manager lacks: taking
+	// over their email or password takes those permissions
+	claims := c.MustGet("claims").(*Claims)
+	self := claims.UserID == existingUser.ID
+	if !self && !claims.HasPermission(models.PermissionRolesManage) {
+		roles, err := h.users.RoleNames(c.Request.Context(), existingUser.ID)
+		if err != nil {
+			respondInternalError(c, "Error updating user", err)
+			return
+		}
+		if len(roles) > 0 {
+			respondError(c, responses.CodeMissingPermission, "Missing permission "+models.PermissionRolesManage+" to update a user with roles")
+			return
+		}
 	}
 
-	if updatedUser.Name != "" {
-		existingUser.Name = updatedUser.Name
+	var updatedUser UserBodyWithoutID
+	if err := c.ShouldBindJSON(&updatedUser); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
 	}
 
-	if updatedUser.Email != "" {
-		existingUser.Email = updatedUser.Email
+	previousEmail := existingUser.Email
+	err := h.users.ApplyChanges(&existingUser, services.UserChanges{Name: updatedUser.Name, Email: updatedUser.Email})
+	switch {
+	case errors.Is(err, services.ErrNoUserChanges):
+		respondError(c, responses.CodeBadRequest, "Name and Email cannot be empty")
+		return
+	case errors.Is(err, services.ErrInvalidEmail):
+		respondInvalidFields(c, messageInvalidEmail, responses.FieldError{Field: "email", Message: "must be an email address"})
+		return
 	}
 
-	// if updatedUser.Master != existingUser.Master && updatedUser.Master != nil {
-	// 	existingUser.Master = updatedUser.Master
-	// }
-
-	if updatedUser.Password != existingUser.Password && updatedUser.Password != "" {
-		existingUser.Password = updatedUser.Password
+	// the verification links sent to the former address cannot verify the new one
+	if existingUser.Email != previousEmail {
+		if err := expireAccountTokens(tenantDB(c), existingUser.ID, tokenPurposeVerifyEmail); err != nil {
+			respondInternalError(c, "Error updating user", err)
+			return
+		}
 	}
 
-	if err := db.Save(&existingUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user", "details": err.Error()})
+	if updatedUser.Password == "" {
+		err = h.users.Save(c.Request.Context(), &existingUser)
+	} else {
+		// the password policy and history are kept in the database
+		db := tenantDB(c)
+		hashedPassword, hashErr := hashNewPassword(db, existingUser, updatedUser.Password)
+		if hashErr != nil {
+			if !abortNewPassword(c, hashErr) {
+				respondInternalError(c, "Error updating user", hashErr)
+			}
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := setUserPassword(tx, &existingUser, hashedPassword); err != nil {
+				return err
+			}
+			if err := tx.Save(&existingUser).Error; err != nil {
+				return err
+			}
+			// whoever knew the former password is logged out
+			if !self {
+				return revokeUserSessions(tx, existingUser.ID)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		respondInternalError(c, "Error updating user", err)
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "user": existingUser})
+	roles, _ := h.users.RoleNames(c.Request.Context(), existingUser.ID)
+	c.JSON(http.StatusOK, MessageWithUserResponse{Message: "User updated successfully", User: newUserResponse(existingUser, roles)})
 }
 
 // DeleteUserHandler deletes a user by ID.
 // @Summary Delete a user by ID
-// @Description Delete a user by ID
+// @Description Delete a user by ID. Users delete themselves, and the users with the users:manage permission anyone without roles
 // @ID delete-user
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
 // @Success 200 {object} MessageResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
 // @Router /users/{id} [delete]
-func DeleteUserHandler(c *gin.Context) {
+func (h *Handlers) DeleteUserHandler(c *gin.Context) {
 	userID := c.Param("id")
-
-	db := database.GetDB()
-
-	var existingUser models.User
-	if err := db.Where(searchById, userID).First(&existingUser).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": messageStatusNotFound})
+	if !canManageUser(c, userID) {
+		respondError(c, responses.CodeForbidden, "You can only delete your own user")
 		return
 	}
 
-	if existingUser.Master {
-		c.JSON(http.StatusNotFound, gin.H{"error": "You cannot delete a user master"})
+	// an invalid ID is a user that does not exist
+	id, _ := uuid.Parse(userID)
+	existingUser, ok := h.findUser(c, id)
+	if !ok {
 		return
 	}
 
-	if err := db.Delete(&existingUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorDeletingUser, "details": err.Error()})
+	err := h.users.Delete(c.Request.Context(), existingUser)
+	if errors.Is(err, services.ErrUserHasRoles) {
+		respondError(c, responses.CodeForbidden, "You cannot delete a user with roles")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, errorDeletingUser, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
-// DeleteUserMasterHandler deletes a user master by ID.
-// @Summary Delete a user master by ID
-// @Description Delete a user master by ID
+// DeleteUserMasterHandler deletes any user by ID.
+// @Summary Delete any user by ID
+// @Description Delete any user by ID, except the last user with the admin role
 // @ID delete-user-master
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
 // @Success 200 {object} MessageResponse
-// @Failure 401 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
-// @Failure 500 {object} ErrorResponseWithDetails
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Security Bearer
+// @Security ApiKey
 // @Router /usersMaster/{id} [delete]
 func DeleteUserMasterHandler(c *gin.Context) {
 	userID := c.Param("id")
 
-	db := database.GetDB()
+	db := tenantDB(c)
 
 	var existingUser models.User
 	if err := db.Where(searchById, userID).First(&existingUser).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": messageStatusNotFound})
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	if isLastAdmin(db, existingUser.ID) {
+		respondError(c, responses.CodeLastAdmin, "You cannot delete the last user with the admin role")
 		return
 	}
 
-	var count int64
-	var users []models.User
-	if err := db.Find(&users).Count(&count).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorDeletingUser, "details": err.Error()})
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", existingUser.ID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&existingUser).Error
+	})
+	if err != nil {
+		respondInternalError(c, errorDeletingUser, err)
 		return
 	}
 
-	if count == 1 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorDeletingUser, "details": "You cannot delete the last user master"})
+	if err := revokeUserSessions(db, existingUser.ID); err != nil {
+		respondInternalError(c, "Error revoking user sessions", err)
 		return
 	}
 
-	if err := db.Delete(&existingUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorDeletingUser, "details": err.Error()})
+	if err := revokeUserAPIKeys(db, existingUser.ID); err != nil {
+		respondInternalError(c, "Error revoking user API keys", err)
 		return
 	}
 
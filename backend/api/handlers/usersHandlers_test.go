@@ -2,12 +2,19 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/config"
 	"document-manager/database"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -22,43 +29,83 @@ var refreshToken string
 var userId string
 var userName string
 
+// TestMain runs the tests on a throwaway SQLite database, unless DB_DRIVER
+// chooses one, such as DB_DRIVER=postgres with the DB_ variables of a server.
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_DRIVER") != "" {
+		os.Exit(m.Run())
+	}
+	directory, err := os.MkdirTemp("", "document-manager-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DB_DRIVER", config.DriverSQLite)
+	os.Setenv("DB_PATH", filepath.Join(directory, "test.db"))
+	code := m.Run()
+	os.RemoveAll(directory)
+	os.Exit(code)
+}
+
 func runInitDb() *gorm.DB {
-	db, err := database.InitDB()
+	_, err := database.InitDB()
 	if err != nil {
 		log.Fatal("Erro ao configurar a conexão com o banco de dados", err)
 	}
 	//será executado no final do bloco
 	// defer db.Close()
 
-	//executar a migração automática
-	err = db.AutoMigrate(&models.User{})
+	//executar as migrações
+	_, err = database.Migrate()
 	if err != nil {
-		log.Fatal("Error creating table 'users':", err)
+		log.Fatal("Error migrating the database:", err)
 	}
-	err = db.AutoMigrate(&models.Document{})
+	err = database.InitTenants(config.Get().Tenants)
 	if err != nil {
-		log.Fatal("Error creating table 'documents':", err)
+		log.Fatal("Error creating default tenant:", err)
 	}
-
-	err = database.InitMasterUser()
+	err = database.InitRoles()
+	if err != nil {
+		log.Fatal("Error creating built-in roles:", err)
+	}
+	err = database.InitMasterUser(config.Get().Tenants)
 	if err != nil {
 		log.Fatal("Error creating default user master:", err)
 	}
 
-	return db
+	return testDB()
+}
+
+// testDB returns the database scoped to the default tenant, the tenant of the
+// test requests.
+func testDB() *gorm.DB {
+	tenant, err := database.FindTenant(models.DefaultTenantSlug)
+	if err != nil {
+		log.Fatal("Error retrieving the default tenant:", err)
+	}
+	return database.GetDB().WithContext(database.WithTenant(context.Background(), tenant.ID))
+}
+
+// gormHandlers returns the handlers on the database of runInitDb, as
+// api.SetupRouter builds them.
+func gormHandlers() *Handlers {
+	db := database.GetDB()
+	return New(
+		services.NewDocumentService(repositories.NewGormDocumentRepository(db), NewStorageQuotas(db)),
+		services.NewUserService(repositories.NewGormUserRepository(db), NewSessionRevoker(db)),
+	)
 }
 
 func createUserForTokenAcess() {
 	db := runInitDb()
 	db = db.Unscoped()
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 
 	newUserID := uuid.New()
 	newUser := models.User{
 		ID:       newUserID,
 		Name:     "newUserMaster",
 		Email:    "test2121@example.com",
-		Master:   true,
 		Password: "password",
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
@@ -67,9 +114,12 @@ func createUserForTokenAcess() {
 		return
 	}
 	newUser.Password = string(hashedPassword)
-	newUser.Master = true
 
 	db.Create(&newUser)
+	if err := setUserRoleByName(testDB(), newUser.ID, models.RoleAdmin, true); err != nil {
+		println("error", err)
+		return
+	}
 
 	r.POST("/login", LoginHandler)
 
@@ -116,6 +166,7 @@ func createUserForTokenAcess() {
 		return
 	}
 	// Excluir o usuário após o teste
+	testDB().Where("user_id = ?", existingUser.ID).Delete(&models.UserRole{})
 	err = db.Delete(&existingUser).Error
 	if err != nil {
 		println("error", err)
@@ -127,8 +178,9 @@ func TestGetAllUsersHandler(t *testing.T) {
 	runInitDb()
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.GET("/users", AuthMiddleware, GetAllUsersHandler)
+	r.GET("/users", AuthMiddleware, gormHandlers().GetAllUsersHandler)
 
 	req, _ := http.NewRequest("GET", "/users", nil)
 	req.Header.Set("Authorization", accessToken)
@@ -145,6 +197,14 @@ func TestGetAllUsersHandler(t *testing.T) {
 	usersLength := len(response.Users)
 	// usando zero no lugar do mínimo de usuários esperados no banco de dados.
 	assert.GreaterOrEqual(t, usersLength, 0, "The length of 'users' should be greater than or equal to 0")
+	for _, user := range response.Users {
+		if user.Name == "master" {
+			assert.Equal(t, []string{models.RoleAdmin}, user.Roles)
+		}
+	}
+	// neither the password hashes nor the tenants are answered
+	assert.NotContains(t, resp.Body.String(), "password")
+	assert.NotContains(t, resp.Body.String(), "tenant_id")
 }
 
 func TestGetUserByIDHandler(t *testing.T) {
@@ -152,10 +212,9 @@ func TestGetUserByIDHandler(t *testing.T) {
 
 	testUserID := uuid.New()
 	testUser := models.User{
-		ID:     testUserID,
-		Name:   "Test User",
-		Email:  "test@example.com",
-		Master: false,
+		ID:    testUserID,
+		Name:  "Test User",
+		Email: "test@example.com",
 	}
 
 	db.Create(&testUser)
@@ -165,8 +224,9 @@ func TestGetUserByIDHandler(t *testing.T) {
 	assert.Nil(t, err)
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.GET("/users/:id", AuthMiddleware, GetUserByIDHandler)
+	r.GET("/users/:id", AuthMiddleware, gormHandlers().GetUserByIDHandler)
 
 	req, _ := http.NewRequest("GET", "/users/"+testUserID.String(), nil)
 	req.Header.Set("Authorization", accessToken)
@@ -182,7 +242,8 @@ func TestGetUserByIDHandler(t *testing.T) {
 	assert.Equal(t, testUserID, userResponse.ID)
 	assert.Equal(t, existingUser.Name, userResponse.Name)
 	assert.Equal(t, existingUser.Email, userResponse.Email)
-	assert.Equal(t, false, userResponse.Master)
+	assert.Empty(t, userResponse.Roles)
+	assert.NotContains(t, resp.Body.String(), "password")
 	err = db.Unscoped().Delete(&existingUser).Error
 	assert.Nil(t, err)
 }
@@ -192,12 +253,13 @@ func TestCreateUserHandler(t *testing.T) {
 	// Temporariamente desativar o Soft Delete para este teste
 	db = db.Unscoped()
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	r.POST("/users", CreateUserHandler)
 
 	newUser := UserBody{
 		Name:     "New user",
 		Email:    "new@example.com",
-		Password: "password",
+		Password: "new-user-password",
 	}
 	reqBody, err := json.Marshal(newUser)
 	assert.Nil(t, err)
@@ -219,7 +281,7 @@ func TestCreateUserHandler(t *testing.T) {
 	assert.NotEqual(t, uuid.Nil, userResponse.ID)
 	assert.Equal(t, newUser.Name, userResponse.Name)
 	assert.Equal(t, newUser.Email, userResponse.Email)
-	assert.Equal(t, false, userResponse.Master)
+	assert.Empty(t, userResponse.Roles)
 	// Excluir o usuário após o teste
 	err = db.Delete(&existingUser).Error
 	assert.Nil(t, err)
@@ -230,12 +292,13 @@ func TestCreateUserMasterHandler(t *testing.T) {
 	// Temporariamente desativar o Soft Delete para este teste
 	db = db.Unscoped()
 	r := gin.Default()
-	r.POST("/usersMaster", AuthMiddlewareMaster, CreateUserMasterHandler)
+	r.Use(TenantMiddleware)
+	r.POST("/usersMaster", AuthMiddleware, RequirePermission(models.PermissionUsersManage), CreateUserMasterHandler)
 
 	newUser := UserBody{
 		Name:     "New user",
 		Email:    "new@example.com",
-		Password: "password",
+		Password: "new-user-password",
 	}
 	reqBody, err := json.Marshal(newUser)
 	assert.Nil(t, err)
@@ -258,8 +321,10 @@ func TestCreateUserMasterHandler(t *testing.T) {
 	assert.NotEqual(t, uuid.Nil, userResponse.ID)
 	assert.Equal(t, newUser.Name, userResponse.Name)
 	assert.Equal(t, newUser.Email, userResponse.Email)
-	assert.Equal(t, true, userResponse.Master)
+	assert.Equal(t, []string{models.RoleAdmin}, userResponse.Roles)
+	assert.True(t, userHasRole(testDB(), existingUser.ID, models.RoleAdmin))
 	// Excluir o usuário após o teste
+	testDB().Where("user_id = ?", existingUser.ID).Delete(&models.UserRole{})
 	err = db.Delete(&existingUser).Error
 	assert.Nil(t, err)
 }
@@ -268,10 +333,9 @@ func TestUpdateUserHandler(t *testing.T) {
 	db := runInitDb()
 	testUserID := uuid.New()
 	testUser := models.User{
-		ID:     testUserID,
-		Name:   "Test User",
-		Email:  "test@example.com",
-		Master: false,
+		ID:    testUserID,
+		Name:  "Test User",
+		Email: "test@example.com",
 	}
 
 	db.Create(&testUser)
@@ -281,8 +345,9 @@ func TestUpdateUserHandler(t *testing.T) {
 	assert.Nil(t, err)
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.PUT("/users/:id", AuthMiddleware, UpdateUserHandler)
+	r.PUT("/users/:id", AuthMiddleware, gormHandlers().UpdateUserHandler)
 
 	updateUserData := UserBody{
 		Name:  "Update User",
@@ -307,20 +372,56 @@ func TestUpdateUserHandler(t *testing.T) {
 	assert.Equal(t, testUserID, response.User.ID)
 	assert.Equal(t, updateUserData.Name, response.User.Name)
 	assert.Equal(t, updateUserData.Email, response.User.Email)
-	assert.Equal(t, false, response.User.Master)
+	assert.Empty(t, response.User.Roles)
 	err = db.Unscoped().Delete(&existingUser).Error
 	assert.Nil(t, err)
 }
 
+func TestUpdateUserWithRoles(t *testing.T) {
+	runInitDb()
+	db := testDB()
+	manager := createTestUser(t, db, "User Manager", "user-manager@example.com", "password", false)
+	admin := createTestUser(t, db, "Managed Admin", "managed-admin@example.com", "password", true)
+	user := createTestUser(t, db, "Managed User", "managed-user@example.com", "password", false)
+	role := models.Role{ID: uuid.New(), Name: "user-manager", Permissions: models.PermissionUsersManage}
+	assert.Nil(t, db.Create(&role).Error)
+	assert.Nil(t, db.Create(&models.UserRole{UserID: manager.ID, RoleID: role.ID}).Error)
+	defer func() {
+		deleteTestUser(db, manager)
+		deleteTestUser(db, admin)
+		deleteTestUser(db, user)
+		db.Delete(&role)
+	}()
+
+	r := authTestRouter()
+	h := gormHandlers()
+	r.PUT("/users/:id", AuthMiddleware, h.UpdateUserHandler)
+	managerLogin := login(t, r, manager.Name, "password")
+	userLogin := login(t, r, user.Name, "password")
+
+	// the email of an admin would let the manager reset its password
+	resp := requestJSONWithToken(r, "PUT", "/users/"+admin.ID.String(), managerLogin.AccessToken, UserBodyWithoutID{Email: "taken-over@example.com"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, responses.CodeMissingPermission, problemOf(t, resp).Code)
+	var stored models.User
+	assert.Nil(t, db.First(&stored, "id = ?", admin.ID).Error)
+	assert.Equal(t, admin.Email, stored.Email)
+
+	// the users without roles are managed, and logged out by a new password
+	resp = requestJSONWithToken(r, "PUT", "/users/"+user.ID.String(), managerLogin.AccessToken, UserBodyWithoutID{Name: user.Name, Password: "a-new-password"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = postWithToken(r, "/logout", userLogin.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
 func TestDeleteUserHandler(t *testing.T) {
 	db := runInitDb()
 
 	testUserID := uuid.New()
 	testUser := models.User{
-		ID:     testUserID,
-		Name:   "Test User",
-		Email:  "test@example.com",
-		Master: false,
+		ID:    testUserID,
+		Name:  "Test User",
+		Email: "test@example.com",
 	}
 
 	db.Create(&testUser)
@@ -330,8 +431,9 @@ func TestDeleteUserHandler(t *testing.T) {
 	assert.Nil(t, err)
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.DELETE("/users/:id", AuthMiddleware, DeleteUserHandler)
+	r.DELETE("/users/:id", AuthMiddleware, gormHandlers().DeleteUserHandler)
 
 	req, _ := http.NewRequest("DELETE", "/users/"+testUserID.String(), nil)
 	req.Header.Set("Authorization", accessToken)
@@ -354,21 +456,22 @@ func TestDeleteUserMasterHandler(t *testing.T) {
 
 	testUserID := uuid.New()
 	testUser := models.User{
-		ID:     testUserID,
-		Name:   "Test User",
-		Email:  "test@example.com",
-		Master: true,
+		ID:    testUserID,
+		Name:  "Test User",
+		Email: "test@example.com",
 	}
 
 	db.Create(&testUser)
+	assert.Nil(t, setUserRoleByName(db, testUserID, models.RoleAdmin, true))
 
 	var existingUser models.User
 	err := db.First(&existingUser, "email = ?", testUser.Email).Error
 	assert.Nil(t, err)
 
 	r := gin.Default()
+	r.Use(TenantMiddleware)
 	createUserForTokenAcess()
-	r.DELETE("/usersMaster/:id", AuthMiddlewareMaster, DeleteUserMasterHandler)
+	r.DELETE("/usersMaster/:id", AuthMiddleware, RequirePermission(models.PermissionUsersManage), DeleteUserMasterHandler)
 
 	req, _ := http.NewRequest("DELETE", "/usersMaster/"+testUserID.String(), nil)
 	req.Header.Set("Authorization", accessToken)
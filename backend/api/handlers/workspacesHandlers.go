@@ -0,0 +1,729 @@
+package handlers
+
+import (
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/api/services"
+	"document-manager/database"
+	"document-manager/mailer"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const workspaceHeader = "X-Workspace-ID"
+
+var workspaceInvitationTTL = time.Hour * 24 * 7
+
+var messageWorkspaceNotFound = "Workspace not found"
+var messageNotWorkspaceOwner = "Only the owners of the workspace can do this"
+
+var errLastWorkspaceOwner = errors.New("the last owner of a workspace cannot leave it")
+
+type WorkspaceBody struct {
+	Name string `json:"name"`
+}
+
+type WorkspaceResponse struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Personal bool      `json:"personal"`
+	// role of the logged user in the workspace
+	Role string `json:"role"`
+}
+
+type WorkspacesResponse struct {
+	Workspaces []WorkspaceResponse `json:"workspaces"`
+}
+
+type WorkspaceMemberBody struct {
+	Role string `json:"role"`
+}
+
+type WorkspaceMemberResponse struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	Email  string    `json:"email"`
+	Role   string    `json:"role"`
+}
+
+type WorkspaceMembersResponse struct {
+	Members []WorkspaceMemberResponse `json:"members"`
+}
+
+type WorkspaceInvitationBody struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type WorkspaceInvitationsResponse struct {
+	Invitations []models.WorkspaceInvitation `json:"invitations"`
+}
+
+// workspaceAccess is the workspace a request works in and the role of the
+// logged user in it. Role is empty for users who are not members but whose
+// token grants permission over the documents of everyone.
+type workspaceAccess struct {
+	Workspace models.Workspace
+	Role      string
+}
+
+func newWorkspaceResponse(workspace models.Workspace, role string) WorkspaceResponse {
+	return WorkspaceResponse{
+		ID:       workspace.ID,
+		Name:     workspace.Name,
+		Personal: workspace.PersonalUserID != nil,
+		Role:     role,
+	}
+}
+
+func isWorkspaceRole(role string) bool {
+	for _, known := range models.WorkspaceRoles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceRole returns the role of the user in the workspace, or an empty string when it is not a member.
+func workspaceRole(db *gorm.DB, workspaceID uuid.UUID, userID uuid.UUID) string {
+	var member models.WorkspaceMember
+	if err := db.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// isLastWorkspaceOwner reports whether the user is the only owner of the workspace.
+func isLastWorkspaceOwner(db *gorm.DB, workspaceID uuid.UUID, userID uuid.UUID) bool {
+	if workspaceRole(db, workspaceID, userID) != models.WorkspaceRoleOwner {
+		return false
+	}
+	var count int64
+	db.Model(&models.WorkspaceMember{}).Where("workspace_id = ? AND role = ?", workspaceID, models.WorkspaceRoleOwner).Count(&count)
+	return count <= 1
+}
+
+// WorkspaceMiddleware resolves the workspace of the request from the
+// X-Workspace-ID header, or the personal workspace of the logged user when
+// the header is missing. It must follow AuthMiddleware.
+func WorkspaceMiddleware(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	header := c.GetHeader(workspaceHeader)
+	if header == "" {
+		workspace, err := database.PersonalWorkspace(db, claims.UserID)
+		if err != nil {
+			respondInternalError(c, "Error retrieving workspace", err)
+			return
+		}
+		c.Set("workspace", &workspaceAccess{Workspace: workspace, Role: models.WorkspaceRoleOwner})
+		c.Next()
+		return
+	}
+
+	var workspace models.Workspace
+	workspaceID, err := uuid.Parse(header)
+	if err == nil {
+		err = db.Where(searchById, workspaceID).First(&workspace).Error
+	}
+	role := ""
+	if err == nil {
+		role = workspaceRole(db, workspace.ID, claims.UserID)
+	}
+	// other workspaces are hidden from who cannot see them
+	if err != nil || (role == "" && !claims.HasPermission(models.PermissionDocumentsReadAll)) {
+		respondError(c, responses.CodeNotFound, messageWorkspaceNotFound)
+		return
+	}
+
+	c.Set("workspace", &workspaceAccess{Workspace: workspace, Role: role})
+	c.Next()
+}
+
+func currentWorkspace(c *gin.Context) *workspaceAccess {
+	return c.MustGet("workspace").(*workspaceAccess)
+}
+
+// findWorkspace loads the workspace of the workspaceId path parameter and the
+// role of the logged user in it, answering the request when it fails.
+func findWorkspace(c *gin.Context, db *gorm.DB) (models.Workspace, string, bool) {
+	claims := c.MustGet("claims").(*Claims)
+
+	var workspace models.Workspace
+	workspaceID, err := uuid.Parse(c.Param("workspaceId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid workspace ID")
+		return workspace, "", false
+	}
+	if err := db.Where(searchById, workspaceID).First(&workspace).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageWorkspaceNotFound)
+		return workspace, "", false
+	}
+
+	role := workspaceRole(db, workspace.ID, claims.UserID)
+	if role == "" {
+		respondError(c, responses.CodeNotFound, messageWorkspaceNotFound)
+		return workspace, "", false
+	}
+	return workspace, role, true
+}
+
+// findOwnedWorkspace is findWorkspace for the actions reserved to the owners of a shared workspace.
+func findOwnedWorkspace(c *gin.Context, db *gorm.DB) (models.Workspace, bool) {
+	workspace, role, ok := findWorkspace(c, db)
+	if !ok {
+		return workspace, false
+	}
+	if role != models.WorkspaceRoleOwner {
+		respondError(c, responses.CodeForbidden, messageNotWorkspaceOwner)
+		return workspace, false
+	}
+	if workspace.PersonalUserID != nil {
+		respondError(c, responses.CodeBadRequest, "A personal workspace cannot be shared")
+		return workspace, false
+	}
+	return workspace, true
+}
+
+// GetWorkspacesHandler lists the workspaces of the logged user.
+// @Summary Get my workspaces
+// @Description List the workspaces the logged user is a member of, starting with its personal workspace
+// @ID get-workspaces
+// @Tags Workspaces
+// @Produce json
+// @Success 200 {object} WorkspacesResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces [get]
+func GetWorkspacesHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	if _, err := database.PersonalWorkspace(db, claims.UserID); err != nil {
+		respondInternalError(c, "Error retrieving workspaces", err)
+		return
+	}
+
+	var members []models.WorkspaceMember
+	if err := db.Where("user_id = ?", claims.UserID).Find(&members).Error; err != nil {
+		respondInternalError(c, "Error retrieving workspaces", err)
+		return
+	}
+	roles := map[uuid.UUID]string{}
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		roles[member.WorkspaceID] = member.Role
+		ids = append(ids, member.WorkspaceID)
+	}
+
+	var workspaces []models.Workspace
+	if err := db.Where("id IN ?", ids).Order("personal_user_id IS NULL, name").Find(&workspaces).Error; err != nil {
+		respondInternalError(c, "Error retrieving workspaces", err)
+		return
+	}
+
+	response := WorkspacesResponse{Workspaces: make([]WorkspaceResponse, 0, len(workspaces))}
+	for _, workspace := range workspaces {
+		response.Workspaces = append(response.Workspaces, newWorkspaceResponse(workspace, roles[workspace.ID]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateWorkspaceHandler creates a shared workspace.
+// @Summary Create a workspace
+// @Description Create a shared workspace, owned by the logged user
+// @ID create-workspace
+// @Tags Workspaces
+// @Accept json
+// @Produce json
+// @Param workspace body WorkspaceBody true "Workspace"
+// @Success 201 {object} WorkspaceResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces [post]
+func CreateWorkspaceHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	var body WorkspaceBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		respondInvalidFields(c, "Name is required", responses.FieldError{Field: "name", Message: "is required"})
+		return
+	}
+
+	workspace := models.Workspace{ID: uuid.New(), Name: body.Name}
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: claims.UserID, Role: models.WorkspaceRoleOwner}).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error creating workspace", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newWorkspaceResponse(workspace, models.WorkspaceRoleOwner))
+}
+
+// UpdateWorkspaceHandler renames a workspace.
+// @Summary Rename a workspace
+// @Description Rename a workspace. Only its owners can do it
+// @ID update-workspace
+// @Tags Workspaces
+// @Accept json
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param workspace body WorkspaceBody true "Workspace"
+// @Success 200 {object} WorkspaceResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId} [put]
+func UpdateWorkspaceHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	workspace, role, ok := findWorkspace(c, db)
+	if !ok {
+		return
+	}
+	if role != models.WorkspaceRoleOwner {
+		respondError(c, responses.CodeForbidden, messageNotWorkspaceOwner)
+		return
+	}
+
+	var body WorkspaceBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		respondInvalidFields(c, "Name is required", responses.FieldError{Field: "name", Message: "is required"})
+		return
+	}
+
+	workspace.Name = strings.TrimSpace(body.Name)
+	if err := db.Save(&workspace).Error; err != nil {
+		respondInternalError(c, "Error updating workspace", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newWorkspaceResponse(workspace, role))
+}
+
+// GetWorkspaceMembersHandler lists the members of a workspace.
+// @Summary Get the members of a workspace
+// @Description List the members of a workspace the logged user is a member of
+// @ID get-workspace-members
+// @Tags Workspaces
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Success 200 {object} WorkspaceMembersResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/members [get]
+func GetWorkspaceMembersHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	workspace, _, ok := findWorkspace(c, db)
+	if !ok {
+		return
+	}
+
+	members := []WorkspaceMemberResponse{}
+	err := db.Model(&models.WorkspaceMember{}).
+		Select("workspace_members.user_id, users.name, users.email, workspace_members.role").
+		Joins("JOIN users ON users.id = workspace_members.user_id").
+		Where("workspace_members.workspace_id = ?", workspace.ID).
+		Order("users.name").
+		Scan(&members).Error
+	if err != nil {
+		respondInternalError(c, "Error retrieving members", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, WorkspaceMembersResponse{Members: members})
+}
+
+// UpdateWorkspaceMemberHandler changes the role of a member of a workspace.
+// @Summary Change the role of a member
+// @Description Change the role of a member of a workspace. Only its owners can do it
+// @ID update-workspace-member
+// @Tags Workspaces
+// @Accept json
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param userId path string true "User ID"
+// @Param member body WorkspaceMemberBody true "Role"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/members/{userId} [put]
+func UpdateWorkspaceMemberHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	workspace, ok := findOwnedWorkspace(c, db)
+	if !ok {
+		return
+	}
+
+	var body WorkspaceMemberBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	if !isWorkspaceRole(body.Role) {
+		respondInvalidFields(c, "Invalid role", responses.FieldError{Field: "role", Message: "must be one of " + strings.Join(models.WorkspaceRoles, ", ")})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil || workspaceRole(db, workspace.ID, userID) == "" {
+		respondError(c, responses.CodeNotFound, "Member not found")
+		return
+	}
+	if body.Role != models.WorkspaceRoleOwner && isLastWorkspaceOwner(db, workspace.ID, userID) {
+		respondError(c, responses.CodeConflict, errLastWorkspaceOwner.Error())
+		return
+	}
+
+	err = db.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id = ?", workspace.ID, userID).
+		Update("role", body.Role).Error
+	if err != nil {
+		respondInternalError(c, "Error updating member", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveWorkspaceMemberHandler removes a member from a workspace.
+// @Summary Remove a member
+// @Description Remove a member from a workspace. Owners can remove anyone, other members only themselves
+// @ID remove-workspace-member
+// @Tags Workspaces
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param userId path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/members/{userId} [delete]
+func RemoveWorkspaceMemberHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	workspace, role, ok := findWorkspace(c, db)
+	if !ok {
+		return
+	}
+	if workspace.PersonalUserID != nil {
+		respondError(c, responses.CodeBadRequest, "A personal workspace cannot be left")
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil || workspaceRole(db, workspace.ID, userID) == "" {
+		respondError(c, responses.CodeNotFound, "Member not found")
+		return
+	}
+	if userID != claims.UserID && role != models.WorkspaceRoleOwner {
+		respondError(c, responses.CodeForbidden, messageNotWorkspaceOwner)
+		return
+	}
+	if isLastWorkspaceOwner(db, workspace.ID, userID) {
+		respondError(c, responses.CodeConflict, errLastWorkspaceOwner.Error())
+		return
+	}
+
+	if err := db.Where("workspace_id = ? AND user_id = ?", workspace.ID, userID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		respondInternalError(c, "Error removing member", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// CreateWorkspaceInvitationHandler invites someone to join a workspace.
+// @Summary Invite to a workspace
+// @Description Send an invitation to join a workspace to an email address. Only its owners can do it
+// @ID create-workspace-invitation
+// @Tags Workspaces
+// @Accept json
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param invitation body WorkspaceInvitationBody true "Invitation"
+// @Success 201 {object} models.WorkspaceInvitation
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/invitations [post]
+func CreateWorkspaceInvitationHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	workspace, ok := findOwnedWorkspace(c, db)
+	if !ok {
+		return
+	}
+
+	var body WorkspaceInvitationBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+	if !services.IsValidEmail(body.Email) {
+		respondInvalidFields(c, messageInvalidEmail, responses.FieldError{Field: "email", Message: "must be an email address"})
+		return
+	}
+	if body.Role == "" {
+		body.Role = models.WorkspaceRoleViewer
+	}
+	if !isWorkspaceRole(body.Role) {
+		respondInvalidFields(c, "Invalid role", responses.FieldError{Field: "role", Message: "must be one of " + strings.Join(models.WorkspaceRoles, ", ")})
+		return
+	}
+
+	var inviter models.User
+	if err := db.Where(searchById, claims.UserID).First(&inviter).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		respondInternalError(c, "Error creating invitation", err)
+		return
+	}
+
+	invitation := models.WorkspaceInvitation{
+		ID:          uuid.New(),
+		WorkspaceID: workspace.ID,
+		Email:       body.Email,
+		Role:        body.Role,
+		TokenHash:   hashToken(token),
+		InvitedByID: inviter.ID,
+		ExpiresAt:   time.Now().Add(workspaceInvitationTTL),
+	}
+
+	// a new invitation replaces the pending one for the same address
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workspace_id = ? AND email = ? AND accepted_at IS NULL", workspace.ID, body.Email).Delete(&models.WorkspaceInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		respondInternalError(c, "Error creating invitation", err)
+		return
+	}
+
+	msg, err := mailer.NewMessage(invitation.Email, mailer.TemplateWorkspaceInvitation, map[string]string{
+		"InvitedBy": inviter.Name,
+		"Workspace": workspace.Name,
+		"Role":      invitation.Role,
+		"Link":      appURL() + "/accept-invitation?token=" + token,
+		"ExpiresIn": formatTTL(workspaceInvitationTTL),
+	})
+	if err == nil {
+		err = mailer.GetMailer().Send(msg)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sending invitation", "workspace", workspace.ID, "error", err)
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// GetWorkspaceInvitationsHandler lists the pending invitations of a workspace.
+// @Summary Get the invitations of a workspace
+// @Description List the pending invitations of a workspace. Only its owners can do it
+// @ID get-workspace-invitations
+// @Tags Workspaces
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Success 200 {object} WorkspaceInvitationsResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/invitations [get]
+func GetWorkspaceInvitationsHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	workspace, ok := findOwnedWorkspace(c, db)
+	if !ok {
+		return
+	}
+
+	invitations := []models.WorkspaceInvitation{}
+	err := db.Where("workspace_id = ? AND accepted_at IS NULL AND expires_at > ?", workspace.ID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		respondInternalError(c, "Error retrieving invitations", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, WorkspaceInvitationsResponse{Invitations: invitations})
+}
+
+// RevokeWorkspaceInvitationHandler revokes a pending invitation.
+// @Summary Revoke an invitation
+// @Description Revoke a pending invitation to a workspace. Only its owners can do it
+// @ID revoke-workspace-invitation
+// @Tags Workspaces
+// @Produce json
+// @Param workspaceId path string true "Workspace ID"
+// @Param invitationId path string true "Invitation ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspaces/{workspaceId}/invitations/{invitationId} [delete]
+func RevokeWorkspaceInvitationHandler(c *gin.Context) {
+	db := tenantDB(c)
+
+	workspace, ok := findOwnedWorkspace(c, db)
+	if !ok {
+		return
+	}
+
+	invitationID, err := uuid.Parse(c.Param("invitationId"))
+	if err != nil {
+		respondError(c, responses.CodeBadRequest, "Invalid invitation ID")
+		return
+	}
+
+	result := db.Where("id = ? AND workspace_id = ? AND accepted_at IS NULL", invitationID, workspace.ID).Delete(&models.WorkspaceInvitation{})
+	if result.Error != nil {
+		respondInternalError(c, "Error revoking invitation", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondError(c, responses.CodeNotFound, "Invitation not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptWorkspaceInvitationHandler makes the logged user a member of the workspace it was invited to.
+// @Summary Accept an invitation
+// @Description Join the workspace of an invitation sent to the verified email address of the logged user
+// @ID accept-workspace-invitation
+// @Tags Workspaces
+// @Accept json
+// @Produce json
+// @Param body body TokenBody true "Invitation token"
+// @Success 200 {object} WorkspaceResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Security Bearer
+// @Router /workspace-invitations/accept [post]
+func AcceptWorkspaceInvitationHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	db := tenantDB(c)
+
+	var body TokenBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondInvalid(c, messageStatusBadRequest, err)
+		return
+	}
+
+	var invitation models.WorkspaceInvitation
+	err := db.Where("token_hash = ? AND accepted_at IS NULL", hashToken(body.Token)).First(&invitation).Error
+	if err != nil || time.Now().After(invitation.ExpiresAt) {
+		respondError(c, responses.CodeInvalidLink, "Invalid or expired invitation")
+		return
+	}
+
+	var user models.User
+	if err := db.Where(searchById, claims.UserID).First(&user).Error; err != nil {
+		respondError(c, responses.CodeNotFound, messageStatusNotFound)
+		return
+	}
+	// anyone can set an email address, only its owner verifies it
+	if !user.EmailVerified {
+		respondError(c, responses.CodeEmailNotVerified, "Verify your email address to accept the invitation")
+		return
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		respondError(c, responses.CodeForbidden, "The invitation was sent to another email address")
+		return
+	}
+
+	var workspace models.Workspace
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(searchById, invitation.WorkspaceID).First(&workspace).Error; err != nil {
+			return err
+		}
+		// the condition on accepted_at keeps the invitation from being accepted twice
+		result := tx.Model(&models.WorkspaceInvitation{}).
+			Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errInvalidAccountToken
+		}
+		// members keep their role when invited again
+		return tx.Where(models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: user.ID}).
+			Attrs(models.WorkspaceMember{Role: invitation.Role}).
+			FirstOrCreate(&models.WorkspaceMember{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidAccountToken) {
+			respondError(c, responses.CodeInvalidLink, "Invalid or expired invitation")
+			return
+		}
+		respondInternalError(c, "Error accepting invitation", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newWorkspaceResponse(workspace, workspaceRole(db, workspace.ID, user.ID)))
+}
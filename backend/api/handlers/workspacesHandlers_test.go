@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"document-manager/api/models"
+	"document-manager/api/responses"
+	"document-manager/database"
+	"document-manager/mailer"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func workspacesTestRouter() *gin.Engine {
+	r := authTestRouter()
+	r.GET("/workspaces", AuthMiddleware, GetWorkspacesHandler)
+	r.POST("/workspaces", AuthMiddleware, CreateWorkspaceHandler)
+	r.PUT("/workspaces/:workspaceId", AuthMiddleware, UpdateWorkspaceHandler)
+	r.GET("/workspaces/:workspaceId/members", AuthMiddleware, GetWorkspaceMembersHandler)
+	r.PUT("/workspaces/:workspaceId/members/:userId", AuthMiddleware, UpdateWorkspaceMemberHandler)
+	r.DELETE("/workspaces/:workspaceId/members/:userId", AuthMiddleware, RemoveWorkspaceMemberHandler)
+	r.POST("/workspaces/:workspaceId/invitations", AuthMiddleware, CreateWorkspaceInvitationHandler)
+	r.GET("/workspaces/:workspaceId/invitations", AuthMiddleware, GetWorkspaceInvitationsHandler)
+	r.DELETE("/workspaces/:workspaceId/invitations/:invitationId", AuthMiddleware, RevokeWorkspaceInvitationHandler)
+	r.POST("/workspace-invitations/accept", AuthMiddleware, AcceptWorkspaceInvitationHandler)
+
+	h := gormHandlers()
+	r.GET("/documents", AuthMiddleware, WorkspaceMiddleware, h.GetAllDocumentsHandler)
+	r.GET("/documents/:id", AuthMiddleware, WorkspaceMiddleware, h.GetDocumentByIDHandler)
+	r.PUT("/documents/:id", AuthMiddleware, WorkspaceMiddleware, h.UpdateDocumentWithoutFileHandler)
+	r.DELETE("/documents/:id", AuthMiddleware, WorkspaceMiddleware, h.DeleteDocumentHandler)
+	r.POST("/documents/:id/transfer", AuthMiddleware, WorkspaceMiddleware, h.TransferDocumentHandler)
+	return r
+}
+
+// requestInWorkspace sends a request in the workspace, or in the personal
+// workspace when workspaceID is empty, with body as JSON when not nil.
+func requestInWorkspace(r *gin.Engine, method string, path string, token string, workspaceID string, body interface{}) *httptest.ResponseRecorder {
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	if workspaceID != "" {
+		req.Header.Set(workspaceHeader, workspaceID)
+	}
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func deleteTestWorkspace(db *gorm.DB, workspaceID uuid.UUID) {
+	db.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceInvitation{})
+	db.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceMember{})
+	db.Where(searchById, workspaceID).Delete(&models.Workspace{})
+}
+
+func TestWorkspaceInvitations(t *testing.T) {
+	db := runInitDb()
+	capture := &captureMailer{}
+	mailer.SetMailer(capture)
+	defer mailer.SetMailer(mailer.LogMailer{})
+
+	owner := createTestUser(t, db, "Workspace Owner", "workspace-owner@example.com", "password", false)
+	member := createTestUser(t, db, "Workspace Member", "workspace-member@example.com", "password", false)
+	stranger := createTestUser(t, db, "Workspace Stranger", "workspace-stranger@example.com", "password", false)
+	defer deleteTestUser(db, owner)
+	defer deleteTestUser(db, member)
+	defer deleteTestUser(db, stranger)
+
+	r := workspacesTestRouter()
+	ownerLogin := login(t, r, owner.Name, "password")
+	memberLogin := login(t, r, member.Name, "password")
+	strangerLogin := login(t, r, stranger.Name, "password")
+
+	resp := requestInWorkspace(r, "POST", "/workspaces", ownerLogin.AccessToken, "", WorkspaceBody{Name: "Legal"})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var workspace WorkspaceResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &workspace))
+	defer deleteTestWorkspace(db, workspace.ID)
+	assert.Equal(t, models.WorkspaceRoleOwner, workspace.Role)
+	assert.False(t, workspace.Personal)
+
+	resp = requestInWorkspace(r, "GET", "/workspaces", ownerLogin.AccessToken, "", nil)
+	var workspaces WorkspacesResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &workspaces))
+	assert.Equal(t, 2, len(workspaces.Workspaces))
+	assert.True(t, workspaces.Workspaces[0].Personal)
+	assert.Equal(t, "Legal", workspaces.Workspaces[1].Name)
+
+	invitationsPath := "/workspaces/" + workspace.ID.String() + "/invitations"
+	resp = requestInWorkspace(r, "POST", invitationsPath, memberLogin.AccessToken, "", WorkspaceInvitationBody{Email: member.Email})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = requestInWorkspace(r, "POST", invitationsPath, ownerLogin.AccessToken, "", WorkspaceInvitationBody{Email: member.Email, Role: "admin"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = requestInWorkspace(r, "POST", invitationsPath, ownerLogin.AccessToken, "", WorkspaceInvitationBody{Email: member.Email})
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var invitation models.WorkspaceInvitation
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &invitation))
+	assert.Equal(t, models.WorkspaceRoleViewer, invitation.Role)
+
+	msg := capture.last()
+	assert.Equal(t, []string{member.Email}, msg.To)
+	assert.Contains(t, msg.Subject, "Legal")
+	token := tokenFromMessage(msg)
+
+	resp = requestInWorkspace(r, "GET", invitationsPath, ownerLogin.AccessToken, "", nil)
+	var invitations WorkspaceInvitationsResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &invitations))
+	assert.Equal(t, 1, len(invitations.Invitations))
+
+	// the invitation is only for its address, once verified
+	resp = requestInWorkspace(r, "POST", "/workspace-invitations/accept", memberLogin.AccessToken, "", TokenBody{Token: token})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, responses.CodeEmailNotVerified, problemOf(t, resp).Code)
+	assert.Nil(t, db.Model(&models.User{}).Where(searchById, stranger.ID).Updates(map[string]interface{}{"email": "Workspace-Member@example.com"}).Error)
+	resp = requestInWorkspace(r, "POST", "/workspace-invitations/accept", strangerLogin.AccessToken, "", TokenBody{Token: token})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Nil(t, db.Model(&models.User{}).Where(searchById, stranger.ID).Updates(map[string]interface{}{"email": stranger.Email, "email_verified": true}).Error)
+	resp = requestInWorkspace(r, "POST", "/workspace-invitations/accept", strangerLogin.AccessToken, "", TokenBody{Token: token})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, responses.CodeForbidden, problemOf(t, resp).Code)
+	assert.Nil(t, db.Model(&models.User{}).Where(searchById, member.ID).Update("email_verified", true).Error)
+
+	resp = requestInWorkspace(r, "POST", "/workspace-invitations/accept", memberLogin.AccessToken, "", TokenBody{Token: token})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var joined WorkspaceResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &joined))
+	assert.Equal(t, workspace.ID, joined.ID)
+	assert.Equal(t, models.WorkspaceRoleViewer, joined.Role)
+
+	resp = requestInWorkspace(r, "POST", "/workspace-invitations/accept", memberLogin.AccessToken, "", TokenBody{Token: token})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = requestInWorkspace(r, "GET", "/workspaces/"+workspace.ID.String()+"/members", memberLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var members WorkspaceMembersResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &members))
+	assert.Equal(t, 2, len(members.Members))
+
+	// the last owner can neither leave nor be demoted
+	ownerPath := "/workspaces/" + workspace.ID.String() + "/members/" + owner.ID.String()
+	resp = requestInWorkspace(r, "PUT", ownerPath, ownerLogin.AccessToken, "", WorkspaceMemberBody{Role: models.WorkspaceRoleEditor})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp = requestInWorkspace(r, "DELETE", ownerPath, ownerLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp = requestInWorkspace(r, "DELETE", ownerPath, memberLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// members can leave
+	resp = requestInWorkspace(r, "DELETE", "/workspaces/"+workspace.ID.String()+"/members/"+member.ID.String(), memberLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestInWorkspace(r, "GET", "/documents", memberLogin.AccessToken, workspace.ID.String(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// personal workspaces cannot be shared
+	resp = requestInWorkspace(r, "POST", "/workspaces/"+workspaces.Workspaces[0].ID.String()+"/invitations", ownerLogin.AccessToken, "", WorkspaceInvitationBody{Email: member.Email})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestWorkspaceDocumentsAndTransfer(t *testing.T) {
+	db := runInitDb()
+	owner := createTestUser(t, db, "Transfer Owner", "transfer-owner@example.com", "password", false)
+	viewer := createTestUser(t, db, "Transfer Viewer", "transfer-viewer@example.com", "password", false)
+	defer deleteTestUser(db, owner)
+	defer deleteTestUser(db, viewer)
+
+	shared := models.Workspace{ID: uuid.New(), Name: "Shared"}
+	assert.Nil(t, db.Create(&shared).Error)
+	defer deleteTestWorkspace(db, shared.ID)
+	assert.Nil(t, db.Create(&models.WorkspaceMember{WorkspaceID: shared.ID, UserID: owner.ID, Role: models.WorkspaceRoleOwner}).Error)
+	assert.Nil(t, db.Create(&models.WorkspaceMember{WorkspaceID: shared.ID, UserID: viewer.ID, Role: models.WorkspaceRoleViewer}).Error)
+
+	personal, err := database.PersonalWorkspace(db, owner.ID)
+	assert.Nil(t, err)
+	document := models.Document{ID: uuid.New(), Title: "Contract", OwnerID: owner.ID.String(), OwnerName: owner.Name, WorkspaceID: personal.ID}
+	assert.Nil(t, db.Create(&document).Error)
+	defer db.Unscoped().Delete(&document)
+
+	r := workspacesTestRouter()
+	ownerLogin := login(t, r, owner.Name, "password")
+	viewerLogin := login(t, r, viewer.Name, "password")
+
+	path := "/documents/" + document.ID.String()
+	resp := requestInWorkspace(r, "GET", path, viewerLogin.AccessToken, shared.ID.String(), nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// viewers cannot add documents to the workspace
+	resp = requestInWorkspace(r, "POST", path+"/transfer", viewerLogin.AccessToken, "", DocumentTransferBody{WorkspaceID: shared.ID})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestInWorkspace(r, "POST", path+"/transfer", ownerLogin.AccessToken, "", DocumentTransferBody{WorkspaceID: uuid.New()})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = requestInWorkspace(r, "POST", path+"/transfer", ownerLogin.AccessToken, "", DocumentTransferBody{WorkspaceID: shared.ID})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var transferred MessageWithDocumentResponse
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &transferred))
+	assert.Equal(t, shared.ID, transferred.Document.WorkspaceID)
+
+	// the document left the personal workspace and is shared with the viewer
+	resp = requestInWorkspace(r, "GET", path, ownerLogin.AccessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = requestInWorkspace(r, "GET", path, viewerLogin.AccessToken, shared.ID.String(), nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// who can only read cannot change it
+	resp = requestInWorkspace(r, "PUT", path, viewerLogin.AccessToken, shared.ID.String(), DocumentRequest{Title: "Changed"})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestInWorkspace(r, "DELETE", path, viewerLogin.AccessToken, shared.ID.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = requestInWorkspace(r, "POST", path+"/transfer", viewerLogin.AccessToken, shared.ID.String(), DocumentTransferBody{WorkspaceID: personal.ID})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = requestInWorkspace(r, "PUT", "/workspaces/"+shared.ID.String()+"/members/"+viewer.ID.String(), ownerLogin.AccessToken, "", WorkspaceMemberBody{Role: models.WorkspaceRoleEditor})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = requestInWorkspace(r, "PUT", path, viewerLogin.AccessToken, shared.ID.String(), DocumentRequest{Title: "Changed"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
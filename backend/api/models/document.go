@@ -8,11 +8,14 @@ import (
 
 type Document struct {
 	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID    uuid.UUID  `gorm:"type:uuid;index" json:"-"`
 	Title       string     `gorm:"not null" json:"title"`
 	Description string     `json:"description"`
 	FilePath    string     `json:"filepath"`
+	FileSize    int64      `json:"file_size" gorm:"not null;default:0"`
 	OwnerID     string     `json:"owner_id" gorm:"not null"`
 	OwnerName   string     `json:"owner_name" gorm:"not null"`
+	WorkspaceID uuid.UUID  `json:"workspace_id" gorm:"type:uuid;index"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	DeletedAt   *time.Time `json:"deleted_at"`
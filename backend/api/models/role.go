@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// permissions granted by roles, on top of what every user can do with its own data
+const (
+	PermissionDocumentsReadAll   = "documents:read_all"
+	PermissionDocumentsWriteAny  = "documents:write_any"
+	PermissionDocumentsDeleteAny = "documents:delete_any"
+	PermissionUsersManage        = "users:manage"
+	PermissionRolesManage        = "roles:manage"
+	PermissionSettingsManage     = "settings:manage"
+	PermissionAuditRead          = "audit:read"
+)
+
+// Permissions lists every permission a role can grant.
+var Permissions = []string{
+	PermissionDocumentsReadAll,
+	PermissionDocumentsWriteAny,
+	PermissionDocumentsDeleteAny,
+	PermissionUsersManage,
+	PermissionRolesManage,
+	PermissionSettingsManage,
+	PermissionAuditRead,
+}
+
+// RoleAdmin is the built-in role with every permission, held by the former master users.
+const RoleAdmin = "admin"
+
+// Role is a named set of permissions. Permissions is a space separated list.
+// Built-in roles cannot be deleted or have their permissions changed.
+type Role struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_roles_tenant_name" json:"-"`
+	Name        string    `gorm:"not null;uniqueIndex:idx_roles_tenant_name" json:"name"`
+	Description string    `json:"description"`
+	Permissions string    `gorm:"not null" json:"permissions"`
+	BuiltIn     bool      `gorm:"not null;default:false" json:"built_in"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UserRole assigns a role to a user.
+type UserRole struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	RoleID    uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"role_id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
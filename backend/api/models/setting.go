@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Setting is a setting of a tenant changed at runtime by its master users.
+type Setting struct {
+	TenantID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	Key       string    `gorm:"primaryKey" json:"key"`
+	Value     string    `gorm:"not null" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// subjects of a storage quota
+const (
+	StorageQuotaUser      = "user"
+	StorageQuotaWorkspace = "workspace"
+)
+
+// StorageQuota replaces the default storage quota of a user or a workspace.
+// Zero means unlimited.
+type StorageQuota struct {
+	TenantID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	Subject   string    `gorm:"primaryKey" json:"subject"`
+	SubjectID uuid.UUID `gorm:"type:uuid;primaryKey" json:"subject_id"`
+	MaxBytes  int64     `gorm:"not null;default:0" json:"max_bytes"`
+	MaxFiles  int64     `gorm:"not null;default:0" json:"max_files"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTenantSlug identifies the tenant of the requests that name no other
+// tenant, and of the data created before tenants existed.
+const DefaultTenantSlug = "default"
+
+// Tenant is an organization hosted by the deployment. Every model with a
+// TenantID belongs to one tenant, and the queries made on behalf of a tenant
+// only see its rows. The files of its documents are stored under StoragePrefix.
+type Tenant struct {
+	ID            uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Slug          string    `gorm:"not null;uniqueIndex" json:"slug"`
+	Name          string    `gorm:"not null" json:"name"`
+	StoragePrefix string    `gorm:"not null;default:''" json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
@@ -6,13 +6,31 @@ import (
 	"github.com/google/uuid"
 )
 
+// states of the account of a user
+const (
+	// UserStatusActive users can log in
+	UserStatusActive = "active"
+	// UserStatusPending users signed up while the registration required an approval, and wait for it
+	UserStatusPending = "pending"
+	// UserStatusSuspended users were suspended by an administrator and cannot log in until reactivated
+	UserStatusSuspended = "suspended"
+)
+
 type User struct {
-	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
-	Name      string     `gorm:"unique;not null" json:"name"`
-	Email     string     `gorm:"unique;not null" json:"email"`
-	Password  string     `gorm:"not null" json:"password"`
-	Master    bool       `gorm:"not null,omitempty" json:"master"`
-	CreatedAt time.Time  `json:"createdAt"`
-	UpdatedAt time.Time  `json:"updatedAt"`
-	DeletedAt *time.Time `json:"deletedAt"`
+	ID            uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID      uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_users_tenant_name;uniqueIndex:idx_users_tenant_email" json:"-"`
+	Name          string    `gorm:"not null;uniqueIndex:idx_users_tenant_name" json:"name"`
+	Email         string    `gorm:"not null;uniqueIndex:idx_users_tenant_email" json:"email"`
+	Password      string    `gorm:"not null" json:"-"`
+	EmailVerified bool      `gorm:"not null;default:false" json:"emailVerified"`
+	Status        string    `gorm:"not null;default:active" json:"status"`
+	TOTPEnabled   bool      `gorm:"column:totp_enabled;not null;default:false" json:"totpEnabled"`
+	TOTPSecret    string    `gorm:"column:totp_secret" json:"-"`
+	TOTPLastStep  int64     `gorm:"column:totp_last_step;not null;default:0" json:"-"`
+	// set when the password was changed, or by administrators to force a change
+	PasswordChangedAt  *time.Time `json:"passwordChangedAt"`
+	MustChangePassword bool       `gorm:"not null;default:false" json:"mustChangePassword"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+	DeletedAt          *time.Time `json:"deletedAt"`
 }
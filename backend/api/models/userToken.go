@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserToken records a single-use token sent to a user by email, such as an
+// email verification or a password reset link.
+type UserToken struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	UserID   uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose  string    `gorm:"not null" json:"purpose"`
+	// address the token was sent to, the only one an email verification verifies
+	Email     string     `gorm:"not null;default:''" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
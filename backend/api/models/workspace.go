@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// roles of a user inside a workspace
+const (
+	// WorkspaceRoleOwner manages the members, the invitations and the documents of the workspace
+	WorkspaceRoleOwner = "owner"
+	// WorkspaceRoleEditor creates, updates and deletes the documents of the workspace
+	WorkspaceRoleEditor = "editor"
+	// WorkspaceRoleViewer reads the documents of the workspace
+	WorkspaceRoleViewer = "viewer"
+)
+
+// WorkspaceRoles lists every role a member of a workspace can have.
+var WorkspaceRoles = []string{WorkspaceRoleOwner, WorkspaceRoleEditor, WorkspaceRoleViewer}
+
+// Workspace is a space of documents shared by its members. Every user has a
+// personal workspace, identified by PersonalUserID, that cannot be shared.
+type Workspace struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID       uuid.UUID  `gorm:"type:uuid;index" json:"-"`
+	Name           string     `gorm:"not null" json:"name"`
+	PersonalUserID *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"personal_user_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// WorkspaceMember gives a user a role in a workspace.
+type WorkspaceMember struct {
+	WorkspaceID uuid.UUID `gorm:"type:uuid;primaryKey" json:"workspace_id"`
+	UserID      uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	Role        string    `gorm:"not null" json:"role"`
+	TenantID    uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WorkspaceInvitation invites an email address to join a workspace with a
+// role. Only the SHA-256 hash of the token sent by email is stored.
+type WorkspaceInvitation struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	TenantID    uuid.UUID  `gorm:"type:uuid;index" json:"-"`
+	WorkspaceID uuid.UUID  `gorm:"type:uuid;not null;index" json:"workspace_id"`
+	Email       string     `gorm:"not null" json:"email"`
+	Role        string     `gorm:"not null" json:"role"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	InvitedByID uuid.UUID  `gorm:"type:uuid;not null" json:"invited_by_id"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"context"
+	"document-manager/api/models"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type transactionContextKey struct{}
+
+// DB returns the transaction of ctx, started by the Transaction of a
+// repository, or db, for the queries of ctx.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(transactionContextKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// transaction calls fn in a transaction of db, or in the transaction ctx is
+// already in.
+func transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(transactionContextKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, transactionContextKey{}, tx))
+	})
+}
+
+// GormDocumentRepository keeps the documents in the documents table.
+type GormDocumentRepository struct {
+	db *gorm.DB
+}
+
+func NewGormDocumentRepository(db *gorm.DB) *GormDocumentRepository {
+	return &GormDocumentRepository{db: db}
+}
+
+func (r *GormDocumentRepository) workspace(ctx context.Context, workspaceID uuid.UUID) *gorm.DB {
+	return DB(ctx, r.db).Where("workspace_id = ?", workspaceID)
+}
+
+func (r *GormDocumentRepository) List(ctx context.Context, workspaceID uuid.UUID, page Page) ([]models.Document, int64, error) {
+	var total int64
+	if err := r.workspace(ctx, workspaceID).Model(&models.Document{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var documents []models.Document
+	err := paginate(r.workspace(ctx, workspaceID), page).Find(&documents).Error
+	return documents, total, err
+}
+
+func (r *GormDocumentRepository) Get(ctx context.Context, workspaceID uuid.UUID, id uuid.UUID) (models.Document, error) {
+	var document models.Document
+	err := r.workspace(ctx, workspaceID).First(&document, "id = ?", id).Error
+	return document, notFound(err)
+}
+
+func (r *GormDocumentRepository) Create(ctx context.Context, document *models.Document) error {
+	return DB(ctx, r.db).Create(document).Error
+}
+
+func (r *GormDocumentRepository) Save(ctx context.Context, document *models.Document) error {
+	return DB(ctx, r.db).Save(document).Error
+}
+
+func (r *GormDocumentRepository) Delete(ctx context.Context, document models.Document) error {
+	return DB(ctx, r.db).Delete(&document).Error
+}
+
+func (r *GormDocumentRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return transaction(ctx, r.db, fn)
+}
+
+// GormUserRepository keeps the users in the users table.
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+func (r *GormUserRepository) List(ctx context.Context, page Page) ([]models.User, error) {
+	var users []models.User
+	err := paginate(DB(ctx, r.db), page).Find(&users).Error
+	return users, err
+}
+
+func (r *GormUserRepository) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	var user models.User
+	err := DB(ctx, r.db).First(&user, "id = ?", id).Error
+	return user, notFound(err)
+}
+
+func (r *GormUserRepository) Save(ctx context.Context, user *models.User) error {
+	return DB(ctx, r.db).Save(user).Error
+}
+
+func (r *GormUserRepository) Delete(ctx context.Context, user models.User) error {
+	return DB(ctx, r.db).Delete(&user).Error
+}
+
+func (r *GormUserRepository) RoleNames(ctx context.Context, id uuid.UUID) ([]string, error) {
+	names := []string{}
+	err := DB(ctx, r.db).Model(&models.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", id).
+		Order("roles.name").
+		Pluck("roles.name", &names).Error
+	return names, err
+}
+
+func paginate(db *gorm.DB, page Page) *gorm.DB {
+	if page.Sort != "" {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: page.Sort}, Desc: page.Desc})
+	}
+	if page.Limit > 0 {
+		db = db.Limit(page.Limit)
+	}
+	return db.Offset(page.Offset)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/database"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// tenant returns the tenant of ctx, the in-memory repositories refusing the
+// queries without one like the database does.
+func tenant(ctx context.Context) (uuid.UUID, error) {
+	tenantID, ok := database.TenantFromContext(ctx)
+	if !ok {
+		return uuid.Nil, database.ErrNoTenant
+	}
+	return tenantID, nil
+}
+
+// page returns the rows of the page of sorted rows.
+func page[T any](rows []T, page Page) []T {
+	if page.Offset >= len(rows) {
+		return []T{}
+	}
+	rows = rows[page.Offset:]
+	if page.Limit > 0 && page.Limit < len(rows) {
+		rows = rows[:page.Limit]
+	}
+	return rows
+}
+
+// MemoryDocumentRepository keeps the documents in memory, for tests.
+type MemoryDocumentRepository struct {
+	mu        sync.Mutex
+	documents map[uuid.UUID]models.Document
+	// held by the transactions, which run one at a time
+	tx sync.Mutex
+}
+
+func NewMemoryDocumentRepository() *MemoryDocumentRepository {
+	return &MemoryDocumentRepository{documents: map[uuid.UUID]models.Document{}}
+}
+
+func (r *MemoryDocumentRepository) List(ctx context.Context, workspaceID uuid.UUID, p Page) ([]models.Document, int64, error) {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	documents := []models.Document{}
+	for _, document := range r.documents {
+		if document.TenantID == tenantID && document.WorkspaceID == workspaceID {
+			documents = append(documents, document)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		a, b := documents[i], documents[j]
+		if p.Desc {
+			a, b = b, a
+		}
+		switch p.Sort {
+		case "title":
+			return a.Title < b.Title
+		case "owner_id":
+			return a.OwnerID < b.OwnerID
+		case "created_at":
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.String() < b.ID.String()
+	})
+	return page(documents, p), int64(len(documents)), nil
+}
+
+func (r *MemoryDocumentRepository) Get(ctx context.Context, workspaceID uuid.UUID, id uuid.UUID) (models.Document, error) {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return models.Document{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	document, ok := r.documents[id]
+	if !ok || document.TenantID != tenantID || document.WorkspaceID != workspaceID {
+		return models.Document{}, ErrNotFound
+	}
+	return document, nil
+}
+
+func (r *MemoryDocumentRepository) Create(ctx context.Context, document *models.Document) error {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	document.TenantID = tenantID
+	document.CreatedAt = time.Now()
+	document.UpdatedAt = document.CreatedAt
+	r.documents[document.ID] = *document
+	return nil
+}
+
+func (r *MemoryDocumentRepository) Save(ctx context.Context, document *models.Document) error {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.documents[document.ID]; ok && existing.TenantID != tenantID {
+		return database.ErrOtherTenant
+	}
+	document.TenantID = tenantID
+	document.UpdatedAt = time.Now()
+	r.documents[document.ID] = *document
+	return nil
+}
+
+func (r *MemoryDocumentRepository) Delete(ctx context.Context, document models.Document) error {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.documents[document.ID]; ok && existing.TenantID == tenantID {
+		delete(r.documents, document.ID)
+	}
+	return nil
+}
+
+// Transaction calls fn once the other transactions ended. Its changes are
+// not rolled back when it fails.
+func (r *MemoryDocumentRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	r.tx.Lock()
+	defer r.tx.Unlock()
+	return fn(ctx)
+}
+
+// MemoryUserRepository keeps the users and the names of their roles in memory, for tests.
+type MemoryUserRepository struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]models.User
+	roles map[uuid.UUID][]string
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[uuid.UUID]models.User{}, roles: map[uuid.UUID][]string{}}
+}
+
+// SetRoles replaces the names of the roles of the user.
+func (r *MemoryUserRepository) SetRoles(id uuid.UUID, names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roles[id] = append([]string{}, names...)
+	sort.Strings(r.roles[id])
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, p Page) ([]models.User, error) {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []models.User{}
+	for _, user := range r.users {
+		if user.TenantID == tenantID {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if p.Desc {
+			a, b = b, a
+		}
+		switch p.Sort {
+		case "name":
+			return a.Name < b.Name
+		case "email":
+			return a.Email < b.Email
+		}
+		return a.ID.String() < b.ID.String()
+	})
+	return page(users, p), nil
+}
+
+func (r *MemoryUserRepository) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.TenantID != tenantID {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// Save creates or updates the user.
+func (r *MemoryUserRepository) Save(ctx context.Context, user *models.User) error {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[user.ID]
+	if ok && existing.TenantID != tenantID {
+		return database.ErrOtherTenant
+	}
+	user.TenantID = tenantID
+	user.UpdatedAt = time.Now()
+	if !ok {
+		user.CreatedAt = user.UpdatedAt
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, user models.User) error {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.users[user.ID]; ok && existing.TenantID == tenantID {
+		delete(r.users, user.ID)
+		delete(r.roles, user.ID)
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) RoleNames(ctx context.Context, id uuid.UUID) ([]string, error) {
+	tenantID, err := tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[id]; !ok || user.TenantID != tenantID {
+		return []string{}, nil
+	}
+	return append([]string{}, r.roles[id]...), nil
+}
@@ -0,0 +1,50 @@
+// Package repositories loads and stores the documents and the users. The
+// handlers and the services reach the database through its interfaces only,
+// so they can be tested on the in-memory repositories.
+//
+// Every method scopes its queries to the tenant of its context, set with
+// database.WithTenant.
+package repositories
+
+import (
+	"context"
+	"document-manager/api/models"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when the document or the user does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// Page selects a page of a list sorted by a column. A zero Limit lists everything.
+type Page struct {
+	Offset int
+	Limit  int
+	Sort   string
+	Desc   bool
+}
+
+// DocumentRepository stores the documents of the workspaces.
+type DocumentRepository interface {
+	// List returns a page of the documents of the workspace, and how many it has.
+	List(ctx context.Context, workspaceID uuid.UUID, page Page) ([]models.Document, int64, error)
+	// Get returns the document of the workspace with the ID.
+	Get(ctx context.Context, workspaceID uuid.UUID, id uuid.UUID) (models.Document, error)
+	Create(ctx context.Context, document *models.Document) error
+	Save(ctx context.Context, document *models.Document) error
+	Delete(ctx context.Context, document models.Document) error
+	// Transaction calls fn with a context whose queries run in a transaction,
+	// committed when fn returns nil.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// UserRepository stores the users.
+type UserRepository interface {
+	List(ctx context.Context, page Page) ([]models.User, error)
+	Get(ctx context.Context, id uuid.UUID) (models.User, error)
+	Save(ctx context.Context, user *models.User) error
+	Delete(ctx context.Context, user models.User) error
+	// RoleNames returns the names of the roles of the user, sorted.
+	RoleNames(ctx context.Context, id uuid.UUID) ([]string, error)
+}
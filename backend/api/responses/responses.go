@@ -1,14 +1,122 @@
+// Package responses holds the error model of the API: every error is
+// answered as an RFC 7807 problem, application/problem+json, carrying a
+// stable machine-readable code from which its HTTP status follows.
 package responses
 
-type ErrorResponse struct {
-	ErrorMessage string `json:"error"`
+import "net/http"
+
+// ContentType is the media type of the problems.
+const ContentType = "application/problem+json"
+
+// Code identifies an error for the clients. The codes never change meaning,
+// the details that come with them may be reworded.
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeInvalidTwoFactor Code = "invalid_two_factor_code"
+	CodeInvalidLink      Code = "invalid_link"
+	CodePasswordPolicy   Code = "password_policy_violation"
+
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeInvalidToken       Code = "invalid_token"
+
+	CodeForbidden          Code = "forbidden"
+	CodeMissingPermission  Code = "missing_permission"
+	CodeAccountPending     Code = "account_pending"
+	CodeAccountSuspended   Code = "account_suspended"
+	CodeTwoFactorRequired  Code = "two_factor_required"
+	CodeInvitationRequired Code = "invitation_required"
+	CodeEmailNotVerified   Code = "email_not_verified"
+
+	CodeNotFound Code = "not_found"
+
+	CodeConflict      Code = "conflict"
+	CodeAlreadyExists Code = "already_exists"
+	CodeLastAdmin     Code = "last_admin"
+
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeRateLimited          Code = "rate_limited"
+	CodeStorageQuotaExceeded Code = "storage_quota_exceeded"
+
+	CodeInternal Code = "internal_error"
+)
+
+var statuses = map[Code]int{
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeValidationFailed: http.StatusBadRequest,
+	CodeInvalidTwoFactor: http.StatusBadRequest,
+	CodeInvalidLink:      http.StatusBadRequest,
+	CodePasswordPolicy:   http.StatusBadRequest,
+
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+
+	CodeForbidden:          http.StatusForbidden,
+	CodeMissingPermission:  http.StatusForbidden,
+	CodeAccountPending:     http.StatusForbidden,
+	CodeAccountSuspended:   http.StatusForbidden,
+	CodeTwoFactorRequired:  http.StatusForbidden,
+	CodeInvitationRequired: http.StatusForbidden,
+	CodeEmailNotVerified:   http.StatusForbidden,
+
+	CodeNotFound: http.StatusNotFound,
+
+	CodeConflict:      http.StatusConflict,
+	CodeAlreadyExists: http.StatusConflict,
+	CodeLastAdmin:     http.StatusConflict,
+
+	CodePayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeRateLimited:          http.StatusTooManyRequests,
+	CodeStorageQuotaExceeded: http.StatusInsufficientStorage,
+
+	CodeInternal: http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status of the errors of code, 500 for unknown
+// codes.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Problem is an error answered by the API, as described by RFC 7807. Type is
+// always about:blank, so Title is the text of Status and Code tells the
+// errors apart.
+type Problem struct {
+	Type   string `json:"type" example:"about:blank"`
+	Title  string `json:"title" example:"Not Found"`
+	Status int    `json:"status" example:"404"`
+	// what went wrong, for the users
+	Detail string `json:"detail,omitempty" example:"Document not found"`
+	// path of the request
+	Instance string `json:"instance,omitempty" example:"/api/documents/0b7c6b6e-4f7a-4d4a-9a53-7b0d5c4e8f10"`
+	Code     Code   `json:"code" swaggertype:"string" example:"not_found"`
+	// ID of the request, to be found in the logs of the server
+	RequestID string `json:"request_id,omitempty"`
+	// invalid fields of the request, when Code is validation_failed or password_policy_violation
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
-type ErrorResponseWithDetails struct {
-	ErrorMessage string `json:"error"`
-	Details      string `json:"details"`
+// FieldError is why a field of the request is invalid.
+type FieldError struct {
+	Field   string `json:"field" example:"title"`
+	Message string `json:"message" example:"is required"`
 }
 
-type MessageResponse struct {
-	Message string `json:"message"`
+// New returns the problem of code, with the status that follows from it.
+func New(code Code, detail string) Problem {
+	status := code.Status()
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
 }
@@ -0,0 +1,19 @@
+package responses
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	assert.Equal(t, http.StatusUnauthorized, CodeInvalidCredentials.Status())
+	assert.Equal(t, http.StatusInsufficientStorage, CodeStorageQuotaExceeded.Status())
+	assert.Equal(t, http.StatusInternalServerError, Code("unknown").Status())
+
+	problem := New(CodeLastAdmin, "The last user with the admin role cannot lose it")
+	assert.Equal(t, "about:blank", problem.Type)
+	assert.Equal(t, http.StatusConflict, problem.Status)
+	assert.Equal(t, "Conflict", problem.Title)
+}
@@ -2,7 +2,16 @@ package api
 
 import (
 	"document-manager/api/handlers"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"document-manager/api/services"
 	"document-manager/api/utils"
+	"document-manager/config"
+	"document-manager/database"
+	"document-manager/metrics"
+	"document-manager/tracing"
+	"io"
+	"log/slog"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -12,48 +21,192 @@ import (
 
 // SetupRouter é a função pública que cria o roteador Gin e configura as rotas
 func SetupRouter() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	// every request is identified, logged and traced, the panics answered with a 500
+	r.Use(handlers.RequestIDMiddleware, handlers.RequestLogger, tracing.Middleware, gin.CustomRecoveryWithWriter(io.Discard, handlers.RecoverPanic))
+	// probed without tenant, and left out of the metrics of the API
+	r.GET("/healthz", handlers.HealthzHandler)
+	r.GET("/readyz", handlers.ReadyzHandler)
+	if cfg := config.Get().Metrics; cfg.Enabled {
+		r.Use(metrics.Middleware)
+		// scraped without tenant, unless served by a listener of their own
+		if cfg.Address == "" {
+			r.GET("/metrics", gin.WrapH(metrics.Handler(cfg.Token)))
+		}
+	}
+	// the rate limits count the requests of the client IP, which only trusted proxies may forward
+	if err := r.SetTrustedProxies(config.Get().Server.TrustedProxies); err != nil {
+		slog.Warn("Invalid trusted proxies", "error", err)
+	}
 	//cors
-	r.Use(cors.New(utils.CORSConfig()))
+	r.Use(cors.New(utils.CORSConfig(config.Get().CORS.AllowedOrigins)))
+	// every query of the handlers is scoped to the tenant of the request
+	r.Use(handlers.TenantMiddleware)
+
+	db := database.GetDB()
+	h := handlers.New(
+		services.NewDocumentService(repositories.NewGormDocumentRepository(db), handlers.NewStorageQuotas(db)),
+		services.NewUserService(repositories.NewGormUserRepository(db), handlers.NewSessionRevoker(db)),
+	)
 
 	r.GET("/api/", handlers.HelloHandler)
+	r.GET("/api/tenant", handlers.GetTenantHandler)
 
-	r.POST("/api/refresh-token", handlers.RefreshTokenHandler)
+	r.POST("/api/refresh-token", handlers.RateLimit("refresh-token"), handlers.RefreshTokenHandler)
+	r.POST("/api/logout", handlers.AuthMiddleware, handlers.LogoutHandler)
+	r.POST("/api/logout-all", handlers.AuthMiddleware, handlers.LogoutAllHandler)
+
+	sessionsProtected := r.Group("/api/sessions")
+	sessionsProtected.Use(handlers.AuthMiddleware)
+	{
+		sessionsProtected.GET("/", handlers.GetSessionsHandler)
+		sessionsProtected.DELETE("/:sessionId", handlers.RevokeSessionHandler)
+	}
 
 	usersProtected := r.Group("/api/users")
 	usersProtected.Use(handlers.AuthMiddleware)
 	{
-		usersProtected.GET("/", handlers.GetAllUsersHandler)
-		usersProtected.GET("/:id", handlers.GetUserByIDHandler)
-		usersProtected.PUT("/:id", handlers.UpdateUserHandler)
-		usersProtected.DELETE("/:id", handlers.DeleteUserHandler)
+		usersProtected.GET("/", h.GetAllUsersHandler)
+		usersProtected.GET("/:id", h.GetUserByIDHandler)
+		usersProtected.PUT("/:id", h.UpdateUserHandler)
+		usersProtected.DELETE("/:id", h.DeleteUserHandler)
+	}
+	r.POST("/api/users", handlers.RateLimit("signup"), handlers.CreateUserHandler)
+	r.GET("/api/registration", handlers.GetRegistrationHandler)
+
+	// invites to sign up
+	invitesMasterProtect := r.Group("/api/invites")
+	invitesMasterProtect.Use(handlers.APIKeyScopes(handlers.ScopeAdmin, handlers.ScopeAdmin), handlers.AuthMiddleware, handlers.RequirePermission(models.PermissionUsersManage))
+	{
+		invitesMasterProtect.GET("/", handlers.GetInvitesHandler)
+		invitesMasterProtect.POST("/", handlers.CreateInviteHandler)
+		invitesMasterProtect.DELETE("/:inviteId", handlers.RevokeInviteHandler)
 	}
-	r.POST("/api/users", handlers.CreateUserHandler)
 
 	//master
 	usersMasterProtect := r.Group("/api/usersMaster")
-	usersMasterProtect.Use(handlers.AuthMiddlewareMaster)
+	usersMasterProtect.Use(handlers.APIKeyScopes(handlers.ScopeAdmin, handlers.ScopeAdmin), handlers.AuthMiddleware, handlers.RequirePermission(models.PermissionUsersManage))
 	{
-		r.POST("/", handlers.CreateUserMasterHandler)
-		r.DELETE("/:id", handlers.DeleteUserMasterHandler)
+		usersMasterProtect.POST("/", handlers.CreateUserMasterHandler)
+		usersMasterProtect.DELETE("/:id", handlers.DeleteUserMasterHandler)
+		usersMasterProtect.GET("/:id/stats", handlers.GetUserStatsMasterHandler)
+		// the admin role grants every permission, so only who manages roles gives it
+		usersMasterProtect.POST("/:id/promote", handlers.RequirePermission(models.PermissionRolesManage), handlers.PromoteUserMasterHandler)
+		usersMasterProtect.POST("/:id/demote", handlers.RequirePermission(models.PermissionRolesManage), handlers.DemoteUserMasterHandler)
+		usersMasterProtect.POST("/:id/suspend", handlers.SuspendUserMasterHandler)
+		usersMasterProtect.POST("/:id/unsuspend", handlers.UnsuspendUserMasterHandler)
+		usersMasterProtect.POST("/:id/password-reset", handlers.ForcePasswordResetMasterHandler)
+		usersMasterProtect.POST("/:id/documents/reassign", handlers.ReassignDocumentsMasterHandler)
+		usersMasterProtect.GET("/pending", handlers.GetPendingUsersHandler)
+		usersMasterProtect.POST("/:id/approve", handlers.ApproveUserMasterHandler)
+		usersMasterProtect.POST("/:id/reject", handlers.RejectUserMasterHandler)
+		usersMasterProtect.POST("/:id/password-change", handlers.RequirePasswordChangeMasterHandler)
+		usersMasterProtect.GET("/:id/sessions", handlers.GetUserSessionsMasterHandler)
+		usersMasterProtect.DELETE("/:id/sessions/:sessionId", handlers.RevokeUserSessionMasterHandler)
+		usersMasterProtect.GET("/:id/api-keys", handlers.GetUserAPIKeysMasterHandler)
+		usersMasterProtect.DELETE("/:id/api-keys/:keyId", handlers.RevokeUserAPIKeyMasterHandler)
 	}
-	r.POST("/api/login", handlers.LoginHandler)
+	r.POST("/api/login", handlers.RateLimit("login"), handlers.LoginHandler)
+	r.POST("/api/login/2fa", handlers.RateLimit("login"), handlers.LoginTwoFactorHandler)
+	r.POST("/api/login/password", handlers.RateLimit("login"), handlers.ChangeRequiredPasswordHandler)
+	r.GET("/api/sso/login", handlers.SSOLoginHandler)
+	r.GET("/api/sso/callback", handlers.SSOCallbackHandler)
+
+	// two-factor authentication
+	twoFactorProtected := r.Group("/api/2fa")
+	twoFactorProtected.Use(handlers.AuthMiddleware)
+	{
+		twoFactorProtected.POST("/enroll", handlers.EnrollTwoFactorHandler)
+		twoFactorProtected.POST("/confirm", handlers.ConfirmTwoFactorHandler)
+		twoFactorProtected.POST("/disable", handlers.DisableTwoFactorHandler)
+		twoFactorProtected.POST("/recovery-codes", handlers.RegenerateRecoveryCodesHandler)
+	}
+
+	// personal API keys, managed with access tokens only
+	apiKeysProtected := r.Group("/api/api-keys")
+	apiKeysProtected.Use(handlers.AuthMiddleware)
+	{
+		apiKeysProtected.POST("/", handlers.CreateAPIKeyHandler)
+		apiKeysProtected.GET("/", handlers.GetAPIKeysHandler)
+		apiKeysProtected.DELETE("/:keyId", handlers.RevokeAPIKeyHandler)
+	}
+
+	// roles
+	rolesProtected := r.Group("/api/roles")
+	rolesProtected.Use(handlers.APIKeyScopes(handlers.ScopeAdmin, handlers.ScopeAdmin), handlers.AuthMiddleware, handlers.RequirePermission(models.PermissionRolesManage))
+	{
+		rolesProtected.GET("/", handlers.GetRolesHandler)
+		rolesProtected.POST("/", handlers.CreateRoleHandler)
+		rolesProtected.PUT("/:roleId", handlers.UpdateRoleHandler)
+		rolesProtected.DELETE("/:roleId", handlers.DeleteRoleHandler)
+		rolesProtected.GET("/:roleId/users", handlers.GetRoleUsersHandler)
+		rolesProtected.PUT("/:roleId/users/:userId", handlers.AssignRoleHandler)
+		rolesProtected.DELETE("/:roleId/users/:userId", handlers.UnassignRoleHandler)
+	}
+
+	// settings
+	settingsMasterProtect := r.Group("/api/settings")
+	settingsMasterProtect.Use(handlers.APIKeyScopes(handlers.ScopeAdmin, handlers.ScopeAdmin), handlers.AuthMiddleware, handlers.RequirePermission(models.PermissionSettingsManage))
+	{
+		settingsMasterProtect.GET("/config", handlers.GetConfigHandler)
+		settingsMasterProtect.GET("/security", handlers.GetSecuritySettingsHandler)
+		settingsMasterProtect.PUT("/security", handlers.UpdateSecuritySettingsHandler)
+		settingsMasterProtect.GET("/password-policy", handlers.GetPasswordPolicyHandler)
+		settingsMasterProtect.PUT("/password-policy", handlers.UpdatePasswordPolicyHandler)
+		settingsMasterProtect.GET("/registration", handlers.GetRegistrationSettingsHandler)
+		settingsMasterProtect.PUT("/registration", handlers.UpdateRegistrationSettingsHandler)
+		settingsMasterProtect.GET("/storage", handlers.GetStorageSettingsHandler)
+		settingsMasterProtect.PUT("/storage", handlers.UpdateStorageSettingsHandler)
+		settingsMasterProtect.PUT("/storage/users/:userId", handlers.SetUserStorageQuotaHandler)
+		settingsMasterProtect.DELETE("/storage/users/:userId", handlers.DeleteUserStorageQuotaHandler)
+		settingsMasterProtect.PUT("/storage/workspaces/:workspaceId", handlers.SetWorkspaceStorageQuotaHandler)
+		settingsMasterProtect.DELETE("/storage/workspaces/:workspaceId", handlers.DeleteWorkspaceStorageQuotaHandler)
+	}
+
+	// email verification and password reset
+	r.POST("/api/verify-email/request", handlers.AuthMiddleware, handlers.RequestEmailVerificationHandler)
+	r.POST("/api/verify-email/confirm", handlers.ConfirmEmailVerificationHandler)
+	r.POST("/api/password-reset/request", handlers.RateLimit("password-reset"), handlers.RequestPasswordResetHandler)
+	r.POST("/api/password-reset/confirm", handlers.RateLimit("password-reset"), handlers.ConfirmPasswordResetHandler)
+
+	// workspaces
+	workspacesProtected := r.Group("/api/workspaces")
+	workspacesProtected.Use(handlers.AuthMiddleware)
+	{
+		workspacesProtected.GET("/", handlers.GetWorkspacesHandler)
+		workspacesProtected.POST("/", handlers.CreateWorkspaceHandler)
+		workspacesProtected.PUT("/:workspaceId", handlers.UpdateWorkspaceHandler)
+		workspacesProtected.GET("/:workspaceId/members", handlers.GetWorkspaceMembersHandler)
+		workspacesProtected.PUT("/:workspaceId/members/:userId", handlers.UpdateWorkspaceMemberHandler)
+		workspacesProtected.DELETE("/:workspaceId/members/:userId", handlers.RemoveWorkspaceMemberHandler)
+		workspacesProtected.POST("/:workspaceId/invitations", handlers.CreateWorkspaceInvitationHandler)
+		workspacesProtected.GET("/:workspaceId/invitations", handlers.GetWorkspaceInvitationsHandler)
+		workspacesProtected.DELETE("/:workspaceId/invitations/:invitationId", handlers.RevokeWorkspaceInvitationHandler)
+	}
+	r.POST("/api/workspace-invitations/accept", handlers.AuthMiddleware, handlers.AcceptWorkspaceInvitationHandler)
+
+	// storage usage of the logged user and the current workspace
+	r.GET("/api/storage/usage", handlers.APIKeyScopes(handlers.ScopeDocumentsRead, handlers.ScopeDocumentsWrite), handlers.AuthMiddleware, handlers.WorkspaceMiddleware, handlers.GetStorageUsageHandler)
 
 	// documents
 	documentsProtected := r.Group("/api/documents")
-	documentsProtected.Use(handlers.AuthMiddleware)
+	documentsProtected.Use(handlers.APIKeyScopes(handlers.ScopeDocumentsRead, handlers.ScopeDocumentsWrite), handlers.AuthMiddleware, handlers.WorkspaceMiddleware)
 	{
-		documentsProtected.GET("/", handlers.GetAllDocumentsHandler)
-		documentsProtected.GET("/:id", handlers.GetDocumentByIDHandler)
-		documentsProtected.PUT("/:id", handlers.UpdateDocumentWithoutFileHandler)
-		documentsProtected.DELETE("/:id", handlers.DeleteDocumentHandler)
-		documentsProtected.GET("/file/:id", handlers.GetDocumentFileByIDHandler)
-		documentsProtected.POST("/upload", handlers.CreateDocumentHandler)
-		documentsProtected.PUT("/upload/:id", handlers.UpdateDocumentHandler)
+		documentsProtected.GET("/", h.GetAllDocumentsHandler)
+		documentsProtected.GET("/:id", h.GetDocumentByIDHandler)
+		documentsProtected.PUT("/:id", h.UpdateDocumentWithoutFileHandler)
+		documentsProtected.DELETE("/:id", h.DeleteDocumentHandler)
+		documentsProtected.GET("/file/:id", h.GetDocumentFileByIDHandler)
+		documentsProtected.POST("/upload", h.CreateDocumentHandler)
+		documentsProtected.PUT("/upload/:id", h.UpdateDocumentHandler)
+		documentsProtected.POST("/:id/transfer", h.TransferDocumentHandler)
 	}
 
 	//swagger
 	r.GET("/api/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 
+	// the unknown paths are answered with a problem too
+	r.NoRoute(handlers.NoRouteHandler)
+
 	return r
 }
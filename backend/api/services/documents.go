@@ -0,0 +1,335 @@
+// Package services holds the rules of the documents and the users: what may
+// be listed, changed and stored. They load and save through the repositories,
+// and leave the HTTP requests and the permissions to the handlers.
+package services
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"document-manager/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/google/uuid"
+)
+
+// StorageUsage is what a user or a workspace stores, and its quota. Zero
+// means unlimited.
+type StorageUsage struct {
+	UsedBytes int64 `json:"used_bytes"`
+	Files     int64 `json:"files"`
+	MaxBytes  int64 `json:"max_bytes"`
+	MaxFiles  int64 `json:"max_files"`
+}
+
+// Quotas are the storage quotas of the users and the workspaces.
+type Quotas interface {
+	// MaxFileBytes returns the maximum size of a file, zero when unlimited.
+	MaxFileBytes(ctx context.Context) (int64, error)
+	// Usage returns what the user or the workspace identified by subjectID
+	// stores, with its quota. subject is models.StorageQuotaUser or
+	// models.StorageQuotaWorkspace. In a transaction of the documents, the
+	// user or the workspace is locked until it ends.
+	Usage(ctx context.Context, subject string, subjectID string) (StorageUsage, error)
+}
+
+// QuotaExceededError is returned when a document does not fit in the quota
+// of its owner or of its workspace.
+type QuotaExceededError struct {
+	Subject string
+	Usage   StorageUsage
+}
+
+func (e *QuotaExceededError) Error() string {
+	return "storage quota of the " + e.Subject + " exceeded"
+}
+
+// Upload is the file of a document being uploaded.
+type Upload struct {
+	File io.Reader
+	Size int64
+}
+
+// DocumentChanges are the fields of a document to change, the empty ones are kept.
+type DocumentChanges struct {
+	Title       string
+	Description string
+	OwnerID     string
+	OwnerName   string
+}
+
+// DocumentList is a page of the documents of a workspace.
+type DocumentList struct {
+	Documents      []models.Document
+	TotalDocuments int64
+	TotalPages     int64
+}
+
+// documentSortColumns are the columns the documents may be sorted by.
+var documentSortColumns = map[string]string{
+	"id":         "id",
+	"title":      "title",
+	"owner":      "owner_id",
+	"created_at": "created_at",
+}
+
+type DocumentService struct {
+	documents repositories.DocumentRepository
+	quotas    Quotas
+}
+
+func NewDocumentService(documents repositories.DocumentRepository, quotas Quotas) *DocumentService {
+	return &DocumentService{documents: documents, quotas: quotas}
+}
+
+// MaxFileBytes returns the maximum size of the file of a document, zero when unlimited.
+func (s *DocumentService) MaxFileBytes(ctx context.Context) (int64, error) {
+	return s.quotas.MaxFileBytes(ctx)
+}
+
+// List returns the page of limit documents of the workspace, counted from 1,
+// sorted by id, title, owner or created_at, asc or desc. Unknown sorts fall
+// back to the id, ascending.
+func (s *DocumentService) List(ctx context.Context, workspaceID uuid.UUID, page int, limit int, sort string, dir string) (DocumentList, error) {
+	column, ok := documentSortColumns[sort]
+	if !ok {
+		column = "id"
+	}
+	documents, total, err := s.documents.List(ctx, workspaceID, repositories.Page{
+		Offset: (page - 1) * limit,
+		Limit:  limit,
+		Sort:   column,
+		Desc:   dir == "desc",
+	})
+	if err != nil {
+		return DocumentList{}, err
+	}
+	return DocumentList{
+		Documents:      documents,
+		TotalDocuments: total,
+		TotalPages:     (total + int64(limit) - 1) / int64(limit),
+	}, nil
+}
+
+// Get returns the document of the workspace, or repositories.ErrNotFound.
+func (s *DocumentService) Get(ctx context.Context, workspaceID uuid.UUID, id uuid.UUID) (models.Document, error) {
+	return s.documents.Get(ctx, workspaceID, id)
+}
+
+// Create stores the upload at the FilePath of the new document and creates
+// it, when it fits in the quotas of its owner and its workspace. The quotas
+// are checked in the transaction creating the document, so that concurrent
+// uploads cannot all fit.
+func (s *DocumentService) Create(ctx context.Context, document *models.Document, upload Upload) error {
+	if err := os.MkdirAll(path.Dir(document.FilePath), 0o750); err != nil {
+		return fmt.Errorf("saving the file: %w", err)
+	}
+	if err := writeFile(ctx, document.FilePath, upload.File); err != nil {
+		return err
+	}
+	document.FileSize = upload.Size
+	err := s.documents.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.checkQuota(ctx, models.StorageQuotaUser, document.OwnerID, upload.Size, 1); err != nil {
+			return err
+		}
+		if err := s.checkQuota(ctx, models.StorageQuotaWorkspace, document.WorkspaceID.String(), upload.Size, 1); err != nil {
+			return err
+		}
+		return s.documents.Create(ctx, document)
+	})
+	if err != nil {
+		removeFile(ctx, document.FilePath)
+		return err
+	}
+	return nil
+}
+
+// Update applies the changes to the document, and replaces its file with
+// upload unless nil. A new owner must have room for the whole file, the
+// current owner and the workspace only for what it grows.
+func (s *DocumentService) Update(ctx context.Context, document models.Document, changes DocumentChanges, upload *Upload) (models.Document, error) {
+	previousOwnerID := document.OwnerID
+	if changes.Title != "" {
+		document.Title = changes.Title
+	}
+	if changes.Description != "" {
+		document.Description = changes.Description
+	}
+	if changes.OwnerID != "" {
+		document.OwnerID = changes.OwnerID
+	}
+	if changes.OwnerName != "" {
+		document.OwnerName = changes.OwnerName
+	}
+	ownerChanged := document.OwnerID != previousOwnerID
+
+	// the row describes the new file only once it replaced the former one
+	var replacement *fileReplacement
+	if upload != nil {
+		var err error
+		if replacement, err = replaceFile(ctx, document.FilePath, upload.File); err != nil {
+			return document, err
+		}
+	}
+	err := s.documents.Transaction(ctx, func(ctx context.Context) error {
+		if upload == nil {
+			if ownerChanged {
+				if err := s.checkQuota(ctx, models.StorageQuotaUser, document.OwnerID, document.FileSize, 1); err != nil {
+					return err
+				}
+			}
+		} else {
+			growth := upload.Size - document.FileSize
+			var err error
+			if ownerChanged {
+				err = s.checkQuota(ctx, models.StorageQuotaUser, document.OwnerID, upload.Size, 1)
+			} else {
+				err = s.checkQuota(ctx, models.StorageQuotaUser, document.OwnerID, growth, 0)
+			}
+			if err == nil {
+				err = s.checkQuota(ctx, models.StorageQuotaWorkspace, document.WorkspaceID.String(), growth, 0)
+			}
+			if err != nil {
+				return err
+			}
+			document.FileSize = upload.Size
+		}
+		return s.documents.Save(ctx, &document)
+	})
+	if err != nil {
+		if replacement != nil {
+			replacement.undo()
+		}
+		return document, err
+	}
+	if replacement != nil {
+		replacement.keep()
+	}
+	return document, nil
+}
+
+// Delete deletes the file of the document, then the document.
+func (s *DocumentService) Delete(ctx context.Context, document models.Document) error {
+	if err := removeFile(ctx, document.FilePath); err != nil {
+		return fmt.Errorf("deleting the file: %w", err)
+	}
+	return s.documents.Delete(ctx, document)
+}
+
+// Transfer moves the document to the workspace, when it fits in its quota.
+// Whether the user may add documents to the workspace is up to the caller.
+func (s *DocumentService) Transfer(ctx context.Context, document models.Document, workspaceID uuid.UUID) (models.Document, error) {
+	if workspaceID == document.WorkspaceID {
+		return document, nil
+	}
+	err := s.documents.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.checkQuota(ctx, models.StorageQuotaWorkspace, workspaceID.String(), document.FileSize, 1); err != nil {
+			return err
+		}
+		document.WorkspaceID = workspaceID
+		return s.documents.Save(ctx, &document)
+	})
+	return document, err
+}
+
+// checkQuota returns a *QuotaExceededError when the user or the workspace
+// cannot store bytes and files more. Growing by nothing is always allowed,
+// so documents can shrink over the quota.
+func (s *DocumentService) checkQuota(ctx context.Context, subject string, subjectID string, bytes int64, files int64) error {
+	if bytes <= 0 && files <= 0 {
+		return nil
+	}
+	usage, err := s.quotas.Usage(ctx, subject, subjectID)
+	if err != nil {
+		return fmt.Errorf("retrieving the storage usage: %w", err)
+	}
+	if (bytes > 0 && usage.MaxBytes > 0 && usage.UsedBytes+bytes > usage.MaxBytes) ||
+		(files > 0 && usage.MaxFiles > 0 && usage.Files+files > usage.MaxFiles) {
+		return &QuotaExceededError{Subject: subject, Usage: usage}
+	}
+	return nil
+}
+
+func writeFile(ctx context.Context, filePath string, content io.Reader) (err error) {
+	_, span := tracing.StartStorage(ctx, "write", filePath)
+	defer func() { tracing.End(span, err) }()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("saving the file: %w", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, content); err != nil {
+		return fmt.Errorf("saving the file: %w", err)
+	}
+	return file.Close()
+}
+
+// fileReplacement is a file replaced by replaceFile. The former file is kept
+// until the replacement is kept or undone.
+type fileReplacement struct {
+	filePath string
+	// hard link to the former file, empty when there was none
+	previous string
+}
+
+// replaceFile writes content to a temporary file next to filePath, then renames
+// it over filePath: the file is never seen half written.
+func replaceFile(ctx context.Context, filePath string, content io.Reader) (replacement *fileReplacement, err error) {
+	_, span := tracing.StartStorage(ctx, "replace", filePath)
+	defer func() { tracing.End(span, err) }()
+
+	file, err := os.CreateTemp(path.Dir(filePath), path.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("saving the file: %w", err)
+	}
+	// once renamed, there is nothing left to remove
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := io.Copy(file, content); err != nil {
+		return nil, fmt.Errorf("saving the file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("saving the file: %w", err)
+	}
+
+	replacement = &fileReplacement{filePath: filePath, previous: file.Name() + ".previous"}
+	if err := os.Link(filePath, replacement.previous); errors.Is(err, fs.ErrNotExist) {
+		replacement.previous = ""
+	} else if err != nil {
+		return nil, fmt.Errorf("saving the file: %w", err)
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		replacement.keep()
+		return nil, fmt.Errorf("saving the file: %w", err)
+	}
+	return replacement, nil
+}
+
+// undo puts the former file back.
+func (r *fileReplacement) undo() error {
+	if r.previous == "" {
+		return os.Remove(r.filePath)
+	}
+	return os.Rename(r.previous, r.filePath)
+}
+
+// keep forgets the former file.
+func (r *fileReplacement) keep() error {
+	if r.previous == "" {
+		return nil
+	}
+	return os.Remove(r.previous)
+}
+
+func removeFile(ctx context.Context, filePath string) (err error) {
+	_, span := tracing.StartStorage(ctx, "remove", filePath)
+	defer func() { tracing.End(span, err) }()
+
+	return os.Remove(filePath)
+}
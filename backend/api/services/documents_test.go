@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"document-manager/database"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// subjectQuotas gives every user and workspace its own usage, unlimited
+// unless set.
+type subjectQuotas map[string]StorageUsage
+
+func (q subjectQuotas) MaxFileBytes(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (q subjectQuotas) Usage(ctx context.Context, subject string, subjectID string) (StorageUsage, error) {
+	return q[subject+":"+subjectID], nil
+}
+
+func testContext() context.Context {
+	return database.WithTenant(context.Background(), uuid.New())
+}
+
+func TestDocumentQuotas(t *testing.T) {
+	ctx := testContext()
+	owner, successor, workspaceID := uuid.NewString(), uuid.NewString(), uuid.New()
+	quotas := subjectQuotas{
+		"user:" + owner:     {UsedBytes: 100, MaxBytes: 150},
+		"user:" + successor: {UsedBytes: 0, MaxBytes: 120},
+	}
+	documents := repositories.NewMemoryDocumentRepository()
+	service := NewDocumentService(documents, quotas)
+
+	document := models.Document{
+		ID:          uuid.New(),
+		Title:       "Quota",
+		OwnerID:     owner,
+		WorkspaceID: workspaceID,
+		FilePath:    path.Join(t.TempDir(), "tenant", "document.pdf"),
+	}
+	err := service.Create(ctx, &document, Upload{File: strings.NewReader(strings.Repeat("x", 60)), Size: 60})
+	var exceeded *QuotaExceededError
+	assert.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, models.StorageQuotaUser, exceeded.Subject)
+	assert.NoFileExists(t, document.FilePath)
+
+	assert.Nil(t, service.Create(ctx, &document, Upload{File: strings.NewReader(strings.Repeat("x", 40)), Size: 40}))
+	content, err := os.ReadFile(document.FilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, len(content))
+
+	// the owner only needs room for what the file grows, a new owner for all of it
+	quotas["user:"+owner] = StorageUsage{UsedBytes: 140, MaxBytes: 150}
+	_, err = service.Update(ctx, document, DocumentChanges{}, &Upload{File: strings.NewReader(strings.Repeat("y", 50)), Size: 50})
+	assert.Nil(t, err)
+	document, err = service.Get(ctx, workspaceID, document.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), document.FileSize)
+	content, err = os.ReadFile(document.FilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("y", 50), string(content))
+
+	_, err = service.Update(ctx, document, DocumentChanges{OwnerID: successor}, &Upload{File: strings.NewReader(strings.Repeat("z", 130)), Size: 130})
+	assert.ErrorAs(t, err, &exceeded)
+	_, err = service.Update(ctx, document, DocumentChanges{OwnerID: successor}, nil)
+	assert.Nil(t, err)
+
+	// shrinking is allowed over the quota
+	quotas["workspace:"+workspaceID.String()] = StorageUsage{UsedBytes: 500, MaxBytes: 100}
+	_, err = service.Update(ctx, document, DocumentChanges{}, &Upload{File: strings.NewReader("small"), Size: 5})
+	assert.Nil(t, err)
+
+	target := uuid.New()
+	quotas["workspace:"+target.String()] = StorageUsage{Files: 1, MaxFiles: 1}
+	_, err = service.Transfer(ctx, document, target)
+	assert.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, models.StorageQuotaWorkspace, exceeded.Subject)
+
+	assert.Nil(t, service.Delete(ctx, document))
+	assert.NoFileExists(t, document.FilePath)
+	_, err = service.Get(ctx, workspaceID, document.ID)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
+
+// countedQuotas counts the documents of the workspace of the memory
+// repository, allowing each user one file.
+type countedQuotas struct {
+	documents   *repositories.MemoryDocumentRepository
+	workspaceID uuid.UUID
+}
+
+func (q countedQuotas) MaxFileBytes(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (q countedQuotas) Usage(ctx context.Context, subject string, subjectID string) (StorageUsage, error) {
+	documents, _, err := q.documents.List(ctx, q.workspaceID, repositories.Page{})
+	usage := StorageUsage{MaxFiles: 1}
+	for _, document := range documents {
+		if subject == models.StorageQuotaWorkspace || document.OwnerID == subjectID {
+			usage.Files++
+		}
+	}
+	if subject == models.StorageQuotaWorkspace {
+		usage.MaxFiles = 0
+	}
+	return usage, err
+}
+
+func TestConcurrentUploadsFitInQuota(t *testing.T) {
+	ctx := testContext()
+	documents := repositories.NewMemoryDocumentRepository()
+	workspaceID := uuid.New()
+	service := NewDocumentService(documents, countedQuotas{documents: documents, workspaceID: workspaceID})
+	owner := uuid.NewString()
+	directory := t.TempDir()
+
+	var wait sync.WaitGroup
+	created := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			document := models.Document{ID: uuid.New(), Title: "Upload", OwnerID: owner, WorkspaceID: workspaceID, FilePath: path.Join(directory, uuid.NewString())}
+			created <- service.Create(ctx, &document, Upload{File: strings.NewReader("x"), Size: 1})
+		}()
+	}
+	wait.Wait()
+	close(created)
+
+	var exceeded *QuotaExceededError
+	succeeded := 0
+	for err := range created {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorAs(t, err, &exceeded)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+// unsavedDocuments fails to save the documents.
+type unsavedDocuments struct {
+	*repositories.MemoryDocumentRepository
+}
+
+func (unsavedDocuments) Save(ctx context.Context, document *models.Document) error {
+	return errors.New("database unavailable")
+}
+
+func TestUpdateDocumentFile(t *testing.T) {
+	ctx := testContext()
+	documents := unsavedDocuments{repositories.NewMemoryDocumentRepository()}
+	service := NewDocumentService(documents, subjectQuotas{})
+	directory := t.TempDir()
+	document := models.Document{ID: uuid.New(), Title: "Replaced", OwnerID: uuid.NewString(), WorkspaceID: uuid.New(), FilePath: path.Join(directory, "document.pdf")}
+	assert.Nil(t, service.Create(ctx, &document, Upload{File: strings.NewReader("first"), Size: 5}))
+
+	// the file stays the one the row describes
+	_, err := service.Update(ctx, document, DocumentChanges{}, &Upload{File: strings.NewReader("second version"), Size: 14})
+	assert.ErrorContains(t, err, "database unavailable")
+	content, err := os.ReadFile(document.FilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(content))
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestListDocuments(t *testing.T) {
+	ctx := testContext()
+	documents := repositories.NewMemoryDocumentRepository()
+	service := NewDocumentService(documents, subjectQuotas{})
+	workspaceID := uuid.New()
+	for _, title := range []string{"c", "a", "b"} {
+		assert.Nil(t, documents.Create(ctx, &models.Document{ID: uuid.New(), Title: title, WorkspaceID: workspaceID}))
+	}
+	assert.Nil(t, documents.Create(ctx, &models.Document{ID: uuid.New(), Title: "other", WorkspaceID: uuid.New()}))
+
+	list, err := service.List(ctx, workspaceID, 1, 2, "title", "desc")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), list.TotalDocuments)
+	assert.Equal(t, int64(2), list.TotalPages)
+	assert.Equal(t, []string{"c", "b"}, []string{list.Documents[0].Title, list.Documents[1].Title})
+
+	list, err = service.List(ctx, workspaceID, 2, 2, "title", "asc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list.Documents))
+	assert.Equal(t, "c", list.Documents[0].Title)
+
+	// the documents of other tenants are not seen
+	list, err = service.List(testContext(), workspaceID, 1, 10, "id", "asc")
+	assert.Nil(t, err)
+	assert.Empty(t, list.Documents)
+	_, err = service.List(context.Background(), workspaceID, 1, 10, "id", "asc")
+	assert.ErrorIs(t, err, database.ErrNoTenant)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNoUserChanges is returned when an update changes neither the name nor the email.
+	ErrNoUserChanges = errors.New("name and email cannot be empty")
+	// ErrInvalidEmail is returned for email addresses that are not a bare address.
+	ErrInvalidEmail = errors.New("invalid email address")
+	// ErrUserHasRoles is returned when deleting a user with roles, which only
+	// the users with the users:manage permission delete.
+	ErrUserHasRoles = errors.New("users with roles cannot be deleted")
+)
+
+// AccessRevoker ends every way a user has to reach the API.
+type AccessRevoker interface {
+	// RevokeAccess ends the sessions and revokes the API keys of the user.
+	RevokeAccess(ctx context.Context, userID uuid.UUID) error
+}
+
+// UserChanges are the fields of a user to change, the empty ones are kept.
+type UserChanges struct {
+	Name  string
+	Email string
+}
+
+// userSortColumns are the columns the users may be sorted by.
+var userSortColumns = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+}
+
+// IsValidEmail reports whether email is a bare address such as "john@example.com".
+func IsValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+type UserService struct {
+	users  repositories.UserRepository
+	access AccessRevoker
+}
+
+func NewUserService(users repositories.UserRepository, access AccessRevoker) *UserService {
+	return &UserService{users: users, access: access}
+}
+
+// List returns limit users from start, sorted by id, name or email, asc or
+// desc. Unknown sorts fall back to the id, ascending.
+func (s *UserService) List(ctx context.Context, start int, limit int, sort string, dir string) ([]models.User, error) {
+	column, ok := userSortColumns[sort]
+	if !ok {
+		column = "id"
+	}
+	return s.users.List(ctx, repositories.Page{Offset: start, Limit: limit, Sort: column, Desc: dir == "desc"})
+}
+
+// Get returns the user, or repositories.ErrNotFound.
+func (s *UserService) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	return s.users.Get(ctx, id)
+}
+
+// RoleNames returns the names of the roles of the user, sorted.
+func (s *UserService) RoleNames(ctx context.Context, id uuid.UUID) ([]string, error) {
+	return s.users.RoleNames(ctx, id)
+}
+
+// ApplyChanges checks the changes and applies them to user, without saving
+// it. A new email address has to be verified again.
+func (s *UserService) ApplyChanges(user *models.User, changes UserChanges) error {
+	if changes.Name == "" && changes.Email == "" {
+		return ErrNoUserChanges
+	}
+	if changes.Email != "" && changes.Email != user.Email && !IsValidEmail(changes.Email) {
+		return ErrInvalidEmail
+	}
+
+	if changes.Name != "" {
+		user.Name = changes.Name
+	}
+	if changes.Email != "" && changes.Email != user.Email {
+		user.Email = changes.Email
+		user.EmailVerified = false
+	}
+	return nil
+}
+
+// Save saves the user.
+func (s *UserService) Save(ctx context.Context, user *models.User) error {
+	return s.users.Save(ctx, user)
+}
+
+// Delete deletes the user, unless it has roles, and revokes its access.
+func (s *UserService) Delete(ctx context.Context, user models.User) error {
+	roles, err := s.users.RoleNames(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		return ErrUserHasRoles
+	}
+
+	if err := s.users.Delete(ctx, user); err != nil {
+		return err
+	}
+	if err := s.access.RevokeAccess(ctx, user.ID); err != nil {
+		return fmt.Errorf("revoking the access of the user: %w", err)
+	}
+	return nil
+}
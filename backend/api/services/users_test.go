@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/api/repositories"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type revokedUsers []uuid.UUID
+
+func (r *revokedUsers) RevokeAccess(ctx context.Context, userID uuid.UUID) error {
+	*r = append(*r, userID)
+	return nil
+}
+
+func TestUserChanges(t *testing.T) {
+	service := NewUserService(repositories.NewMemoryUserRepository(), &revokedUsers{})
+	user := models.User{Name: "user", Email: "user@example.com", EmailVerified: true}
+
+	assert.ErrorIs(t, service.ApplyChanges(&user, UserChanges{}), ErrNoUserChanges)
+	assert.ErrorIs(t, service.ApplyChanges(&user, UserChanges{Email: "John <john@example.com>"}), ErrInvalidEmail)
+	assert.Equal(t, "user@example.com", user.Email)
+
+	assert.Nil(t, service.ApplyChanges(&user, UserChanges{Name: "renamed", Email: "user@example.com"}))
+	assert.Equal(t, "renamed", user.Name)
+	assert.True(t, user.EmailVerified)
+	assert.Nil(t, service.ApplyChanges(&user, UserChanges{Email: "new@example.com"}))
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.False(t, user.EmailVerified)
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := testContext()
+	users := repositories.NewMemoryUserRepository()
+	revoked := &revokedUsers{}
+	service := NewUserService(users, revoked)
+	user := models.User{ID: uuid.New(), Name: "user", Email: "user@example.com"}
+	assert.Nil(t, users.Save(ctx, &user))
+
+	users.SetRoles(user.ID, models.RoleAdmin)
+	assert.ErrorIs(t, service.Delete(ctx, user), ErrUserHasRoles)
+	assert.Empty(t, *revoked)
+
+	users.SetRoles(user.ID)
+	assert.Nil(t, service.Delete(ctx, user))
+	assert.Equal(t, revokedUsers{user.ID}, *revoked)
+	_, err := service.Get(ctx, user.ID)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
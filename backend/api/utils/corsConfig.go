@@ -6,11 +6,12 @@ import (
 	"github.com/gin-contrib/cors"
 )
 
-func CORSConfig() cors.Config {
+// CORSConfig allows the given origins, and the frontend served from the local IP.
+func CORSConfig(origins []string) cors.Config {
 	config := cors.DefaultConfig()
 	// config.AllowAllOrigins = true
 	localIp, err := GetLocalIP()
-	config.AllowOrigins = []string{"http://localhost", "http://localhost:3000"}
+	config.AllowOrigins = append([]string{}, origins...)
 	// write the local IP to the .env file
 	if err != nil {
 		log.Printf("Warning: Error retrieving local IP address: %v", err)
@@ -19,8 +20,8 @@ func CORSConfig() cors.Config {
 		_ = WriteEnvFile(localIp)
 	}
 	config.AllowMethods = []string{"POST", "GET", "PUT", "OPTIONS", "DELETE"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "User-Agent", "Cache-Control", "Pragma"}
-	config.ExposeHeaders = []string{"Content-Length"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "User-Agent", "Cache-Control", "Pragma", "X-Request-ID"}
+	config.ExposeHeaders = []string{"Content-Length", "Retry-After", "X-Request-ID"}
 	config.AllowCredentials = true
 
 	return config
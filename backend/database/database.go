@@ -1,10 +1,18 @@
 package database
 
 import (
+	"context"
 	"document-manager/api/models"
+	"document-manager/config"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/glebarez/sqlite"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
@@ -13,34 +21,103 @@ import (
 
 var db *gorm.DB
 
-// InitDB inicializa a conexão com o banco de dados PostgreSQL
+// InitDB inicializa a conexão com o banco de dados, PostgreSQL ou SQLite
+// conforme database.driver
 func InitDB() (*gorm.DB, error) {
-	dsn := getDSNFromEnv()
+	dsn := getDSN()
+
+	var dialector gorm.Dialector
+	switch config.Get().Database.Driver {
+	case config.DriverSQLite:
+		if err := os.MkdirAll(filepath.Dir(config.Get().Database.Path), 0o750); err != nil {
+			return nil, err
+		}
+		dialector = sqlite.Open(dsn)
+	default:
+		dialector = postgres.Open(dsn)
+	}
 
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
+	if err := registerTenantCallbacks(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
-func InitMasterUser() error {
+// InitRoles creates the built-in roles of every tenant, keeping their
+// permissions up to date.
+func InitRoles() error {
+	return ForEachTenant(func(tx *gorm.DB, tenant models.Tenant) error {
+		_, err := initRoles(tx)
+		return err
+	})
+}
+
+// initRoles creates the built-in roles of the tenant of tx and returns the admin role.
+func initRoles(tx *gorm.DB) (models.Role, error) {
+	var admin models.Role
+	err := tx.Where("name = ?", models.RoleAdmin).First(&admin).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		admin = models.Role{
+			ID:          uuid.New(),
+			Name:        models.RoleAdmin,
+			Description: "Every permission",
+			Permissions: strings.Join(models.Permissions, " "),
+			BuiltIn:     true,
+		}
+		err = tx.Create(&admin).Error
+	case err == nil:
+		err = tx.Model(&admin).Update("permissions", strings.Join(models.Permissions, " ")).Error
+	}
+	return admin, err
+}
+
+// InitMasterUser creates the initial user, with the admin role, of every
+// tenant without users, with its master password of tenants.
+func InitMasterUser(tenants config.TenantsConfig) error {
+	return ForEachTenant(func(tx *gorm.DB, tenant models.Tenant) error {
+		return initMasterUser(tx, tenant, masterPassword(tenants, tenant))
+	})
+}
+
+// initMasterUser creates the master user of the tenant of tx when it has no
+// users. Only the default tenant can do without password, and gets a
+// default one to change.
+func initMasterUser(tx *gorm.DB, tenant models.Tenant, password string) error {
 	//inicializar com usuário master padrão
 	var count int64
 	var users []models.User
-	if err := db.Find(&users).Count(&count).Error; err != nil {
+	if err := tx.Find(&users).Count(&count).Error; err != nil {
 		return err
 	}
 
 	if count == 0 {
+		// the default password must be changed on the first login, and only
+		// the default tenant has one
+		mustChange := password == ""
+		if mustChange {
+			if tenant.Slug != models.DefaultTenantSlug {
+				return fmt.Errorf("%w, set %s", ErrNoMasterPassword, masterPasswordVariable(tenant))
+			}
+			password = "copa2026"
+			log.Println("Warning: MASTER_PASSWORD is not set, the master user was created with the default password. Change it and enable two-factor authentication.")
+		}
+
+		now := time.Now()
 		masterUser := models.User{
-			ID:       uuid.New(),
-			Name:     "master",
-			Email:    "master@email.com",
-			Password: "copa2026",
-			Master:   true,
+			ID:                 uuid.New(),
+			Name:               "master",
+			Email:              "master@email.com",
+			Password:           password,
+			PasswordChangedAt:  &now,
+			MustChangePassword: mustChange,
 		}
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(masterUser.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -48,30 +125,110 @@ func InitMasterUser() error {
 		}
 		masterUser.Password = string(hashedPassword)
 
-		if err := db.Create(&masterUser).Error; err != nil {
+		var admin models.Role
+		if err := tx.Where("name = ?", models.RoleAdmin).First(&admin).Error; err != nil {
 			return err
 		}
+
+		return tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&masterUser).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.PasswordHistory{ID: uuid.New(), UserID: masterUser.ID, Password: masterUser.Password}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.UserRole{UserID: masterUser.ID, RoleID: admin.ID}).Error
+		})
 	}
 	return nil
 }
 
+// PersonalWorkspace returns the personal workspace of the user, creating it on first use.
+func PersonalWorkspace(tx *gorm.DB, userID uuid.UUID) (models.Workspace, error) {
+	var workspace models.Workspace
+	err := tx.Where("personal_user_id = ?", userID).First(&workspace).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return workspace, err
+	}
+
+	workspace = models.Workspace{ID: uuid.New(), Name: "Personal", PersonalUserID: &userID}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: userID, Role: models.WorkspaceRoleOwner}).Error
+	})
+	if err != nil {
+		// another request created it first
+		if tx.Where("personal_user_id = ?", userID).First(&workspace).Error == nil {
+			return workspace, nil
+		}
+	}
+	return workspace, err
+}
+
+// InitDocumentSizes records the size of the files uploaded before the storage
+// used by users and workspaces was tracked.
+func InitDocumentSizes() error {
+	return ForEachTenant(func(tx *gorm.DB, tenant models.Tenant) error {
+		var documents []models.Document
+		if err := tx.Where("file_size = 0 AND file_path <> ''").Find(&documents).Error; err != nil {
+			return err
+		}
+
+		for _, document := range documents {
+			info, err := os.Stat(document.FilePath)
+			if err != nil {
+				log.Printf("File of document '%s' not found, its size is left unknown: %v", document.ID, err)
+				continue
+			}
+			if err := tx.Model(&document).UpdateColumn("file_size", info.Size()).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // GetDB retorna a instância do banco de dados para ser usada nos modelos e nas rotas
 func GetDB() *gorm.DB {
 	return db
 }
 
+// Ping checks the database of InitDB answers.
+func Ping(ctx context.Context) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the connections of the database of InitDB, waiting for the
+// queries in progress.
+func Close() error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
 // DSN  "Data Source Name" ou "Nome da Fonte de Dados".
-func getDSNFromEnv() string {
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+func getDSN() string {
+	database := config.Get().Database
+
+	if database.Driver == config.DriverSQLite {
+		if database.Path == "" {
+			log.Fatal("The SQLite database file is not set, set DB_PATH.")
+		}
+		return database.DSN()
+	}
 
 	// Adicione verificações para garantir que os valores não estejam vazios
-	if dbHost == "" || dbPort == "" || dbUser == "" || dbPassword == "" || dbName == "" {
-		log.Fatal("Some database environment variable is missing or empty.")
+	if database.Host == "" || database.Port == "" || database.User == "" || database.Password == "" || database.Name == "" {
+		log.Fatal("Some database setting is missing or empty, set DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_NAME.")
 	}
 
-	return "host=" + dbHost + " user=" + dbUser + " password=" + dbPassword + " dbname=" + dbName + " port=" + dbPort + " sslmode=disable TimeZone=America/Fortaleza"
+	return database.DSN()
 }
@@ -0,0 +1,344 @@
+package database
+
+import (
+	"context"
+	"document-manager/config"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// Migrations returns the schema migrations compiled into the binary for the
+// database driver, config.DriverPostgres or config.DriverSQLite. Each one is
+// a pair of files, <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Both drivers have the same versions, written in their own SQL.
+func Migrations(driver string) (fs.FS, error) {
+	switch driver {
+	case config.DriverPostgres, config.DriverSQLite:
+		return fs.Sub(migrationFiles, "migrations/"+driver)
+	}
+	return nil, fmt.Errorf("no migrations for the %s database driver", driver)
+}
+
+// migrationLockKey identifies the advisory lock held while migrating, so that
+// instances started together do not apply the same migration twice.
+const migrationLockKey int64 = 4372010561
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrPendingMigrations is returned when the schema is behind the migrations.
+var ErrPendingMigrations = errors.New("the database schema has pending migrations")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, nil when pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the migrations, recording the applied
+// versions in its table.
+type Migrator struct {
+	db         *gorm.DB
+	table      string
+	migrations []Migration
+}
+
+// NewMigrator reads the migrations at the root of files, and records the
+// applied ones in the schema_migrations table.
+func NewMigrator(db *gorm.DB, files fs.FS) (*Migrator, error) {
+	return newMigrator(db, files, "schema_migrations")
+}
+
+func newMigrator(db *gorm.DB, files fs.FS, table string) (*Migrator, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, name := range names {
+		match := migrationFilePattern.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: the name must be <version>_<name>.up.sql or <version>_<name>.down.sql", name)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: the version must be a positive number", name)
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %s: version %d is also %s", name, version, migration.Name)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return &Migrator{db: db, table: table, migrations: migrations}, nil
+}
+
+// Latest returns the version of the last migration, 0 without migrations.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists every migration with when it was applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if record, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &record.AppliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Check returns ErrPendingMigrations when some migrations are not applied.
+// Unlike Pending it neither takes the migration lock nor creates the table,
+// so it may run often, such as at each readiness probe.
+func (m *Migrator) Check(ctx context.Context) error {
+	var versions []int64
+	if err := m.db.WithContext(ctx).Table(m.table).Pluck("version", &versions).Error; err != nil {
+		return err
+	}
+	applied := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	pending := 0
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d", ErrPendingMigrations, pending)
+	}
+	return nil
+}
+
+// Up applies every pending migration and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	return m.To(m.Latest())
+}
+
+// Down rolls back the last steps applied migrations and returns them.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("the number of migrations to roll back must be positive")
+	}
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.rollback(conn, migration); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// To applies or rolls back migrations until the schema is at version, 0
+// rolling back every migration, and returns the migrations it ran.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	if version != 0 && !m.known(version) {
+		return nil, fmt.Errorf("unknown migration version %d, see migrate status", version)
+	}
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				if err := m.rollback(conn, migration); err != nil {
+					return err
+				}
+				done = append(done, migration)
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.apply(conn, migration); err != nil {
+					return err
+				}
+				done = append(done, migration)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// locked runs fn on a single connection holding the migration lock, after
+// creating the table of the applied migrations.
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	return m.db.Connection(func(conn *gorm.DB) error {
+		// only PostgreSQL has advisory locks
+		if conn.Dialector.Name() == config.DriverPostgres {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+				return fmt.Errorf("locking the migrations: %w", err)
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+		}
+
+		// SQLite only reads back the times of datetime columns
+		timeType := "timestamptz"
+		if conn.Dialector.Name() == config.DriverSQLite {
+			timeType = "datetime"
+		}
+		createTable := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" ("version" bigint PRIMARY KEY, "name" text NOT NULL, "applied_at" %s NOT NULL)`, m.table, timeType)
+		if err := conn.Exec(createTable).Error; err != nil {
+			return fmt.Errorf("creating the %s table: %w", m.table, err)
+		}
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]schemaMigration, error) {
+	var records []schemaMigration
+	if err := conn.Table(m.table).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]schemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// apply runs the up file of migration and records it, in one transaction.
+func (m *Migrator) apply(conn *gorm.DB, migration Migration) error {
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Up).Error; err != nil {
+			return err
+		}
+		return tx.Table(m.table).Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// rollback runs the down file of migration and forgets it, in one transaction.
+func (m *Migrator) rollback(conn *gorm.DB, migration Migration) error {
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Table(m.table).Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// GetMigrator returns the migrator of the database of InitDB.
+func GetMigrator() (*Migrator, error) {
+	migrations, err := Migrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, migrations)
+}
+
+// Migrate applies the pending migrations to the database of InitDB.
+func Migrate() ([]Migration, error) {
+	migrator, err := GetMigrator()
+	if err != nil {
+		return nil, err
+	}
+	return migrator.Up()
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/config"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testMigrations = fstest.MapFS{
+	"1_create_migrate_notes.up.sql":   {Data: []byte(`CREATE TABLE migrate_notes (id integer PRIMARY KEY, body text);`)},
+	"1_create_migrate_notes.down.sql": {Data: []byte(`DROP TABLE migrate_notes;`)},
+	"2_add_migrate_tags.up.sql": {Data: []byte(`CREATE TABLE migrate_tags (id integer PRIMARY KEY, name text);
+INSERT INTO migrate_tags (id, name) VALUES (1, 'baseline');`)},
+	"2_add_migrate_tags.down.sql":   {Data: []byte(`DROP TABLE migrate_tags;`)},
+	"3_index_migrate_tags.up.sql":   {Data: []byte(`CREATE UNIQUE INDEX idx_migrate_tags_name ON migrate_tags (name);`)},
+	"3_index_migrate_tags.down.sql": {Data: []byte(`DROP INDEX idx_migrate_tags_name;`)},
+}
+
+// TestMain runs the tests on a throwaway SQLite database, unless DB_DRIVER
+// chooses one.
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_DRIVER") != "" {
+		os.Exit(m.Run())
+	}
+	directory, err := os.MkdirTemp("", "document-manager-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DB_DRIVER", config.DriverSQLite)
+	os.Setenv("DB_PATH", filepath.Join(directory, "test.db"))
+	code := m.Run()
+	os.RemoveAll(directory)
+	os.Exit(code)
+}
+
+func testMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	db, err := InitDB()
+	assert.Nil(t, err)
+	migrator, err := newMigrator(db, testMigrations, "test_schema_migrations")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		migrator.To(0)
+		db.Exec("DROP TABLE test_schema_migrations")
+	})
+	return migrator, db
+}
+
+func appliedVersions(t *testing.T, migrator *Migrator) []int64 {
+	statuses, err := migrator.Status()
+	assert.Nil(t, err)
+	versions := []int64{}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			versions = append(versions, status.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrator(t *testing.T) {
+	migrator, db := testMigrator(t)
+	assert.Equal(t, int64(3), migrator.Latest())
+	assert.Equal(t, []int64{}, appliedVersions(t, migrator))
+
+	done, err := migrator.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(done))
+	assert.Equal(t, []int64{1, 2, 3}, appliedVersions(t, migrator))
+	assert.True(t, db.Migrator().HasTable("migrate_tags"))
+	var count int64
+	db.Table("migrate_tags").Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// applied migrations are not applied again
+	done, err = migrator.Up()
+	assert.Nil(t, err)
+	assert.Empty(t, done)
+
+	done, err = migrator.Down(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3, 2}, []int64{done[0].Version, done[1].Version})
+	assert.Equal(t, []int64{1}, appliedVersions(t, migrator))
+	assert.False(t, db.Migrator().HasTable("migrate_tags"))
+
+	done, err = migrator.To(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(done))
+	assert.Equal(t, []int64{1, 2}, appliedVersions(t, migrator))
+	pending, err := migrator.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, "index_migrate_tags", pending[0].Name)
+	assert.ErrorIs(t, migrator.Check(context.Background()), ErrPendingMigrations)
+	_, err = migrator.Up()
+	assert.Nil(t, err)
+	assert.Nil(t, migrator.Check(context.Background()))
+
+	_, err = migrator.To(7)
+	assert.ErrorContains(t, err, "unknown migration version 7")
+	_, err = migrator.To(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{}, appliedVersions(t, migrator))
+	assert.False(t, db.Migrator().HasTable("migrate_notes"))
+}
+
+func TestMigrationFailureIsRolledBack(t *testing.T) {
+	db, err := InitDB()
+	assert.Nil(t, err)
+	files := fstest.MapFS{
+		"1_broken.up.sql":   {Data: []byte(`CREATE TABLE migrate_broken (id integer); SELECT * FROM missing_table;`)},
+		"1_broken.down.sql": {Data: []byte(`DROP TABLE migrate_broken;`)},
+	}
+	migrator, err := newMigrator(db, files, "test_schema_migrations")
+	assert.Nil(t, err)
+	defer db.Exec("DROP TABLE test_schema_migrations")
+
+	_, err = migrator.Up()
+	assert.ErrorContains(t, err, "applying migration 1_broken")
+	assert.Equal(t, []int64{}, appliedVersions(t, migrator))
+	assert.False(t, db.Migrator().HasTable("migrate_broken"))
+}
+
+// releasedUser and releasedDocument are the models of the release that
+// created the tables with AutoMigrate.
+type releasedUser struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name      string    `gorm:"unique;not null"`
+	Email     string    `gorm:"unique;not null"`
+	Password  string    `gorm:"not null"`
+	Master    bool      `gorm:"not null,omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+}
+
+func (releasedUser) TableName() string { return "users" }
+
+type releasedDocument struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Title       string    `gorm:"not null"`
+	Description string
+	FilePath    string
+	OwnerID     string `gorm:"not null"`
+	OwnerName   string `gorm:"not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   *time.Time
+}
+
+func (releasedDocument) TableName() string { return "documents" }
+
+func TestMigrateReleasedSchema(t *testing.T) {
+	db, err := InitDB()
+	assert.Nil(t, err)
+	migrations, err := Migrations(config.DriverSQLite)
+	assert.Nil(t, err)
+	migrator, err := NewMigrator(db, migrations)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		migrator.To(0)
+		db.Exec("DROP TABLE schema_migrations")
+	})
+
+	assert.Nil(t, db.AutoMigrate(&releasedUser{}, &releasedDocument{}))
+	master := releasedUser{ID: uuid.New(), Name: "master", Email: "master@email.com", Password: "hash", Master: true}
+	user := releasedUser{ID: uuid.New(), Name: "user", Email: "user@email.com", Password: "hash"}
+	assert.Nil(t, db.Create([]releasedUser{master, user}).Error)
+	masterDocument := releasedDocument{ID: uuid.New(), Title: "report", OwnerID: master.ID.String(), OwnerName: master.Name}
+	orphanDocument := releasedDocument{ID: uuid.New(), Title: "imported", OwnerID: "legacy", OwnerName: "legacy"}
+	assert.Nil(t, db.Create([]releasedDocument{masterDocument, orphanDocument}).Error)
+
+	_, err = migrator.Up()
+	assert.Nil(t, err)
+	assert.Nil(t, migrator.Check(context.Background()))
+
+	defaultTenant, err := FindTenant(models.DefaultTenantSlug)
+	assert.Nil(t, err)
+	tx := db.WithContext(WithTenant(context.Background(), defaultTenant.ID))
+
+	// the users keep their rows, in the default tenant, and the master flag
+	// becomes the admin role
+	assert.False(t, db.Migrator().HasColumn(&models.User{}, "master"))
+	var users []models.User
+	assert.Nil(t, tx.Order("name").Find(&users).Error)
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, master.ID, users[0].ID)
+	assert.Equal(t, models.UserStatusActive, users[0].Status)
+	var adminIDs []uuid.UUID
+	assert.Nil(t, tx.Model(&models.UserRole{}).Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.name = ?", models.RoleAdmin).Pluck("user_roles.user_id", &adminIDs).Error)
+	assert.Equal(t, []uuid.UUID{master.ID}, adminIDs)
+
+	// names are unique per tenant only
+	assert.Nil(t, db.Exec(`INSERT INTO tenants (id, slug, name) VALUES (?, 'other', 'Other')`, uuid.New()).Error)
+	assert.Nil(t, db.Exec(`INSERT INTO users (id, tenant_id, name, email, password) SELECT ?, id, 'master', 'master@email.com', 'hash' FROM tenants WHERE slug = 'other'`, uuid.New()).Error)
+	assert.NotNil(t, db.Exec(`INSERT INTO users (id, tenant_id, name, email, password) VALUES (?, ?, 'master', 'other@email.com', 'hash')`, uuid.New(), defaultTenant.ID).Error)
+
+	// the documents move to the personal workspace of their owner
+	var documents []models.Document
+	assert.Nil(t, tx.Order("title").Find(&documents).Error)
+	assert.Equal(t, 2, len(documents))
+	assert.Equal(t, uuid.Nil, documents[0].WorkspaceID)
+	workspace, err := PersonalWorkspace(tx, master.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, workspace.ID, documents[1].WorkspaceID)
+
+	// and back to the release
+	assert.Nil(t, db.Exec(`DELETE FROM users WHERE tenant_id <> ?`, defaultTenant.ID).Error)
+	_, err = migrator.To(1)
+	assert.Nil(t, err)
+	var released []releasedUser
+	assert.Nil(t, db.Order("name").Find(&released).Error)
+	assert.Equal(t, []bool{true, false}, []bool{released[0].Master, released[1].Master})
+	assert.False(t, db.Migrator().HasTable(&models.Tenant{}))
+}
+
+func TestMigrationFiles(t *testing.T) {
+	_, err := newMigrator(nil, fstest.MapFS{"1_only_up.up.sql": {Data: []byte("SELECT 1;")}}, "test_schema_migrations")
+	assert.ErrorContains(t, err, "needs both an up and a down file")
+	_, err = newMigrator(nil, fstest.MapFS{"create_users.up.sql": {Data: []byte("SELECT 1;")}}, "test_schema_migrations")
+	assert.ErrorContains(t, err, "the name must be")
+
+	// the migrations compiled into the binary start with the baseline, with
+	// the same versions for every driver
+	var versions [][]int64
+	for _, driver := range []string{config.DriverPostgres, config.DriverSQLite} {
+		migrations, err := Migrations(driver)
+		assert.Nil(t, err)
+		migrator, err := NewMigrator(nil, migrations)
+		assert.Nil(t, err)
+		assert.Equal(t, "baseline", migrator.migrations[0].Name)
+		assert.Equal(t, int64(1), migrator.migrations[0].Version)
+
+		driverVersions := []int64{}
+		for _, migration := range migrator.migrations {
+			driverVersions = append(driverVersions, migration.Version)
+		}
+		versions = append(versions, driverVersions)
+	}
+	assert.Equal(t, versions[0], versions[1])
+
+	_, err = Migrations("mysql")
+	assert.ErrorContains(t, err, "no migrations for the mysql database driver")
+}
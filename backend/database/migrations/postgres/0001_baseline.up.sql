@@ -0,0 +1,30 @@
+-- Schema of the release that created the tables with AutoMigrate. Every
+-- statement is idempotent, so the databases it created adopt this baseline,
+-- which the following migrations bring up to date.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" uuid,
+    "name" text NOT NULL,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "master" boolean,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_name" UNIQUE ("name"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+
+CREATE TABLE IF NOT EXISTS "documents" (
+    "id" uuid,
+    "title" text NOT NULL,
+    "description" text,
+    "file_path" text,
+    "owner_id" text NOT NULL,
+    "owner_name" text NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id")
+);
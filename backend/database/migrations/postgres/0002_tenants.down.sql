@@ -0,0 +1,11 @@
+-- fails when users of different tenants share a name or an email
+DROP INDEX "idx_documents_tenant_id";
+ALTER TABLE "documents" DROP COLUMN "tenant_id";
+
+DROP INDEX "idx_users_tenant_name";
+DROP INDEX "idx_users_tenant_email";
+ALTER TABLE "users" DROP COLUMN "tenant_id";
+ALTER TABLE "users" ADD CONSTRAINT "uni_users_name" UNIQUE ("name");
+ALTER TABLE "users" ADD CONSTRAINT "uni_users_email" UNIQUE ("email");
+
+DROP TABLE "tenants";
@@ -0,0 +1,30 @@
+-- The users and documents of the release belong to the default tenant, whose
+-- files stay where they are. Names and emails become unique per tenant.
+
+CREATE TABLE "tenants" (
+    "id" uuid,
+    "slug" text NOT NULL,
+    "name" text NOT NULL,
+    "storage_prefix" text NOT NULL DEFAULT '',
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_tenants_slug" ON "tenants" ("slug");
+
+INSERT INTO "tenants" ("id", "slug", "name", "storage_prefix", "created_at", "updated_at")
+VALUES (gen_random_uuid(), 'default', 'Default', '', now(), now());
+
+ALTER TABLE "users" ADD COLUMN "tenant_id" uuid;
+UPDATE "users" SET "tenant_id" = (SELECT "id" FROM "tenants" WHERE "slug" = 'default');
+-- the constraints of the release, named as AutoMigrate named them before and since GORM 1.25
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "uni_users_name";
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "uni_users_email";
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "users_name_key";
+ALTER TABLE "users" DROP CONSTRAINT IF EXISTS "users_email_key";
+CREATE UNIQUE INDEX "idx_users_tenant_email" ON "users" ("tenant_id","email");
+CREATE UNIQUE INDEX "idx_users_tenant_name" ON "users" ("tenant_id","name");
+
+ALTER TABLE "documents" ADD COLUMN "tenant_id" uuid;
+UPDATE "documents" SET "tenant_id" = (SELECT "id" FROM "tenants" WHERE "slug" = 'default');
+CREATE INDEX "idx_documents_tenant_id" ON "documents" ("tenant_id");
@@ -0,0 +1,132 @@
+-- Account lifecycle, email verification, two-factor authentication, sessions,
+-- external identities, API keys and the settings of the tenants. The users
+-- of the release are active and have not verified their email.
+
+ALTER TABLE "users" ADD COLUMN "email_verified" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "status" text NOT NULL DEFAULT 'active';
+ALTER TABLE "users" ADD COLUMN "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "totp_secret" text;
+ALTER TABLE "users" ADD COLUMN "totp_last_step" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "users" ADD COLUMN "password_changed_at" timestamptz;
+ALTER TABLE "users" ADD COLUMN "must_change_password" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "user_tokens" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "purpose" text NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_user_tokens_user_id" ON "user_tokens" ("user_id");
+CREATE INDEX "idx_user_tokens_tenant_id" ON "user_tokens" ("tenant_id");
+
+CREATE TABLE "refresh_tokens" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "family_id" uuid NOT NULL,
+    "token_hash" text NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz,
+    "revoked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_refresh_tokens_tenant_id" ON "refresh_tokens" ("tenant_id");
+CREATE UNIQUE INDEX "idx_refresh_tokens_token_hash" ON "refresh_tokens" ("token_hash");
+CREATE INDEX "idx_refresh_tokens_family_id" ON "refresh_tokens" ("family_id");
+CREATE INDEX "idx_refresh_tokens_user_id" ON "refresh_tokens" ("user_id");
+
+CREATE TABLE "sessions" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "user_agent" text,
+    "ip" text,
+    "created_at" timestamptz,
+    "last_seen_at" timestamptz,
+    "revoked_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_sessions_user_id" ON "sessions" ("user_id");
+CREATE INDEX "idx_sessions_tenant_id" ON "sessions" ("tenant_id");
+
+CREATE TABLE "recovery_codes" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "code_hash" text NOT NULL,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+CREATE INDEX "idx_recovery_codes_tenant_id" ON "recovery_codes" ("tenant_id");
+
+CREATE TABLE "settings" (
+    "tenant_id" uuid,
+    "key" text,
+    "value" text NOT NULL,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("tenant_id",
+    "key")
+);
+
+CREATE TABLE "user_identities" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "issuer" text NOT NULL,
+    "subject" text NOT NULL,
+    "email" text,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_user_identities_user_id" ON "user_identities" ("user_id");
+CREATE UNIQUE INDEX "idx_user_identities_tenant_issuer_subject" ON "user_identities" ("tenant_id","issuer","subject");
+
+CREATE TABLE "api_keys" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "name" text NOT NULL,
+    "prefix" text NOT NULL,
+    "key_hash" text NOT NULL,
+    "scopes" text NOT NULL,
+    "expires_at" timestamptz,
+    "last_used_at" timestamptz,
+    "revoked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_api_keys_key_hash" ON "api_keys" ("key_hash");
+CREATE INDEX "idx_api_keys_user_id" ON "api_keys" ("user_id");
+CREATE INDEX "idx_api_keys_tenant_id" ON "api_keys" ("tenant_id");
+
+CREATE TABLE "password_histories" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "user_id" uuid NOT NULL,
+    "password" text NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_password_histories_user_id" ON "password_histories" ("user_id");
+CREATE INDEX "idx_password_histories_tenant_id" ON "password_histories" ("tenant_id");
+
+CREATE TABLE "invites" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "email" text NOT NULL,
+    "token_hash" text NOT NULL,
+    "invited_by_id" uuid NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_invites_token_hash" ON "invites" ("token_hash");
+CREATE INDEX "idx_invites_tenant_id" ON "invites" ("tenant_id");
@@ -0,0 +1,6 @@
+DROP INDEX "idx_documents_workspace_id";
+ALTER TABLE "documents" DROP COLUMN "workspace_id";
+
+DROP TABLE "workspace_invitations";
+DROP TABLE "workspace_members";
+DROP TABLE "workspaces";
@@ -0,0 +1,2 @@
+DROP TABLE "storage_quota";
+ALTER TABLE "documents" DROP COLUMN "file_size";
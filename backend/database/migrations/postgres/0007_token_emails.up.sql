@@ -0,0 +1,5 @@
+-- The email address a token was sent to. The verification links only verify
+-- that address, and those sent before it was recorded have to be requested
+-- again.
+
+ALTER TABLE "user_tokens" ADD COLUMN "email" text NOT NULL DEFAULT '';
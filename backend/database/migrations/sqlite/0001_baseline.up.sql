@@ -0,0 +1,30 @@
+-- Schema of the baseline on SQLite, the tables of postgres/0001_baseline
+-- with the column types SQLite reads back: datetime for the times, numeric
+-- for the booleans.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" uuid,
+    "name" text NOT NULL,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "master" numeric,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_name" UNIQUE ("name"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+
+CREATE TABLE IF NOT EXISTS "documents" (
+    "id" uuid,
+    "title" text NOT NULL,
+    "description" text,
+    "file_path" text,
+    "owner_id" text NOT NULL,
+    "owner_name" text NOT NULL,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id")
+);
@@ -0,0 +1,24 @@
+-- fails when users of different tenants share a name or an email
+DROP INDEX "idx_documents_tenant_id";
+ALTER TABLE "documents" DROP COLUMN "tenant_id";
+
+CREATE TABLE "users_release" (
+    "id" uuid,
+    "name" text NOT NULL,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "master" numeric,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_name" UNIQUE ("name"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+INSERT INTO "users_release" ("id", "name", "email", "password", "master", "created_at", "updated_at", "deleted_at")
+SELECT "id", "name", "email", "password", "master", "created_at", "updated_at", "deleted_at"
+FROM "users";
+DROP TABLE "users";
+ALTER TABLE "users_release" RENAME TO "users";
+
+DROP TABLE "tenants";
@@ -0,0 +1,42 @@
+-- The users and documents of the release belong to the default tenant, whose
+-- files stay where they are. Names and emails become unique per tenant:
+-- SQLite cannot drop the constraints of the release, the users table is
+-- rebuilt without them.
+
+CREATE TABLE "tenants" (
+    "id" uuid,
+    "slug" text NOT NULL,
+    "name" text NOT NULL,
+    "storage_prefix" text NOT NULL DEFAULT '',
+    "created_at" datetime,
+    "updated_at" datetime,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_tenants_slug" ON "tenants" ("slug");
+
+INSERT INTO "tenants" ("id", "slug", "name", "storage_prefix", "created_at", "updated_at")
+VALUES (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))), 'default', 'Default', '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+
+CREATE TABLE "users_tenants" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "name" text NOT NULL,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "master" numeric,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id")
+);
+INSERT INTO "users_tenants" ("id", "tenant_id", "name", "email", "password", "master", "created_at", "updated_at", "deleted_at")
+SELECT "id", (SELECT "id" FROM "tenants" WHERE "slug" = 'default'), "name", "email", "password", "master", "created_at", "updated_at", "deleted_at"
+FROM "users";
+DROP TABLE "users";
+ALTER TABLE "users_tenants" RENAME TO "users";
+CREATE UNIQUE INDEX "idx_users_tenant_email" ON "users" ("tenant_id","email");
+CREATE UNIQUE INDEX "idx_users_tenant_name" ON "users" ("tenant_id","name");
+
+ALTER TABLE "documents" ADD COLUMN "tenant_id" uuid;
+UPDATE "documents" SET "tenant_id" = (SELECT "id" FROM "tenants" WHERE "slug" = 'default');
+CREATE INDEX "idx_documents_tenant_id" ON "documents" ("tenant_id");
@@ -0,0 +1,8 @@
+ALTER TABLE "users" ADD COLUMN "master" numeric;
+UPDATE "users" SET "master" = EXISTS (
+    SELECT 1 FROM "user_roles" JOIN "roles" ON "roles"."id" = "user_roles"."role_id"
+    WHERE "user_roles"."user_id" = "users"."id" AND "roles"."name" = 'admin'
+);
+
+DROP TABLE "user_roles";
+DROP TABLE "roles";
@@ -0,0 +1,39 @@
+-- Roles replace the master flag: the master users get the built-in admin
+-- role, whose permissions the server keeps up to date when it starts.
+
+CREATE TABLE "roles" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "name" text NOT NULL,
+    "description" text,
+    "permissions" text NOT NULL,
+    "built_in" numeric NOT NULL DEFAULT false,
+    "created_at" datetime,
+    "updated_at" datetime,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_roles_tenant_name" ON "roles" ("tenant_id","name");
+
+CREATE TABLE "user_roles" (
+    "user_id" uuid,
+    "role_id" uuid,
+    "tenant_id" uuid,
+    "created_at" datetime,
+    PRIMARY KEY ("user_id",
+    "role_id")
+);
+CREATE INDEX "idx_user_roles_tenant_id" ON "user_roles" ("tenant_id");
+CREATE INDEX "idx_user_roles_role_id" ON "user_roles" ("role_id");
+
+INSERT INTO "roles" ("id", "tenant_id", "name", "description", "permissions", "built_in", "created_at", "updated_at")
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))), "id", 'admin', 'Every permission',
+    'documents:read_all documents:write_any documents:delete_any users:manage roles:manage settings:manage audit:read',
+    true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM "tenants";
+
+INSERT INTO "user_roles" ("user_id", "role_id", "tenant_id", "created_at")
+SELECT "users"."id", "roles"."id", "users"."tenant_id", CURRENT_TIMESTAMP
+FROM "users" JOIN "roles" ON "roles"."tenant_id" = "users"."tenant_id" AND "roles"."name" = 'admin'
+WHERE "users"."master";
+
+ALTER TABLE "users" DROP COLUMN "master";
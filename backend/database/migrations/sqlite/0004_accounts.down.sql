@@ -0,0 +1,17 @@
+DROP TABLE "invites";
+DROP TABLE "password_histories";
+DROP TABLE "api_keys";
+DROP TABLE "user_identities";
+DROP TABLE "settings";
+DROP TABLE "recovery_codes";
+DROP TABLE "sessions";
+DROP TABLE "refresh_tokens";
+DROP TABLE "user_tokens";
+
+ALTER TABLE "users" DROP COLUMN "must_change_password";
+ALTER TABLE "users" DROP COLUMN "password_changed_at";
+ALTER TABLE "users" DROP COLUMN "totp_last_step";
+ALTER TABLE "users" DROP COLUMN "totp_secret";
+ALTER TABLE "users" DROP COLUMN "totp_enabled";
+ALTER TABLE "users" DROP COLUMN "status";
+ALTER TABLE "users" DROP COLUMN "email_verified";
@@ -0,0 +1,60 @@
+-- Documents belong to workspaces: those of the release move to the personal
+-- workspace of their owner. The documents whose owner is not a user are left
+-- without workspace.
+
+CREATE TABLE "workspaces" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "name" text NOT NULL,
+    "personal_user_id" uuid,
+    "created_at" datetime,
+    "updated_at" datetime,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_workspaces_personal_user_id" ON "workspaces" ("personal_user_id");
+CREATE INDEX "idx_workspaces_tenant_id" ON "workspaces" ("tenant_id");
+
+CREATE TABLE "workspace_members" (
+    "workspace_id" uuid,
+    "user_id" uuid,
+    "role" text NOT NULL,
+    "tenant_id" uuid,
+    "created_at" datetime,
+    PRIMARY KEY ("workspace_id",
+    "user_id")
+);
+CREATE INDEX "idx_workspace_members_tenant_id" ON "workspace_members" ("tenant_id");
+CREATE INDEX "idx_workspace_members_user_id" ON "workspace_members" ("user_id");
+
+CREATE TABLE "workspace_invitations" (
+    "id" uuid,
+    "tenant_id" uuid,
+    "workspace_id" uuid NOT NULL,
+    "email" text NOT NULL,
+    "role" text NOT NULL,
+    "token_hash" text NOT NULL,
+    "invited_by_id" uuid NOT NULL,
+    "expires_at" datetime NOT NULL,
+    "accepted_at" datetime,
+    "created_at" datetime,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_workspace_invitations_token_hash" ON "workspace_invitations" ("token_hash");
+CREATE INDEX "idx_workspace_invitations_workspace_id" ON "workspace_invitations" ("workspace_id");
+CREATE INDEX "idx_workspace_invitations_tenant_id" ON "workspace_invitations" ("tenant_id");
+
+ALTER TABLE "documents" ADD COLUMN "workspace_id" uuid;
+CREATE INDEX "idx_documents_workspace_id" ON "documents" ("workspace_id");
+
+INSERT INTO "workspaces" ("id", "tenant_id", "name", "personal_user_id", "created_at", "updated_at")
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))), "tenant_id", 'Personal', "id", CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM "users"
+WHERE EXISTS (SELECT 1 FROM "documents" WHERE "documents"."owner_id" = "users"."id");
+
+INSERT INTO "workspace_members" ("workspace_id", "user_id", "role", "tenant_id", "created_at")
+SELECT "id", "personal_user_id", 'owner', "tenant_id", CURRENT_TIMESTAMP
+FROM "workspaces";
+
+UPDATE "documents" SET "workspace_id" = (
+    SELECT "id" FROM "workspaces" WHERE "workspaces"."personal_user_id" = "documents"."owner_id"
+);
@@ -0,0 +1,16 @@
+-- Storage used and its quotas. The sizes of the files of the release cannot
+-- be read from SQL: the server records them when it starts.
+
+ALTER TABLE "documents" ADD COLUMN "file_size" integer NOT NULL DEFAULT 0;
+
+CREATE TABLE "storage_quota" (
+    "tenant_id" uuid,
+    "subject" text,
+    "subject_id" uuid,
+    "max_bytes" integer NOT NULL DEFAULT 0,
+    "max_files" integer NOT NULL DEFAULT 0,
+    "updated_at" datetime,
+    PRIMARY KEY ("tenant_id",
+    "subject",
+    "subject_id")
+);
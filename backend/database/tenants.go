@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/config"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type tenantContextKey struct{}
+
+type allTenantsContextKey struct{}
+
+var (
+	// ErrNoTenant is the error of the queries on tenant data whose context
+	// names no tenant: they fail instead of seeing the rows of every tenant.
+	ErrNoTenant = errors.New("query on tenant data without a tenant")
+	// ErrOtherTenant is the error of saving a row of another tenant.
+	ErrOtherTenant = errors.New("row belongs to another tenant")
+	// ErrInvalidTenantSlug is returned for slugs that are not a DNS label.
+	ErrInvalidTenantSlug = errors.New("tenant slug must be lowercase letters, digits and hyphens")
+	// ErrNoMasterPassword is returned when creating a tenant whose master
+	// user has no password configured.
+	ErrNoMasterPassword = errors.New("the password of the master user of the tenant is not set")
+)
+
+// tenantModels lists the models with a TenantID.
+var tenantModels = []interface{}{
+	&models.User{},
+	&models.Document{},
+	&models.Role{},
+	&models.UserRole{},
+	&models.Workspace{},
+	&models.WorkspaceMember{},
+	&models.WorkspaceInvitation{},
+	&models.UserToken{},
+	&models.RefreshToken{},
+	&models.Session{},
+	&models.RecoveryCode{},
+	&models.Setting{},
+	&models.UserIdentity{},
+	&models.APIKey{},
+	&models.StorageQuota{},
+	&models.PasswordHistory{},
+	&models.Invite{},
+}
+
+// WithTenant returns a copy of ctx in which the queries on models with a
+// TenantID only see, change and create rows of the tenant.
+func WithTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// AllTenants returns a copy of ctx in which the queries see the rows of every
+// tenant, for the maintenance of the deployment.
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsContextKey{}, true)
+}
+
+// TenantFromContext returns the tenant set with WithTenant.
+func TenantFromContext(ctx context.Context) (uuid.UUID, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey{}).(uuid.UUID)
+	return tenantID, ok
+}
+
+// registerTenantCallbacks scopes every query on a model with a TenantID to
+// the tenant of its context, so handlers cannot forget to. Raw SQL is not scoped.
+func registerTenantCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:create", func(db *gorm.DB) {
+		if field, tenantID, ok := statementTenant(db); ok {
+			assignTenant(db, field, tenantID)
+		}
+	}); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", scopeToTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant:row", scopeToTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", func(db *gorm.DB) {
+		if field, tenantID, ok := statementTenant(db); ok {
+			// Save writes every column, the tenant included
+			assignTenant(db, field, tenantID)
+			addTenantCondition(db, field, tenantID)
+		}
+	}); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register("tenant:delete", scopeToTenant)
+}
+
+// statementTenant returns the TenantID field of the model of the statement and
+// the tenant of its context. ok is false when the statement is not scoped.
+func statementTenant(db *gorm.DB) (field *schema.Field, tenantID uuid.UUID, ok bool) {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return nil, uuid.Nil, false
+	}
+	field = stmt.Schema.LookUpField("TenantID")
+	if field == nil || stmt.Context.Value(allTenantsContextKey{}) != nil {
+		return nil, uuid.Nil, false
+	}
+	tenantID, ok = TenantFromContext(stmt.Context)
+	if !ok {
+		db.AddError(ErrNoTenant)
+		return nil, uuid.Nil, false
+	}
+	return field, tenantID, true
+}
+
+func scopeToTenant(db *gorm.DB) {
+	if field, tenantID, ok := statementTenant(db); ok {
+		addTenantCondition(db, field, tenantID)
+	}
+}
+
+func addTenantCondition(db *gorm.DB, field *schema.Field, tenantID uuid.UUID) {
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: db.Statement.Table, Name: field.DBName}, Value: tenantID},
+	}})
+}
+
+// assignTenant sets the tenant of the rows that have none.
+func assignTenant(db *gorm.DB, field *schema.Field, tenantID uuid.UUID) {
+	stmt := db.Statement
+	assign := func(row reflect.Value) {
+		if row.Kind() != reflect.Struct || row.Type() != stmt.Schema.ModelType {
+			return
+		}
+		value, zero := field.ValueOf(stmt.Context, row)
+		switch {
+		case zero:
+			if err := field.Set(stmt.Context, row, tenantID); err != nil {
+				db.AddError(err)
+			}
+		case value != tenantID:
+			db.AddError(ErrOtherTenant)
+		}
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			assign(reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	case reflect.Struct:
+		assign(stmt.ReflectValue)
+	}
+}
+
+// FindTenant returns the tenant identified by slug.
+func FindTenant(slug string) (models.Tenant, error) {
+	var tenant models.Tenant
+	err := db.Where("slug = ?", slug).First(&tenant).Error
+	return tenant, err
+}
+
+// CreateTenant creates a tenant, its built-in roles and its master user. The
+// files of its documents are stored under its slug. ErrNoMasterPassword is
+// returned without masterPassword.
+func CreateTenant(slug string, name string, masterPassword string) (models.Tenant, error) {
+	if !config.TenantSlugPattern.MatchString(slug) {
+		return models.Tenant{}, ErrInvalidTenantSlug
+	}
+	if name == "" {
+		name = slug
+	}
+
+	tenant := models.Tenant{ID: uuid.New(), Slug: slug, Name: name, StoragePrefix: slug}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tenant).Error; err != nil {
+			return err
+		}
+		tx = tx.WithContext(WithTenant(context.Background(), tenant.ID))
+		if _, err := initRoles(tx); err != nil {
+			return err
+		}
+		return initMasterUser(tx, tenant, masterPassword)
+	})
+	return tenant, err
+}
+
+// InitTenants creates the tenants of tenants.Create that do not exist yet,
+// slugs each optionally followed by ":" and the name of the organization,
+// with the master passwords of tenants. The default tenant, given the data
+// created before tenants existed, is created by the migrations.
+func InitTenants(tenants config.TenantsConfig) error {
+	for _, entry := range tenants.Create {
+		slug, name, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if slug == "" {
+			continue
+		}
+		if _, err := FindTenant(slug); !errors.Is(err, gorm.ErrRecordNotFound) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		tenant, err := CreateTenant(slug, strings.TrimSpace(name), tenants.MasterPasswords[slug])
+		if err != nil {
+			return err
+		}
+		log.Printf("Created tenant '%s'", tenant.Slug)
+	}
+	return nil
+}
+
+// ForEachTenant calls fn with the database scoped to each tenant.
+func ForEachTenant(fn func(tx *gorm.DB, tenant models.Tenant) error) error {
+	var tenants []models.Tenant
+	if err := db.Order("created_at").Find(&tenants).Error; err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if err := fn(db.WithContext(WithTenant(context.Background(), tenant.ID)), tenant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// masterPassword returns the password of tenants for the master user of
+// tenant, or an empty string when it is not set.
+func masterPassword(tenants config.TenantsConfig, tenant models.Tenant) string {
+	if tenant.Slug == models.DefaultTenantSlug {
+		return tenants.MasterPassword
+	}
+	return tenants.MasterPasswords[tenant.Slug]
+}
+
+// masterPasswordVariable is MASTER_PASSWORD for the default tenant,
+// MASTER_PASSWORD_<SLUG> for the others.
+func masterPasswordVariable(tenant models.Tenant) string {
+	if tenant.Slug == models.DefaultTenantSlug {
+		return "MASTER_PASSWORD"
+	}
+	return config.MasterPasswordEnv(tenant.Slug)
+}
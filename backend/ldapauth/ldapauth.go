@@ -0,0 +1,229 @@
+// Package ldapauth authenticates users against an LDAP directory, such as
+// OpenLDAP or Active Directory, by binding with their own credentials.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"document-manager/config"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Config describes how to find and authenticate users in the directory.
+type Config struct {
+	URL string
+	// BindDN and BindPassword are the service account used to search for
+	// users. Both empty means an anonymous search.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the user, every %s is replaced by the escaped login.
+	UserFilter string
+	StartTLS   bool
+	TLSConfig  *tls.Config
+
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+	// MasterGroup, when set, makes members of the group master users and
+	// everybody else regular users. It is compared with the values of
+	// GroupAttribute, usually group DNs.
+	MasterGroup string
+
+	Timeout time.Duration
+}
+
+// Entry is the directory user who authenticated.
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// Authenticator checks credentials against the directory.
+type Authenticator struct {
+	config Config
+}
+
+// ErrInvalidCredentials is returned when the user does not exist, is not
+// unique or the password is wrong.
+var ErrInvalidCredentials = errors.New("ldapauth: invalid credentials")
+
+// New returns an authenticator, filling in the defaults of an OpenLDAP
+// directory for the attributes that are not set.
+func New(config Config) (*Authenticator, error) {
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("ldapauth: URL and base DN are required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(|(uid=%s)(mail=%s))"
+	}
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = "uid"
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 10
+	}
+	return &Authenticator{config: config}, nil
+}
+
+// ID identifies the directory, it is stored with the users it created.
+func (a *Authenticator) ID() string {
+	return "ldap:" + a.config.URL + "/" + a.config.BaseDN
+}
+
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.config.Timeout}),
+		ldap.DialWithTLSConfig(a.config.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS {
+		tlsConfig := a.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			if u, err := url.Parse(a.config.URL); err == nil {
+				tlsConfig = tlsConfig.Clone()
+				tlsConfig.ServerName = u.Hostname()
+			}
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate finds the user matching login and binds as that user with
+// the password.
+func (a *Authenticator) Authenticate(login string, password string) (*Entry, error) {
+	// an empty password would be an unauthenticated bind, which many
+	// directories accept as anonymous
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		err = conn.Bind(a.config.BindDN, a.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldapauth: service bind: %w", err)
+	}
+
+	escaped := ldap.EscapeFilter(login)
+	filter := strings.ReplaceAll(a.config.UserFilter, "%s", escaped)
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.config.Timeout.Seconds()), false,
+		filter,
+		[]string{a.config.UsernameAttribute, a.config.EmailAttribute, a.config.GroupAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldapauth: search: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldapauth: user bind: %w", err)
+	}
+
+	return &Entry{
+		DN:       found.DN,
+		Username: found.GetAttributeValue(a.config.UsernameAttribute),
+		Email:    found.GetAttributeValue(a.config.EmailAttribute),
+		Groups:   found.GetAttributeValues(a.config.GroupAttribute),
+	}, nil
+}
+
+// MasterFromGroups maps the groups of the entry to the master flag. The
+// second result is false when no master group is configured, in which case
+// the flag of the user must be left alone.
+func (a *Authenticator) MasterFromGroups(entry *Entry) (bool, bool) {
+	if a.config.MasterGroup == "" {
+		return false, false
+	}
+	for _, group := range entry.Groups {
+		if strings.EqualFold(group, a.config.MasterGroup) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+var authenticator *Authenticator
+
+// InitAuthenticator configures LDAP authentication with the settings of the
+// configuration. It returns nil when it is disabled.
+func InitAuthenticator(cfg config.LDAPConfig) (*Authenticator, error) {
+	if cfg.URL == "" {
+		log.Println("ldap.url is not set, LDAP authentication is disabled")
+		return nil, nil
+	}
+
+	directory := Config{
+		URL:               cfg.URL,
+		BindDN:            cfg.BindDN,
+		BindPassword:      cfg.BindPassword,
+		BaseDN:            cfg.BaseDN,
+		UserFilter:        cfg.UserFilter,
+		StartTLS:          cfg.StartTLS,
+		UsernameAttribute: cfg.UsernameAttribute,
+		EmailAttribute:    cfg.EmailAttribute,
+		GroupAttribute:    cfg.GroupAttribute,
+		MasterGroup:       cfg.MasterGroup,
+	}
+	if cfg.TLSSkipVerify {
+		directory.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	a, err := New(directory)
+	if err != nil {
+		return nil, err
+	}
+	authenticator = a
+	return authenticator, nil
+}
+
+// GetAuthenticator returns the configured directory, or nil when LDAP authentication is disabled.
+func GetAuthenticator() *Authenticator {
+	return authenticator
+}
+
+// SetAuthenticator replaces the directory, mainly for tests.
+func SetAuthenticator(a *Authenticator) {
+	authenticator = a
+}
@@ -0,0 +1,111 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"document-manager/ldapauth/ldaptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const masterGroup = "cn=admins,ou=groups,dc=example,dc=com"
+
+func newTestDirectory(t *testing.T) *ldaptest.Server {
+	server := ldaptest.NewServer(
+		ldaptest.Entry{DN: "cn=service,dc=example,dc=com", Password: "service"},
+		ldaptest.Entry{
+			DN:       "uid=jane,ou=people,dc=example,dc=com",
+			Password: "jane-password",
+			Attributes: map[string][]string{
+				"uid":      {"jane"},
+				"mail":     {"jane@example.com"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com", masterGroup},
+			},
+		},
+		ldaptest.Entry{
+			DN:       "uid=john,ou=people,dc=example,dc=com",
+			Password: "john-password",
+			Attributes: map[string][]string{
+				"uid":  {"john"},
+				"mail": {"john@example.com"},
+			},
+		},
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAuthenticator(t *testing.T, server *ldaptest.Server) *Authenticator {
+	authenticator, err := New(Config{
+		URL:          server.URL,
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		MasterGroup:  masterGroup,
+	})
+	assert.Nil(t, err)
+	return authenticator
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newTestDirectory(t)
+	authenticator := newTestAuthenticator(t, server)
+
+	entry, err := authenticator.Authenticate("jane", "jane-password")
+	assert.Nil(t, err)
+	assert.Equal(t, "uid=jane,ou=people,dc=example,dc=com", entry.DN)
+	assert.Equal(t, "jane", entry.Username)
+	assert.Equal(t, "jane@example.com", entry.Email)
+
+	isMaster, mapped := authenticator.MasterFromGroups(entry)
+	assert.True(t, isMaster)
+	assert.True(t, mapped)
+
+	// the email works as login too
+	entry, err = authenticator.Authenticate("john@example.com", "john-password")
+	assert.Nil(t, err)
+	assert.Equal(t, "john", entry.Username)
+
+	isMaster, mapped = authenticator.MasterFromGroups(entry)
+	assert.False(t, isMaster)
+	assert.True(t, mapped)
+}
+
+func TestAuthenticateRejectsInvalidCredentials(t *testing.T) {
+	server := newTestDirectory(t)
+	authenticator := newTestAuthenticator(t, server)
+
+	_, err := authenticator.Authenticate("jane", "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = authenticator.Authenticate("nobody", "jane-password")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	// no anonymous bind with an empty password
+	_, err = authenticator.Authenticate("jane", "")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	// the login cannot widen the filter
+	_, err = authenticator.Authenticate("*", "jane-password")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	server := newTestDirectory(t)
+	server.SetRequireTLS(true)
+
+	authenticator := newTestAuthenticator(t, server)
+	_, err := authenticator.Authenticate("jane", "jane-password")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrInvalidCredentials, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	authenticator.config.StartTLS = true
+	authenticator.config.TLSConfig = &tls.Config{RootCAs: roots}
+
+	entry, err := authenticator.Authenticate("jane", "jane-password")
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", entry.Username)
+}
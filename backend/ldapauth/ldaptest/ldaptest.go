@@ -0,0 +1,433 @@
+// Package ldaptest provides an in-process LDAP directory for tests. It
+// supports simple binds, StartTLS and searches with the common filters, which
+// is what a bind-based login needs.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const (
+	applicationBindRequest       = 0
+	applicationBindResponse      = 1
+	applicationUnbindRequest     = 2
+	applicationSearchRequest     = 3
+	applicationSearchResultEntry = 4
+	applicationSearchResultDone  = 5
+	applicationExtendedRequest   = 23
+	applicationExtendedResponse  = 24
+
+	resultSuccess                  = 0
+	resultProtocolError            = 2
+	resultConfidentialityRequired  = 13
+	resultNoSuchObject             = 32
+	resultInvalidCredentials       = 49
+	resultInsufficientAccessRights = 50
+	resultUnwillingToPerform       = 53
+
+	searchScopeBaseObject  = 0
+	searchScopeSingleLevel = 1
+
+	filterAnd           = 0
+	filterOr            = 1
+	filterNot           = 2
+	filterEqualityMatch = 3
+	filterSubstrings    = 4
+	filterPresent       = 7
+
+	substringInitial = 0
+	substringAny     = 1
+	substringFinal   = 2
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// Entry is an object of the directory. Entries with a password can bind.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is an LDAP directory listening on a local port. Its address is
+// Server.URL, for example ldap://127.0.0.1:40123.
+type Server struct {
+	URL string
+
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	certificate *x509.Certificate
+
+	mu         sync.Mutex
+	entries    []Entry
+	binds      int
+	requireTLS bool
+}
+
+// NewServer starts a directory with the given entries.
+func NewServer(entries ...Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	certificate, tlsCertificate := newCertificate()
+	s := &Server{
+		URL:         "ldap://" + listener.Addr().String(),
+		listener:    listener,
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{tlsCertificate}},
+		certificate: certificate,
+		entries:     entries,
+	}
+	go s.serve()
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+}
+
+// Certificate returns the self-signed certificate presented after StartTLS.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.certificate
+}
+
+// AddEntry adds an entry to the directory.
+func (s *Server) AddEntry(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+}
+
+// SetRequireTLS makes the server refuse binds and searches before StartTLS.
+func (s *Server) SetRequireTLS(require bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireTLS = require
+}
+
+func (s *Server) tlsRequired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requireTLS
+}
+
+// Binds returns the number of successful binds, service binds included.
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds
+}
+
+func newCertificate() (*x509.Certificate, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return certificate, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: certificate}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	secure := false
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case applicationBindRequest:
+			code := s.bind(request, secure)
+			bound = code == resultSuccess
+			s.write(conn, messageID, applicationBindResponse, code, "")
+		case applicationUnbindRequest:
+			return
+		case applicationSearchRequest:
+			if !secure && s.tlsRequired() {
+				s.write(conn, messageID, applicationSearchResultDone, resultConfidentialityRequired, "StartTLS is required")
+				continue
+			}
+			if !bound {
+				s.write(conn, messageID, applicationSearchResultDone, resultInsufficientAccessRights, "bind first")
+				continue
+			}
+			s.search(conn, messageID, request)
+		case applicationExtendedRequest:
+			if len(request.Children) == 0 || request.Children[0].Data.String() != startTLSOID || secure {
+				s.write(conn, messageID, applicationExtendedResponse, resultProtocolError, "unsupported extended operation")
+				continue
+			}
+			s.write(conn, messageID, applicationExtendedResponse, resultSuccess, "")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			secure = true
+		default:
+			s.write(conn, messageID, applicationExtendedResponse, resultUnwillingToPerform, "unsupported operation")
+		}
+	}
+}
+
+func (s *Server) write(conn net.Conn, messageID interface{}, tag ber.Tag, code int64, message string) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "diagnosticMessage"))
+	packet.AppendChild(response)
+	conn.Write(packet.Bytes())
+}
+
+// bind checks a simple bind. Anonymous binds are allowed, unauthenticated
+// binds (a name without a password) are refused.
+func (s *Server) bind(request *ber.Packet, secure bool) int64 {
+	if len(request.Children) < 3 {
+		return resultProtocolError
+	}
+	dn, _ := request.Children[1].Value.(string)
+	password := request.Children[2].Data.String()
+
+	if dn == "" && password == "" {
+		return resultSuccess
+	}
+	if !secure && s.tlsRequired() {
+		return resultConfidentialityRequired
+	}
+	if password == "" {
+		return resultUnwillingToPerform
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			s.binds++
+			return resultSuccess
+		}
+	}
+	return resultInvalidCredentials
+}
+
+func (s *Server) search(conn net.Conn, messageID interface{}, request *ber.Packet) {
+	if len(request.Children) < 8 {
+		s.write(conn, messageID, applicationSearchResultDone, resultProtocolError, "invalid search request")
+		return
+	}
+	baseDN := strings.ToLower(request.Children[0].Value.(string))
+	scope := request.Children[1].Value.(int64)
+	filter := request.Children[6]
+
+	var requested []string
+	for _, attribute := range request.Children[7].Children {
+		requested = append(requested, attribute.Value.(string))
+	}
+
+	s.mu.Lock()
+	entries := append([]Entry(nil), s.entries...)
+	s.mu.Unlock()
+
+	found := false
+	for _, entry := range entries {
+		dn := strings.ToLower(entry.DN)
+		if dn == baseDN {
+			found = true
+		}
+		if !inScope(dn, baseDN, scope) || !matches(entry, filter) {
+			continue
+		}
+		found = true
+
+		packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, applicationSearchResultEntry, nil, "Search Result Entry")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "objectName"))
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+		for name, values := range entry.Attributes {
+			if !wanted(name, requested) {
+				continue
+			}
+			attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+			}
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+		result.AppendChild(attributes)
+		packet.AppendChild(result)
+		conn.Write(packet.Bytes())
+	}
+
+	// a base that is not an entry itself is fine as long as it has children
+	if !found {
+		for _, entry := range entries {
+			if strings.HasSuffix(strings.ToLower(entry.DN), ","+baseDN) {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		s.write(conn, messageID, applicationSearchResultDone, resultNoSuchObject, "no such object")
+		return
+	}
+	s.write(conn, messageID, applicationSearchResultDone, resultSuccess, "")
+}
+
+func inScope(dn string, baseDN string, scope int64) bool {
+	switch scope {
+	case searchScopeBaseObject:
+		return dn == baseDN
+	case searchScopeSingleLevel:
+		if !strings.HasSuffix(dn, ","+baseDN) {
+			return false
+		}
+		return !strings.Contains(strings.TrimSuffix(dn, ","+baseDN), ",")
+	default:
+		return dn == baseDN || strings.HasSuffix(dn, ","+baseDN)
+	}
+}
+
+func wanted(name string, requested []string) bool {
+	if len(requested) == 0 {
+		return true
+	}
+	for _, attribute := range requested {
+		if attribute == "*" || strings.EqualFold(attribute, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func values(entry Entry, name string) []string {
+	for attribute, values := range entry.Attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// matches evaluates a search filter against an entry. Values are compared
+// without case, like most directory attributes.
+func matches(entry Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case filterEqualityMatch:
+		name := filter.Children[0].Data.String()
+		expected := filter.Children[1].Data.String()
+		if strings.EqualFold(name, "objectClass") && strings.EqualFold(expected, "*") {
+			return true
+		}
+		for _, value := range values(entry, name) {
+			if strings.EqualFold(value, expected) {
+				return true
+			}
+		}
+		return false
+	case filterSubstrings:
+		name := filter.Children[0].Data.String()
+		for _, value := range values(entry, name) {
+			if matchesSubstrings(strings.ToLower(value), filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return strings.EqualFold(filter.Data.String(), "objectClass") || len(values(entry, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func matchesSubstrings(value string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		substring := strings.ToLower(part.Data.String())
+		switch part.Tag {
+		case substringInitial:
+			if !strings.HasPrefix(value, substring) {
+				return false
+			}
+			value = value[len(substring):]
+		case substringAny:
+			index := strings.Index(value, substring)
+			if index < 0 {
+				return false
+			}
+			value = value[index+len(substring):]
+		case substringFinal:
+			if !strings.HasSuffix(value, substring) {
+				return false
+			}
+		}
+	}
+	return true
+}
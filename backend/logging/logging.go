@@ -0,0 +1,144 @@
+// Package logging writes the logs of the server as JSON lines with log/slog.
+// The lines logged with the context of a request carry its request ID, the
+// user who made it and its trace, and the secrets they name are redacted.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redacted replaces the value of the secrets in the logs.
+const Redacted = "********"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+)
+
+// secretWords are the words of the keys whose values are never logged.
+var secretWords = []string{"password", "token", "secret", "authorization", "cookie", "api_key", "apikey", "api-key"}
+
+// secretParameters are the query parameters of the single sign-on callback,
+// which would let whoever reads the log complete the login.
+var secretParameters = []string{"code", "state", "nonce", "verifier"}
+
+// Init makes the JSON logger of level, debug, info, warn or error, the
+// default one, to which the log package writes too.
+func Init(level string) *slog.Logger {
+	logger := New(os.Stdout, level)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New returns a logger writing JSON lines of level and above to w. Unknown
+// levels log from info.
+func New(w io.Writer, level string) *slog.Logger {
+	var minimum slog.Level
+	if err := minimum.UnmarshalText([]byte(level)); err != nil {
+		minimum = slog.LevelInfo
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: minimum, ReplaceAttr: redact})
+	return slog.New(contextHandler{handler})
+}
+
+// WithRequestID returns ctx carrying the ID of its request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID of ctx, empty outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUserID returns ctx carrying the ID of the authenticated user.
+func WithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// IsSecret reports whether the value of key, an attribute, a header or a
+// query parameter, must not be logged.
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range secretWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	for _, parameter := range secretParameters {
+		if key == parameter {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactQuery encodes the query with the values of its secret parameters
+// redacted.
+func RedactQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		for _, value := range query[key] {
+			value = url.QueryEscape(value)
+			if IsSecret(key) {
+				value = Redacted
+			}
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(key) + "=" + value)
+		}
+	}
+	return b.String()
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindGroup && IsSecret(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// contextHandler adds the request ID, the user and the trace of the context
+// to the records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if id, ok := ctx.Value(userIDKey).(string); ok {
+		record.AddAttrs(slog.String("user_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
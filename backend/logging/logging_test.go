@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestContext(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, "info")
+	ctx := WithUserID(WithRequestID(context.Background(), "request-1"), "user-1")
+
+	logger.With("component", "test").InfoContext(ctx, "request", "status", 200)
+	var line map[string]any
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "request", line["msg"])
+	assert.Equal(t, "request-1", line["request_id"])
+	assert.Equal(t, "user-1", line["user_id"])
+	assert.Equal(t, "test", line["component"])
+
+	out.Reset()
+	logger.Debug("hidden")
+	assert.Empty(t, out.String())
+	New(&out, "debug").Debug("shown")
+	assert.Contains(t, out.String(), "shown")
+	assert.NotContains(t, out.String(), "request_id")
+}
+
+func TestRedaction(t *testing.T) {
+	var out bytes.Buffer
+	New(&out, "info").Info("login", "password", "hunter2", "Authorization", "Bearer abc", "refresh_token", "def", "email", "user@example.com")
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.NotContains(t, out.String(), "abc")
+	assert.NotContains(t, out.String(), "def")
+	assert.Contains(t, out.String(), "user@example.com")
+
+	query := url.Values{"code": {"secret-code"}, "state": {"xyz"}, "page": {"2"}, "token": {"t"}}
+	assert.Equal(t, "code=********&page=2&state=********&token=********", RedactQuery(query))
+	assert.True(t, IsSecret("X-API-Key"))
+	assert.False(t, IsSecret("status"))
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"document-manager/config"
+	"log"
+)
+
+// Message is a plain text email ready to be delivered.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes the recipients and subjects of messages to the server log
+// instead of delivering them. It is used when no SMTP server is configured.
+// The bodies are not logged: their links carry tokens that log in.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("mailer: to=%v subject=%q", msg.To, msg.Subject)
+	return nil
+}
+
+var mailer Mailer = LogMailer{}
+
+// InitMailer configures the mailer from cfg. When no SMTP server is
+// configured the LogMailer is kept.
+func InitMailer(cfg config.MailConfig) Mailer {
+	if cfg.SMTPHost == "" {
+		log.Println("SMTP_HOST is not set, emails will be written to the log")
+		mailer = LogMailer{}
+		return mailer
+	}
+
+	mailer = &SMTPMailer{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.From,
+	}
+	return mailer
+}
+
+// GetMailer returns the mailer used by the handlers.
+func GetMailer() Mailer {
+	return mailer
+}
+
+// SetMailer replaces the mailer used by the handlers, mainly for tests.
+func SetMailer(m Mailer) {
+	mailer = m
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpSink is a minimal SMTP server that accepts every message and records it.
+type smtpSink struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error starting SMTP sink:", err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan string, 10)}
+	go sink.serve()
+	t.Cleanup(func() { listener.Close() })
+	return sink
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	write("220 localhost sink")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			write("250-localhost")
+			write("250 8BITMIME")
+		case strings.HasPrefix(command, "DATA"):
+			write("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.messages <- data.String()
+			write("250 OK")
+		case strings.HasPrefix(command, "QUIT"):
+			write("221 bye")
+			return
+		default:
+			write("250 OK")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	host, port, err := net.SplitHostPort(sink.listener.Addr().String())
+	assert.Nil(t, err)
+
+	m := &SMTPMailer{Host: host, Port: port, From: "no-reply@example.com"}
+	err = m.Send(Message{
+		To:      []string{"user@example.com"},
+		Subject: "Hello",
+		Body:    "first line\nsecond line\n",
+	})
+	assert.Nil(t, err)
+
+	data := <-sink.messages
+	assert.Contains(t, data, "From: no-reply@example.com\r\n")
+	assert.Contains(t, data, "To: user@example.com\r\n")
+	assert.Contains(t, data, "Subject: Hello\r\n")
+	assert.Contains(t, data, "\r\n\r\nfirst line\r\nsecond line\r\n")
+}
+
+func TestSMTPMailerSendWithoutRecipients(t *testing.T) {
+	m := &SMTPMailer{Host: "127.0.0.1", Port: "25", From: "no-reply@example.com"}
+	err := m.Send(Message{Subject: "Hello"})
+	assert.NotNil(t, err)
+}
+
+func TestNewMessage(t *testing.T) {
+	data := map[string]string{
+		"Name":      "john",
+		"Link":      "http://localhost:3000/reset-password?token=abc",
+		"ExpiresIn": "1 hour",
+	}
+
+	msg, err := NewMessage("john@example.com", TemplatePasswordReset, data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"john@example.com"}, msg.To)
+	assert.Equal(t, "Reset your password", msg.Subject)
+	assert.Contains(t, msg.Body, "Hello john,")
+	assert.Contains(t, msg.Body, data["Link"])
+	assert.Contains(t, msg.Body, "1 hour")
+
+	msg, err = NewMessage("john@example.com", TemplateVerifyEmail, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "Confirm your email address", msg.Subject)
+
+	_, err = NewMessage("john@example.com", "unknown", data)
+	assert.NotNil(t, err)
+}
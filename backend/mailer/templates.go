@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+// Template names available to NewMessage.
+const (
+	TemplateVerifyEmail         = "verify_email"
+	TemplatePasswordReset       = "password_reset"
+	TemplateWorkspaceInvitation = "workspace_invitation"
+	TemplateRegistrationInvite  = "registration_invite"
+	TemplateAccountApproved     = "account_approved"
+)
+
+// NewMessage renders the "<name>.subject" and "<name>.body" templates with
+// data and returns a message addressed to "to".
+func NewMessage(to, name string, data any) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := templates.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := templates.ExecuteTemplate(&body, name+".body", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      []string{to},
+		Subject: subject.String(),
+		Body:    body.String(),
+	}, nil
+}
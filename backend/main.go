@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
 	"document-manager/api"
-	"document-manager/api/models"
+	"document-manager/config"
 	"document-manager/database"
 	_ "document-manager/docs"
-	"fmt"
+	"document-manager/ldapauth"
+	"document-manager/logging"
+	"document-manager/mailer"
+	"document-manager/metrics"
+	"document-manager/passwords"
+	"document-manager/ratelimit"
+	"document-manager/sso"
+	"document-manager/tracing"
 	"log"
+	"log/slog"
+	"os"
 )
 
 // @title Document manager API
@@ -27,41 +37,124 @@ import (
 // @in header
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
+// @securityDefinitions.apikey ApiKey
+// @in header
+// @name X-API-Key
+// @description Personal API key, accepted by the routes its scopes allow.
 func main() {
 
+	// Load the configuration from the configuration file, the environment and the flags
+	cfg, err := config.Init(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	// Log JSON lines from the configured level, the log package included
+	logging.Init(cfg.Log.Level)
+
+	// Export the spans of the requests, the queries and the stored files, if configured
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Error configuring tracing: %v", err)
+	}
+
 	// Initialize the database connection
 	db, err := database.InitDB()
 	if err != nil {
 		log.Fatalf("Error configuring database connection: %v", err)
 	}
-	// Ensure the connection is closed when main exits
-	// defer db.Close()
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatalf("Error tracing the database: %v", err)
+	}
+
+	// Run a command given after the flags, such as migrate, instead of the server
+	if args := config.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("Unknown command %q, the only command is migrate", args[0])
+		}
+		if err := runMigrate(args[1:]); err != nil {
+			log.Fatalf("Error migrating the database: %v", err)
+		}
+		return
+	}
 
-	// Run automatic migration for the 'users' table
-	err = db.AutoMigrate(&models.User{})
+	// Apply the pending schema migrations, or check there are none
+	if cfg.Database.AutoMigrate {
+		applied, err := database.Migrate()
+		if err != nil {
+			log.Fatalf("Error migrating the database: %v", err)
+		}
+		for _, migration := range applied {
+			slog.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+		}
+	} else if err := checkMigrations(); err != nil {
+		log.Fatalf("Error checking the database schema: %v", err)
+	}
+
+	// Create the tenants of the configuration
+	err = database.InitTenants(cfg.Tenants)
 	if err != nil {
-		log.Fatalf("Error creating 'users' table: %v", err)
+		log.Fatalf("Error creating tenants: %v", err)
 	}
 
-	// Initialize the master user
-	err = database.InitMasterUser()
+	// Create the built-in roles of every tenant
+	err = database.InitRoles()
+	if err != nil {
+		log.Fatalf("Error creating built-in roles: %v", err)
+	}
+
+	// Initialize the master user of the tenants without users
+	err = database.InitMasterUser(cfg.Tenants)
 	if err != nil {
 		log.Fatalf("Error creating initial master user: %v", err)
 	}
 
-	// Run automatic migration for the 'documents' table
-	err = db.AutoMigrate(&models.Document{})
+	// Record the size of the files uploaded before storage was tracked
+	err = database.InitDocumentSizes()
 	if err != nil {
-		log.Fatalf("Error creating 'documents' table: %v", err)
+		log.Fatalf("Error recording document sizes: %v", err)
+	}
+
+	// Configure the mailer used for verification and password reset emails
+	mailer.InitMailer(cfg.Mail)
+
+	// Discover the identity provider used for single sign-on, if any
+	if _, err := sso.InitProvider(context.Background(), cfg.SSO); err != nil {
+		log.Printf("Error configuring single sign-on, it is disabled: %v", err)
+	}
+
+	// Configure the LDAP directory used to log in users without a local password, if any
+	if _, err := ldapauth.InitAuthenticator(cfg.LDAP); err != nil {
+		log.Fatalf("Error configuring LDAP authentication: %v", err)
+	}
+
+	// Check new passwords against the local breached password list, if any
+	passwords.InitBreachList(cfg.Passwords)
+
+	// Configure the rate limits of the authentication endpoints, shared through Redis if configured
+	if err := ratelimit.Init(cfg.RateLimit); err != nil {
+		log.Fatalf("Error connecting to the rate limit store: %v", err)
+	}
+
+	// Time the database queries for the Prometheus metrics
+	if cfg.Metrics.Enabled {
+		if err := metrics.InstrumentDB(db); err != nil {
+			log.Fatalf("Error instrumenting the database: %v", err)
+		}
 	}
 
 	// Set up and start the router
 	router := api.SetupRouter()
 
-	fmt.Println("Server running on http://localhost:3450")
-	fmt.Println("Swagger UI available at http://localhost:3450/api/swagger/index.html")
+	scheme := "http://"
+	if cfg.TLS.Enabled() {
+		scheme = "https://"
+	}
+	slog.Info("Server running", "url", scheme+cfg.Server.Address, "swagger", scheme+cfg.Server.Address+"/api/swagger/index.html")
 
-	if err := router.Run("0.0.0.0:3450"); err != nil {
-		log.Fatalf("Error starting the server: %v", err)
+	// Serve until stopped, then drain the requests in flight and close the database
+	if err := serve(cfg, router, shutdownTracing); err != nil {
+		log.Fatalf("Error running the server: %v", err)
 	}
+	slog.Info("Server stopped")
 }
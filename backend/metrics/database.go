@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/database"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const queryStartKey = "metrics:start"
+
+// InstrumentDB times the queries of db and reports the documents, users and
+// storage of its tenants at each scrape.
+func InstrumentDB(db *gorm.DB) error {
+	callbacks := db.Callback()
+	err := errors.Join(
+		callbacks.Create().Before("gorm:create").Register("metrics:before_create", startQuery),
+		callbacks.Create().After("gorm:create").Register("metrics:after_create", endQuery("create")),
+		callbacks.Query().Before("gorm:query").Register("metrics:before_query", startQuery),
+		callbacks.Query().After("gorm:query").Register("metrics:after_query", endQuery("query")),
+		callbacks.Update().Before("gorm:update").Register("metrics:before_update", startQuery),
+		callbacks.Update().After("gorm:update").Register("metrics:after_update", endQuery("update")),
+		callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", startQuery),
+		callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", endQuery("delete")),
+		callbacks.Row().Before("gorm:row").Register("metrics:before_row", startQuery),
+		callbacks.Row().After("gorm:row").Register("metrics:after_row", endQuery("row")),
+		callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", startQuery),
+		callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", endQuery("raw")),
+	)
+	if err != nil {
+		return err
+	}
+	return Registry.Register(&databaseCollector{db: db})
+}
+
+func startQuery(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func endQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if start, ok := db.InstanceGet(queryStartKey); ok {
+			QueryDuration.WithLabelValues(operation).Observe(time.Since(start.(time.Time)).Seconds())
+		}
+	}
+}
+
+var (
+	documentsDesc = prometheus.NewDesc(namespace+"_documents", "Documents stored, by tenant.", []string{"tenant"}, nil)
+	storageDesc   = prometheus.NewDesc(namespace+"_storage_used_bytes", "Bytes of the document files stored, by tenant.", []string{"tenant"}, nil)
+	usersDesc     = prometheus.NewDesc(namespace+"_users", "Users, by tenant and status.", []string{"tenant", "status"}, nil)
+)
+
+// scrapeTimeout bounds the queries of a scrape.
+const scrapeTimeout = 10 * time.Second
+
+// databaseCollector counts the documents, the users and the bytes stored
+// when the metrics are scraped.
+type databaseCollector struct {
+	db *gorm.DB
+}
+
+func (d *databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- documentsDesc
+	ch <- storageDesc
+	ch <- usersDesc
+}
+
+func (d *databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(database.AllTenants(context.Background()), scrapeTimeout)
+	defer cancel()
+	db := d.db.WithContext(ctx)
+
+	var tenants []models.Tenant
+	if err := db.Find(&tenants).Error; err != nil {
+		for _, desc := range []*prometheus.Desc{documentsDesc, storageDesc, usersDesc} {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+		}
+		return
+	}
+	slugs := make(map[uuid.UUID]string, len(tenants))
+	for _, tenant := range tenants {
+		slugs[tenant.ID] = tenant.Slug
+	}
+
+	var documents []struct {
+		TenantID uuid.UUID
+		Count    int64
+		Bytes    int64
+	}
+	err := db.Model(&models.Document{}).Select("tenant_id, count(*) AS count, coalesce(sum(file_size), 0) AS bytes").Group("tenant_id").Scan(&documents).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(documentsDesc, err)
+		ch <- prometheus.NewInvalidMetric(storageDesc, err)
+	} else {
+		for _, row := range documents {
+			ch <- prometheus.MustNewConstMetric(documentsDesc, prometheus.GaugeValue, float64(row.Count), slugs[row.TenantID])
+			ch <- prometheus.MustNewConstMetric(storageDesc, prometheus.GaugeValue, float64(row.Bytes), slugs[row.TenantID])
+		}
+	}
+
+	var users []struct {
+		TenantID uuid.UUID
+		Status   string
+		Count    int64
+	}
+	err = db.Model(&models.User{}).Select("tenant_id, status, count(*) AS count").Group("tenant_id, status").Scan(&users).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(usersDesc, err)
+		return
+	}
+	for _, row := range users {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(row.Count), slugs[row.TenantID], row.Status)
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute labels the requests to no route, whose paths would make a
+// series each.
+const unmatchedRoute = "unmatched"
+
+// Middleware counts the requests and times them, by the route they matched
+// rather than their path.
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+	HTTPDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+}
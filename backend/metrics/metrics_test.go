@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/config"
+	"document-manager/database"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain runs the tests on a throwaway SQLite database, unless DB_DRIVER
+// chooses one.
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_DRIVER") != "" {
+		os.Exit(m.Run())
+	}
+	directory, err := os.MkdirTemp("", "document-manager-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DB_DRIVER", config.DriverSQLite)
+	os.Setenv("DB_PATH", filepath.Join(directory, "test.db"))
+	code := m.Run()
+	os.RemoveAll(directory)
+	os.Exit(code)
+}
+
+func scrape(t *testing.T, handler http.Handler, token string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", "/metrics", nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, request)
+	return resp
+}
+
+func TestHandlerToken(t *testing.T) {
+	handler := Handler("scrape-token")
+	assert.Equal(t, http.StatusUnauthorized, scrape(t, handler, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, scrape(t, handler, "wrong").Code)
+	resp := scrape(t, handler, "scrape-token")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "go_goroutines")
+
+	assert.Equal(t, http.StatusOK, scrape(t, Handler(""), "").Code)
+}
+
+func TestMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(Middleware)
+	router.GET("/api/documents/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	matched := HTTPRequests.WithLabelValues("GET", "/api/documents/:id", "204")
+	unmatched := HTTPRequests.WithLabelValues("GET", unmatchedRoute, "404")
+	matchedBefore, unmatchedBefore := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched)
+	for _, path := range []string{"/api/documents/1", "/api/documents/2", "/missing"} {
+		request, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	// a series by route, whatever the path
+	assert.Equal(t, matchedBefore+2, testutil.ToFloat64(matched))
+	assert.Equal(t, unmatchedBefore+1, testutil.ToFloat64(unmatched))
+
+	failures := Logins.WithLabelValues(LoginPassword, "failure")
+	failuresBefore := testutil.ToFloat64(failures)
+	RecordLogin(LoginPassword, false)
+	assert.Equal(t, failuresBefore+1, testutil.ToFloat64(failures))
+}
+
+func TestDatabaseMetrics(t *testing.T) {
+	db, err := database.InitDB()
+	assert.Nil(t, err)
+	_, err = database.Migrate()
+	assert.Nil(t, err)
+	assert.Nil(t, database.InitTenants(config.Get().Tenants))
+	assert.Nil(t, database.InitRoles())
+	assert.Nil(t, database.InitMasterUser(config.Get().Tenants))
+	assert.Nil(t, InstrumentDB(db))
+	var tenants []models.Tenant
+	assert.Nil(t, db.WithContext(database.AllTenants(context.Background())).Find(&tenants).Error)
+
+	resp := scrape(t, Handler(""), "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	body := resp.Body.String()
+	assert.True(t, strings.Contains(body, `document_manager_users{status="active",tenant="default"} 1`), "the master user is counted")
+	assert.True(t, strings.Contains(body, `document_manager_db_query_duration_seconds_count{operation="query"}`), "the queries are timed")
+	assert.False(t, strings.Contains(body, "document_manager_documents{"), "no documents are stored")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"document-manager/database"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// runMigrate runs the migrate command: status lists the migrations, up
+// applies the pending ones, down rolls back the last one or the given number
+// of them, and to applies or rolls back migrations up to a version.
+func runMigrate(args []string) error {
+	migrator, err := database.GetMigrator()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command: status, up, down [steps] or to <version>")
+	}
+
+	var done []database.Migration
+	switch command := args[0]; {
+	case command == "status" && len(args) == 1:
+		return printMigrationStatus(migrator)
+	case command == "up" && len(args) == 1:
+		done, err = migrator.Up()
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("the steps of migrate down must be a number, got %q", args[1])
+			}
+		}
+		done, err = migrator.Down(steps)
+	case command == "to" && len(args) == 2:
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("the version of migrate to must be a number, got %q", args[1])
+		}
+		done, err = migrator.To(version)
+	default:
+		return fmt.Errorf("unknown migrate command %q, use status, up, down [steps] or to <version>", strings.Join(args, " "))
+	}
+
+	for _, migration := range done {
+		fmt.Printf("%d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Println("Nothing to migrate")
+	}
+	return nil
+}
+
+func printMigrationStatus(migrator *database.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 -0700")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// checkMigrations fails when the schema has pending migrations, which the
+// server started without DB_AUTO_MIGRATE cannot work without.
+func checkMigrations() error {
+	migrator, err := database.GetMigrator()
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d, apply them with the migrate up command", database.ErrPendingMigrations, len(pending))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"document-manager/config"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList tells whether a password appeared in a data breach.
+// Implementations must be safe for concurrent use.
+type BreachList interface {
+	Contains(password string) (bool, error)
+}
+
+// RangeDirectory is a local copy of a breached password list split like the
+// Pwned Passwords range API: the file named after the first five hex digits
+// of the SHA-1 hash of a password lists the remaining 35 digits of the hashes
+// starting with them, one per line, optionally followed by ":" and a count.
+// Only the file of the prefix of the hash is read.
+type RangeDirectory string
+
+func (d RangeDirectory) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	file, err := os.Open(filepath.Join(string(d), hash[:5]))
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = os.Open(filepath.Join(string(d), hash[:5]+".txt"))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(suffix), hash[5:]) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+var breachList BreachList
+
+// InitBreachList uses the range directory of the configuration. Without it,
+// passwords are not checked against a breach list.
+func InitBreachList(cfg config.PasswordsConfig) BreachList {
+	if cfg.BreachedDir == "" {
+		breachList = nil
+		return nil
+	}
+	breachList = RangeDirectory(cfg.BreachedDir)
+	return breachList
+}
+
+// SetBreachList replaces the breach list, mainly for tests.
+func SetBreachList(list BreachList) {
+	breachList = list
+}
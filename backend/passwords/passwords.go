@@ -0,0 +1,119 @@
+// Package passwords checks new passwords against a password policy and a
+// local list of breached passwords.
+package passwords
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Policy is what a new password must satisfy.
+type Policy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	// refused in addition to the most common passwords, whatever the case
+	BannedPasswords []string `json:"banned_passwords"`
+	// number of previous passwords that cannot be used again
+	HistorySize int `json:"history_size"`
+	// days after which the password must be changed, zero for never
+	MaxAgeDays int `json:"max_age_days"`
+	// refuse the passwords of the breach list, when one is configured
+	CheckBreached bool `json:"check_breached"`
+}
+
+// DefaultPolicy returns the policy of the tenants that did not set one.
+func DefaultPolicy() Policy {
+	return Policy{MinLength: 8, HistorySize: 5, CheckBreached: true}
+}
+
+// commonPasswords are refused by every policy.
+var commonPasswords = []string{
+	"password", "password1", "password123", "passw0rd", "12345678", "123456789",
+	"1234567890", "11111111", "00000000", "87654321", "qwertyui", "qwerty123",
+	"qwertyuiop", "1q2w3e4r", "abcdefgh", "abcd1234", "iloveyou", "letmein1",
+	"sunshine", "football", "baseball", "princess", "welcome1", "admin123",
+	"changeme", "copa2026",
+}
+
+// PolicyError lists the rules a password breaks.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Check returns a *PolicyError when password breaks the policy. personal are
+// the name and email of the user, which cannot be the password.
+func (p Policy) Check(password string, personal ...string) error {
+	var violations []string
+	if len([]rune(password)) < max(p.MinLength, 1) {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", max(p.MinLength, 1)))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.isBanned(password) {
+		violations = append(violations, "is too common")
+	}
+	for _, value := range personal {
+		local, _, _ := strings.Cut(value, "@")
+		if value != "" && (strings.EqualFold(password, value) || strings.EqualFold(password, local)) {
+			violations = append(violations, "must not be your name or email")
+			break
+		}
+	}
+
+	if p.CheckBreached && breachList != nil {
+		breached, err := breachList.Contains(password)
+		if err != nil {
+			log.Printf("Error checking the breached password list: %v", err)
+		} else if breached {
+			violations = append(violations, "appeared in a data breach")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (p Policy) isBanned(password string) bool {
+	for _, banned := range append(commonPasswords, p.BannedPasswords...) {
+		if strings.EqualFold(password, strings.TrimSpace(banned)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func violations(err error) []string {
+	if err == nil {
+		return nil
+	}
+	return err.(*PolicyError).Violations
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := DefaultPolicy()
+	assert.Nil(t, policy.Check("correct horse battery", "jane", "jane@example.com"))
+	assert.Equal(t, []string{"must be at least 8 characters long"}, violations(policy.Check("short")))
+	assert.Equal(t, []string{"must be at least 8 characters long"}, violations(policy.Check("")))
+	assert.Equal(t, []string{"is too common"}, violations(policy.Check("PassWord")))
+	assert.Equal(t, []string{"must not be your name or email"}, violations(policy.Check("jane.doe@example.com", "jane", "jane.doe@example.com")))
+	assert.Equal(t, []string{"must not be your name or email"}, violations(policy.Check("Jane.Doe", "jane", "jane.doe@example.com")))
+
+	policy = Policy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true, BannedPasswords: []string{"Document-Manager1"}}
+	assert.Equal(t, []string{
+		"must be at least 10 characters long",
+		"must contain an uppercase letter",
+		"must contain a digit",
+		"must contain a symbol",
+	}, violations(policy.Check("lower")))
+	assert.Nil(t, policy.Check("Upper-lower-1"))
+	assert.Equal(t, []string{"is too common"}, violations(policy.Check("DOCUMENT-manager1")))
+}
+
+func TestRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// the SHA-1 hash of "breached-password" is 0E25372B435EE38A4C248D114B6A4DC6F0DA6FF1
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "0E253"), []byte("0000000000000000000000000000000000A:1\r\n72b435ee38a4c248d114b6a4dc6f0da6ff1:42\n"), 0o600))
+
+	list := RangeDirectory(dir)
+	breached, err := list.Contains("breached-password")
+	assert.Nil(t, err)
+	assert.True(t, breached)
+	breached, err = list.Contains("another-password")
+	assert.Nil(t, err)
+	assert.False(t, breached)
+
+	SetBreachList(list)
+	defer SetBreachList(nil)
+	assert.Equal(t, []string{"appeared in a data breach"}, violations(DefaultPolicy().Check("breached-password")))
+	assert.Nil(t, Policy{MinLength: 8}.Check("breached-password"))
+}
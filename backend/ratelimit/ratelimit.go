@@ -0,0 +1,124 @@
+// Package ratelimit limits the attempts of the clients of the authentication
+// endpoints with token buckets, and locks accounts after repeated failed
+// logins. The state is kept in memory, or in a Redis server shared by every
+// instance of the API.
+package ratelimit
+
+import (
+	"context"
+	"document-manager/config"
+	"time"
+)
+
+// Limit is a token bucket: Burst attempts at once, refilled at PerMinute
+// attempts per minute. A zero PerMinute disables the limit.
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Lockout locks an account after Threshold failed attempts, for Duration
+// doubled by every further failure up to MaxDuration. Failures are forgotten
+// Window after the last one. A zero Threshold disables the lockout.
+type Lockout struct {
+	Threshold   int64
+	Duration    time.Duration
+	MaxDuration time.Duration
+	Window      time.Duration
+}
+
+// For returns how long an account is locked after failures failed attempts.
+func (l Lockout) For(failures int64) time.Duration {
+	if l.Threshold <= 0 || failures < l.Threshold {
+		return 0
+	}
+	lockout := l.Duration
+	for i := l.Threshold; i < failures && lockout < l.MaxDuration; i++ {
+		lockout *= 2
+	}
+	return max(min(lockout, l.MaxDuration), l.Duration)
+}
+
+// Config is what the authentication endpoints allow to each client IP and
+// each account.
+type Config struct {
+	IP      Limit
+	Account Limit
+	Lockout Lockout
+}
+
+// Store keeps the buckets, the failed attempts and the locks. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// Allow takes a token from the bucket key. When it is empty it returns
+	// false and how long until the next token.
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+	// AddFailure counts a failed attempt of key and returns the failures
+	// since the last Reset, forgotten ttl after the last one.
+	AddFailure(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Lock refuses key for d.
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// LockedFor returns how long key is still locked, zero when it is not.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the failed attempts and the lock of key.
+	Reset(ctx context.Context, key string) error
+}
+
+var (
+	store  Store = NewMemoryStore()
+	limits       = DefaultConfig()
+)
+
+// DefaultConfig returns the limits of the default configuration, used before Init.
+func DefaultConfig() Config {
+	return configOf(config.Default().RateLimit)
+}
+
+// Init configures the limits with the settings of the configuration, and
+// keeps the state in the Redis server of its URL, or in memory when it is empty.
+func Init(cfg config.RateLimitConfig) error {
+	limits = configOf(cfg)
+	if cfg.RedisURL == "" {
+		store = NewMemoryStore()
+		return nil
+	}
+	redisStore, err := NewRedisStore(cfg.RedisURL)
+	if err != nil {
+		return err
+	}
+	store = redisStore
+	return nil
+}
+
+func configOf(cfg config.RateLimitConfig) Config {
+	return Config{
+		IP:      Limit{PerMinute: cfg.IPPerMinute, Burst: cfg.IPBurst},
+		Account: Limit{PerMinute: cfg.AccountPerMinute, Burst: cfg.AccountBurst},
+		Lockout: Lockout{
+			Threshold:   cfg.LockoutThreshold,
+			Duration:    time.Duration(cfg.LockoutDuration),
+			MaxDuration: time.Duration(cfg.LockoutMaxDuration),
+			Window:      time.Duration(cfg.LockoutWindow),
+		},
+	}
+}
+
+// GetStore returns the store used by the handlers.
+func GetStore() Store {
+	return store
+}
+
+// SetStore replaces the store used by the handlers, mainly for tests.
+func SetStore(s Store) {
+	store = s
+}
+
+// GetConfig returns the limits used by the handlers.
+func GetConfig() Config {
+	return limits
+}
+
+// SetConfig replaces the limits used by the handlers, mainly for tests.
+func SetConfig(c Config) {
+	limits = c
+}
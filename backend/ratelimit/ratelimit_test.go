@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore checks the behaviour shared by every store.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	limit := Limit{PerMinute: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Allow(ctx, "ip:login:192.0.2.1", limit)
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter, err := store.Allow(ctx, "ip:login:192.0.2.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, 50*time.Second)
+	assert.LessOrEqual(t, retryAfter, time.Minute)
+
+	// buckets are independent
+	allowed, _, err = store.Allow(ctx, "ip:login:192.0.2.2", limit)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+
+	for i := int64(1); i <= 3; i++ {
+		count, err := store.AddFailure(ctx, "account:default:user", time.Hour)
+		assert.Nil(t, err)
+		assert.Equal(t, i, count)
+	}
+
+	lockedFor, err := store.LockedFor(ctx, "account:default:user")
+	assert.Nil(t, err)
+	assert.Zero(t, lockedFor)
+	assert.Nil(t, store.Lock(ctx, "account:default:user", time.Minute))
+	lockedFor, err = store.LockedFor(ctx, "account:default:user")
+	assert.Nil(t, err)
+	assert.Greater(t, lockedFor, 50*time.Second)
+
+	assert.Nil(t, store.Reset(ctx, "account:default:user"))
+	lockedFor, err = store.LockedFor(ctx, "account:default:user")
+	assert.Nil(t, err)
+	assert.Zero(t, lockedFor)
+	count, err := store.AddFailure(ctx, "account:default:user", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testStore(t, store)
+
+	// the bucket is refilled with time, and the failures forgotten
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := Limit{PerMinute: 60, Burst: 1}
+	allowed, _, _ := store.Allow(context.Background(), "refill", limit)
+	assert.True(t, allowed)
+	allowed, retryAfter, _ := store.Allow(context.Background(), "refill", limit)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+	now = now.Add(time.Second)
+	allowed, _, _ = store.Allow(context.Background(), "refill", limit)
+	assert.True(t, allowed)
+
+	store.AddFailure(context.Background(), "forgotten", time.Minute)
+	now = now.Add(2 * time.Minute)
+	count, _ := store.AddFailure(context.Background(), "forgotten", time.Minute)
+	assert.Equal(t, int64(1), count)
+
+	// unlimited buckets always allow
+	allowed, _, _ = store.Allow(context.Background(), "unlimited", Limit{})
+	assert.True(t, allowed)
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://" + server.Addr())
+	assert.Nil(t, err)
+	testStore(t, store)
+
+	server.FastForward(2 * time.Hour)
+	count, err := store.AddFailure(context.Background(), "account:default:user", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = NewRedisStore("redis://127.0.0.1:1")
+	assert.NotNil(t, err)
+}
+
+func TestLockout(t *testing.T) {
+	lockout := Lockout{Threshold: 3, Duration: time.Minute, MaxDuration: 10 * time.Minute}
+	assert.Zero(t, lockout.For(2))
+	assert.Equal(t, time.Minute, lockout.For(3))
+	assert.Equal(t, 2*time.Minute, lockout.For(4))
+	assert.Equal(t, 8*time.Minute, lockout.For(6))
+	assert.Equal(t, 10*time.Minute, lockout.For(7))
+	assert.Equal(t, 10*time.Minute, lockout.For(100))
+	assert.Zero(t, Lockout{}.For(100))
+}
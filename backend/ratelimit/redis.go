@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "ratelimit:"
+
+// takeToken refills the bucket KEYS[1] for the time elapsed since it was last
+// used and takes a token. It returns whether a token was taken and otherwise
+// the milliseconds until the next one.
+var takeToken = redis.NewScript(`
+local perMillisecond = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(now - updated, 0) * perMillisecond)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / perMillisecond)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / perMillisecond) + 1)
+return {allowed, wait}
+`)
+
+// RedisStore keeps the state in a Redis server, or any server speaking its
+// protocol, so that every instance of the API shares it. The buckets are
+// refilled with the clocks of the instances, which must be in sync.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore connects to the server of url, e.g. redis://localhost:6379/0.
+func NewRedisStore(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if limit.PerMinute <= 0 {
+		return true, 0, nil
+	}
+	result, err := takeToken.Run(ctx, s.client, []string{redisKeyPrefix + "bucket:" + key},
+		limit.PerMinute/60000, max(limit.Burst, 1), time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (s *RedisStore) AddFailure(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	failuresKey := redisKeyPrefix + "failures:" + key
+	pipe := s.client.TxPipeline()
+	count := pipe.Incr(ctx, failuresKey)
+	pipe.PExpire(ctx, failuresKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	return s.client.Set(ctx, redisKeyPrefix+"lock:"+key, 1, d).Err()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, redisKeyPrefix+"lock:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// negative when the key does not exist or never expires
+	return max(ttl, 0), nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisKeyPrefix+"failures:"+key, redisKeyPrefix+"lock:"+key).Err()
+}
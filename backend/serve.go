@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"document-manager/config"
+	"document-manager/database"
+	"document-manager/metrics"
+	"document-manager/tlsserver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve answers the requests with handler, over HTTPS and HTTP/2 when TLS is
+// configured, until SIGINT or SIGTERM. It then stops accepting connections
+// and gives the requests in flight, the uploads included,
+// server.shutdown_timeout to finish, before flushing the spans with
+// shutdownTracing and closing the database.
+func serve(cfg *config.Config, handler http.Handler, shutdownTracing func(context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	servers := []*http.Server{{Addr: cfg.Server.Address, Handler: handler}}
+	if cfg.TLS.Enabled() {
+		certificates, err := tlsserver.New(cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("configuring TLS: %w", err)
+		}
+		servers[0].TLSConfig = certificates.TLSConfig()
+		servers[0].Handler = tlsserver.HSTS(time.Duration(cfg.TLS.HSTSMaxAge), handler)
+		if cfg.TLS.RedirectAddress != "" {
+			servers = append(servers, &http.Server{Addr: cfg.TLS.RedirectAddress, Handler: certificates.RedirectHandler(cfg.Server.Address)})
+			slog.Info("HTTP redirected to HTTPS", "address", cfg.TLS.RedirectAddress)
+		}
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Address != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+		servers = append(servers, &http.Server{Addr: cfg.Metrics.Address, Handler: mux})
+		slog.Info("Metrics served", "url", "http://"+cfg.Metrics.Address+"/metrics")
+	}
+
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("serving %s: %w", server.Addr, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-failed:
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	}
+	stop()
+
+	timeout := time.Duration(cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("draining the requests of %s: %w", server.Addr, shutdownErr))
+		}
+	}
+	if tracingErr := shutdownTracing(shutdownCtx); tracingErr != nil {
+		err = errors.Join(err, fmt.Errorf("flushing the spans: %w", tracingErr))
+	}
+	if closeErr := database.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("closing the database: %w", closeErr))
+	}
+	return err
+}
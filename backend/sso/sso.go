@@ -0,0 +1,189 @@
+// Package sso implements OpenID Connect single sign-on with the authorization
+// code flow and PKCE.
+package sso
+
+import (
+	"context"
+	"document-manager/config"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Config describes the OpenID Connect client registered at the identity provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// GroupsClaim is the ID token claim listing the groups of the user.
+	GroupsClaim string
+	// MasterGroup, when set, makes members of the group master users and
+	// everybody else regular users.
+	MasterGroup string
+}
+
+// Identity is the user authenticated by the identity provider.
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
+
+// Provider is an OpenID Connect identity provider ready to log users in.
+type Provider struct {
+	config   Config
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var ErrNonceMismatch = errors.New("sso: nonce does not match")
+
+// NewProvider discovers the identity provider at config.Issuer.
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.ClientID == "" {
+		return nil, errors.New("sso: client ID is required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       config.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the identity provider the user is sent to.
+// verifier is the PKCE code verifier kept by the caller until the callback.
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange trades the authorization code for tokens and returns the identity
+// found in the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("sso: token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+
+	return identity, nil
+}
+
+// MasterFromGroups maps the groups of the identity to the master flag. The
+// second result is false when no master group is configured, in which case
+// the flag of the user must be left alone.
+func (p *Provider) MasterFromGroups(identity *Identity) (bool, bool) {
+	if p.config.MasterGroup == "" {
+		return false, false
+	}
+	for _, group := range identity.Groups {
+		if group == p.config.MasterGroup {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+var provider *Provider
+
+// InitProvider configures single sign-on with the settings of the
+// configuration. It returns nil when it is disabled.
+func InitProvider(ctx context.Context, cfg config.SSOConfig) (*Provider, error) {
+	if cfg.Issuer == "" {
+		log.Println("sso.issuer is not set, single sign-on is disabled")
+		return nil, nil
+	}
+
+	p, err := NewProvider(ctx, Config{
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+		GroupsClaim:  cfg.GroupsClaim,
+		MasterGroup:  cfg.MasterGroup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	provider = p
+	return provider, nil
+}
+
+// GetProvider returns the configured identity provider, or nil when single sign-on is disabled.
+func GetProvider() *Provider {
+	return provider
+}
+
+// SetProvider replaces the identity provider, mainly for tests.
+func SetProvider(p *Provider) {
+	provider = p
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
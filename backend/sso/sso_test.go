@@ -0,0 +1,98 @@
+package sso
+
+import (
+	"context"
+	"document-manager/sso/ssotest"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// authorize follows the login at the mock provider and returns the code sent to the redirect URL.
+func authorize(t *testing.T, authCodeURL string) string {
+	resp, err := noRedirectClient.Get(authCodeURL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "state", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func newTestProvider(t *testing.T) (*ssotest.Server, *Provider) {
+	server := ssotest.NewServer("document-manager")
+	t.Cleanup(server.Close)
+
+	provider, err := NewProvider(context.Background(), Config{
+		Issuer:      server.URL,
+		ClientID:    "document-manager",
+		RedirectURL: "http://localhost:3450/api/sso/callback",
+		MasterGroup: "admins",
+	})
+	assert.Nil(t, err)
+	return server, provider
+}
+
+func TestProviderExchange(t *testing.T) {
+	server, provider := newTestProvider(t)
+	server.SetUser(ssotest.User{
+		Subject:           "subject-1",
+		Email:             "john@example.com",
+		EmailVerified:     true,
+		Name:              "John",
+		PreferredUsername: "john",
+		Groups:            []string{"staff", "admins"},
+	})
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL, identity.Issuer)
+	assert.Equal(t, "subject-1", identity.Subject)
+	assert.Equal(t, "john@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "john", identity.PreferredUsername)
+	assert.Equal(t, []string{"staff", "admins"}, identity.Groups)
+
+	isMaster, mapped := provider.MasterFromGroups(identity)
+	assert.True(t, isMaster)
+	assert.True(t, mapped)
+}
+
+func TestProviderExchangeRejectsWrongVerifierAndNonce(t *testing.T) {
+	server, provider := newTestProvider(t)
+	server.SetUser(ssotest.User{Subject: "subject-2"})
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+	_, err := provider.Exchange(context.Background(), code, oauth2.GenerateVerifier(), "nonce")
+	assert.NotNil(t, err)
+
+	code = authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+	_, err = provider.Exchange(context.Background(), code, verifier, "other nonce")
+	assert.Equal(t, ErrNonceMismatch, err)
+}
+
+func TestMasterFromGroupsWithoutMasterGroup(t *testing.T) {
+	provider := &Provider{}
+	_, mapped := provider.MasterFromGroups(&Identity{Groups: []string{"admins"}})
+	assert.False(t, mapped)
+
+	provider.config.MasterGroup = "admins"
+	isMaster, mapped := provider.MasterFromGroups(&Identity{Groups: []string{"staff"}})
+	assert.False(t, isMaster)
+	assert.True(t, mapped)
+}
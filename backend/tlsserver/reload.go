@@ -0,0 +1,88 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval is how often the handshakes check whether the certificate
+// files changed.
+const reloadInterval = 10 * time.Second
+
+// certReloader serves the certificate of its files, and reloads them once
+// they change, such as when renewed. A certificate that fails to load, like
+// one whose key is not written yet, is retried while the previous one is
+// kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: reloadInterval}
+	modified, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modified); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the certificate, reloaded if the files changed
+// since the last check.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.interval {
+		r.checked = time.Now()
+		modified, err := r.modTime()
+		if err == nil && !modified.Equal(r.modified) {
+			err = r.load(modified)
+			if err == nil {
+				slog.Info("Reloaded the TLS certificate", "file", r.certFile)
+			}
+		}
+		if err != nil {
+			slog.Error("Error reloading the TLS certificate, the previous one is served", "error", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// modTime returns when the latest of the two files changed.
+func (r *certReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading the TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load(modified time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading the TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modified = modified
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package tlsserver serves the API over HTTPS and HTTP/2, with a certificate
+// read from files, reloaded when they change, or obtained from an ACME
+// certificate authority such as Let's Encrypt. It also sends HSTS and
+// redirects the HTTP requests to HTTPS.
+package tlsserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"document-manager/config"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Manager provides the certificates of the server.
+type Manager struct {
+	tlsConfig *tls.Config
+	acme      *autocert.Manager
+}
+
+// New reads the certificate files of cfg, or prepares the ACME account that
+// obtains the certificates of its domains on the first handshakes.
+func New(cfg config.TLSConfig) (*Manager, error) {
+	if len(cfg.ACMEDomains) > 0 {
+		return newACME(cfg)
+	}
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{tlsConfig: &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: reloader.GetCertificate,
+	}}, nil
+}
+
+func newACME(cfg config.TLSConfig) (*Manager, error) {
+	client := &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+	if cfg.ACMECAFile != "" {
+		pem, err := os.ReadFile(cfg.ACMECAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the CAs of the ACME directory: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("reading the CAs of the ACME directory: no PEM certificate in " + cfg.ACMECAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		Email:      cfg.ACMEEmail,
+		Client:     client,
+	}
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return &Manager{tlsConfig: tlsConfig, acme: manager}, nil
+}
+
+// TLSConfig returns the configuration of the HTTPS listener, which
+// negotiates HTTP/2 with the clients that support it.
+func (m *Manager) TLSConfig() *tls.Config {
+	return m.tlsConfig
+}
+
+// RedirectHandler redirects the HTTP requests to the HTTPS listener of
+// httpsAddress. With ACME it first answers the HTTP-01 challenges.
+func (m *Manager) RedirectHandler(httpsAddress string) http.Handler {
+	redirect := Redirect(httpsAddress)
+	if m.acme != nil {
+		return m.acme.HTTPHandler(redirect)
+	}
+	return redirect
+}
+
+// Redirect redirects the requests to the same URL over HTTPS, on the port of
+// httpsAddress, keeping their method.
+func Redirect(httpsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// HSTS sends the Strict-Transport-Security header of maxAge, so that the
+// browsers stick to HTTPS, unless maxAge is zero.
+func HSTS(maxAge time.Duration, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,142 @@
+package tlsserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"document-manager/config"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+// writeCertificate writes a self-signed certificate of commonName for
+// localhost and its key.
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+	reloader.interval = 0
+	cert, err := reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	// a broken pair keeps the previous certificate
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	cert, err = reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	writeCertificate(t, certFile, keyFile, "renewed")
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	cert, err = reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "renewed", commonName(t, cert))
+
+	_, err = newCertReloader(filepath.Join(directory, "missing.pem"), keyFile)
+	assert.ErrorContains(t, err, "reading the TLS certificate")
+}
+
+func TestHTTP2AndHSTS(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	writeCertificate(t, certFile, keyFile, "localhost")
+	manager, err := New(config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	assert.Nil(t, err)
+
+	server := httptest.NewUnstartedServer(HSTS(24*time.Hour, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})))
+	server.TLS = manager.TLSConfig()
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{ServerName: "localhost", InsecureSkipVerify: true}
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "max-age=86400", resp.Header.Get("Strict-Transport-Security"))
+	assert.Equal(t, "localhost", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestRedirect(t *testing.T) {
+	redirects := []struct{ address, host, target string }{
+		{"0.0.0.0:443", "docs.example.com", "https://docs.example.com/api/documents?page=2"},
+		{"0.0.0.0:443", "docs.example.com:80", "https://docs.example.com/api/documents?page=2"},
+		{"0.0.0.0:3450", "localhost:8080", "https://localhost:3450/api/documents?page=2"},
+		{"[::]:3450", "[::1]:8080", "https://[::1]:3450/api/documents?page=2"},
+	}
+	for _, redirect := range redirects {
+		req := httptest.NewRequest("POST", "/api/documents?page=2", nil)
+		req.Host = redirect.host
+		resp := httptest.NewRecorder()
+		Redirect(redirect.address).ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusPermanentRedirect, resp.Code)
+		assert.Equal(t, redirect.target, resp.Header().Get("Location"))
+	}
+}
+
+func TestACME(t *testing.T) {
+	manager, err := New(config.TLSConfig{
+		ACMEDomains:   []string{"docs.example.com"},
+		ACMEDirectory: "https://localhost:14000/dir",
+		ACMECacheDir:  t.TempDir(),
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, manager.TLSConfig().NextProtos, "h2")
+	assert.Contains(t, manager.TLSConfig().NextProtos, acme.ALPNProto, "the TLS-ALPN-01 challenges are answered")
+	assert.Equal(t, "https://localhost:14000/dir", manager.acme.Client.DirectoryURL)
+
+	// the HTTP-01 challenges are answered before the redirect
+	req := httptest.NewRequest("GET", "/.well-known/acme-challenge/token", nil)
+	req.Host = "docs.example.com"
+	resp := httptest.NewRecorder()
+	manager.RedirectHandler("0.0.0.0:443").ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	_, err = New(config.TLSConfig{ACMEDomains: []string{"docs.example.com"}, ACMECAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "reading the CAs of the ACME directory")
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"document-manager/api/models"
+	"document-manager/config"
+	"document-manager/database"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// TestMain runs the tests on a throwaway SQLite database, unless DB_DRIVER
+// chooses one.
+func TestMain(m *testing.M) {
+	if os.Getenv("DB_DRIVER") != "" {
+		os.Exit(m.Run())
+	}
+	directory, err := os.MkdirTemp("", "document-manager-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DB_DRIVER", config.DriverSQLite)
+	os.Setenv("DB_PATH", filepath.Join(directory, "test.db"))
+	code := m.Run()
+	os.RemoveAll(directory)
+	os.Exit(code)
+}
+
+// record records the spans ended from now on.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	_, err := Init(context.Background(), config.TracingConfig{Exporter: config.TracingNone})
+	assert.Nil(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+	router := gin.New()
+	router.Use(Middleware)
+	router.GET("/api/documents/:id", func(c *gin.Context) {
+		_, span := StartStorage(c.Request.Context(), "read", "documents/1")
+		End(span, errors.New("missing"))
+		c.Status(http.StatusInternalServerError)
+	})
+
+	request, _ := http.NewRequest("GET", "/api/documents/1", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, request)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	storage, server := spans[0], spans[1]
+	assert.Equal(t, "GET /api/documents/:id", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String(), "the trace of the caller goes on")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Contains(t, server.Attributes(), semconv.HTTPResponseStatusCode(http.StatusInternalServerError))
+	assert.Equal(t, codes.Error, server.Status().Code)
+	assert.Contains(t, resp.Header().Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	assert.Equal(t, "storage.read", storage.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), storage.Parent().SpanID())
+	assert.Equal(t, codes.Error, storage.Status().Code)
+}
+
+func TestGormPlugin(t *testing.T) {
+	db, err := database.InitDB()
+	assert.Nil(t, err)
+	_, err = database.Migrate()
+	assert.Nil(t, err)
+	assert.Nil(t, database.InitTenants(config.Get().Tenants))
+	assert.Nil(t, db.Use(GormPlugin{}))
+	recorder := record(t)
+
+	// outside of a trace, nothing is recorded
+	var tenants []models.Tenant
+	assert.Nil(t, db.WithContext(database.AllTenants(context.Background())).Find(&tenants).Error)
+	assert.Empty(t, recorder.Ended())
+
+	ctx, span := Start(database.AllTenants(context.Background()), "test")
+	assert.Nil(t, db.WithContext(ctx).Where("slug = ?", "secret-slug").Find(&tenants).Error)
+	span.End()
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	query := spans[0]
+	assert.Equal(t, "db.select", query.Name())
+	assert.Equal(t, span.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Contains(t, query.Attributes(), semconv.DBSystemKey.String("sqlite"))
+	var text string
+	for _, attribute := range query.Attributes() {
+		if attribute.Key == semconv.DBQueryTextKey {
+			text = attribute.Value.AsString()
+		}
+	}
+	assert.Contains(t, text, "tenants")
+	assert.NotContains(t, text, "secret-slug", "the values are left out")
+}
@@ -3,9 +3,11 @@ export interface Document {
   title: string;
   owner_id: string;
   owner_name: string;
+  workspace_id: string;
   createdAt: string;
   updatedAt: string;
   deletedAt: string;
   description: string;
   filepath: string;
+  file_size: number;
 }
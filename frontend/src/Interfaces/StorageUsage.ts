@@ -0,0 +1,12 @@
+export interface Usage {
+  used_bytes: number;
+  files: number;
+  max_bytes: number;
+  max_files: number;
+}
+
+export interface StorageUsage {
+  user: Usage;
+  workspace: Usage;
+  max_file_bytes: number;
+}
@@ -1,6 +1,6 @@
 export interface User {
   id: string;
-  master: boolean;
+  roles: string[];
   email: string;
   name: string;
   access_token: string;
@@ -2,6 +2,7 @@ import React, { useState } from "react";
 import logo from "../../logo.png";
 import { Link, Navigate } from "react-router-dom";
 import axios from "../../utils/axios";
+import { isAxiosError } from "axios";
 import { useAppDispatch, useAppSelector } from "../../store/store";
 import { setUser } from "../../store/userSlice";
 
@@ -32,6 +33,13 @@ export default function Login() {
         }),
       );
     } catch (error) {
+      if (isAxiosError(error) && error.response?.status === 429) {
+        const retryAfter = Number(error.response.headers["retry-after"]);
+        setError(
+          `Too many attempts, try again in ${Math.ceil(retryAfter / 60)} minute(s)`,
+        );
+        return;
+      }
       setError("Invalid username/email or password");
     }
   };
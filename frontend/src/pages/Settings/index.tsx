@@ -1,18 +1,70 @@
 import { useNavigate } from "react-router-dom";
 import { useAppSelector } from "../../store/store";
-import { useEffect } from "react";
+import { useEffect, useState } from "react";
 import SideBar from "../../Components/SideBar";
+import axios from "../../utils/axios";
+import { StorageUsage, Usage } from "../../Interfaces/StorageUsage";
+
+function formatBytes(bytes: number) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let unit = 0;
+  while (bytes >= 1024 && unit < units.length - 1) {
+    bytes /= 1024;
+    unit++;
+  }
+  return `${bytes.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+}
+
+function UsageBar({ label, usage }: { label: string; usage: Usage }) {
+  // zero means unlimited
+  const percent =
+    usage.max_bytes > 0
+      ? Math.min(100, (usage.used_bytes / usage.max_bytes) * 100)
+      : 0;
+  return (
+    <div className="my-4">
+      <p>
+        {label}: {formatBytes(usage.used_bytes)}
+        {usage.max_bytes > 0 ? ` of ${formatBytes(usage.max_bytes)}` : ""},{" "}
+        {usage.files}
+        {usage.max_files > 0 ? ` of ${usage.max_files}` : ""} files
+      </p>
+      {usage.max_bytes > 0 && (
+        <div className="w-full h-2 bg-slate-700 rounded">
+          <div
+            className="h-2 bg-sky-500 rounded"
+            style={{ width: `${percent}%` }}
+          ></div>
+        </div>
+      )}
+    </div>
+  );
+}
 
 export default function Setttings() {
   const navigate = useNavigate();
 
   const user = useAppSelector((state) => state.userState.user);
+  const [storage, setStorage] = useState<StorageUsage | null>(null);
 
   useEffect(() => {
     if (!user) {
       navigate("/login", { replace: true });
       return;
     }
+    const getStorage = async () => {
+      try {
+        const response = await axios.get<StorageUsage>("/storage/usage", {
+          headers: {
+            Authorization: user!.access_token,
+          },
+        });
+        setStorage(response.data);
+      } catch (error) {
+        console.error(error);
+      }
+    };
+    getStorage();
   }, [user, navigate]);
 
   return (
@@ -47,7 +99,16 @@ export default function Setttings() {
             <circle cx="65.3" cy="36.9" r="11.4"></circle>{" "}
           </g>
         </svg>
-        <p>working in progress</p>
+        {storage && (
+          <div className="mx-auto w-80 text-left">
+            <h2 className="text-xl">Storage</h2>
+            <UsageBar label="Your documents" usage={storage.user} />
+            <UsageBar label="Workspace" usage={storage.workspace} />
+            {storage.max_file_bytes > 0 && (
+              <p>Maximum file size: {formatBytes(storage.max_file_bytes)}</p>
+            )}
+          </div>
+        )}
       </div>
     </div>
   );
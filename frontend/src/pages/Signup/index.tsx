@@ -1,9 +1,11 @@
 import React, { useState } from "react";
 import logo from "../../logo.png";
-import { Link } from "react-router-dom";
+import { Link, useSearchParams } from "react-router-dom";
 import axios from "../../utils/axios";
 
 export default function Signup() {
+  const [searchParams] = useSearchParams();
+  const inviteToken = searchParams.get("invite_token") ?? "";
   const [email, setEmail] = useState("");
   const [emailValid, setEmailValid] = useState(true);
   const [name, setName] = useState("");
@@ -17,6 +19,7 @@ export default function Signup() {
   const [requestSuccess, setRequestSuccess] = useState<Boolean | undefined>(
     undefined,
   );
+  const [pendingApproval, setPendingApproval] = useState(false);
 
   const handleShowPassword = () => {
     setShowPassword(!showPassword);
@@ -42,15 +45,25 @@ export default function Signup() {
       passwordsMatchValidate
     ) {
       try {
-        await axios.post(`/users`, {
+        const response = await axios.post(`/users`, {
           email,
           name,
           password,
+          invite_token: inviteToken,
         });
+        setPendingApproval(response.data.status === "pending");
         setRequestSuccess(true);
         setError(null);
       } catch (error: any) {
-        setError(error.response.data.error);
+        const { detail, errors } = error.response.data;
+        const violations = (errors ?? [])
+          .filter((fieldError: any) => fieldError.field === "password")
+          .map((fieldError: any) => fieldError.message);
+        setError(
+          violations.length > 0
+            ? `${detail}: password ${violations.join(", ")}`
+            : detail,
+        );
         setRequestSuccess(false);
       }
     }
@@ -217,6 +230,8 @@ export default function Signup() {
             <span className="block sm:inline">
               {" "}
               User {name} created with success
+              {pendingApproval &&
+                ", you can log in once an administrator approves it"}
             </span>
           </div>
         )}